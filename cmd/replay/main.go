@@ -0,0 +1,385 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// replay rebuilds the order book and the logs of a product offline from the order topic, starting from a chosen
+// snapshot, and reports the first sequence where the rebuilt logs diverge from the logs in Kafka.
+//
+//	replay -product BTC-USDT -snapshot engine -out ./replay
+//
+// The orders are exported from Kafka into out/orders.jsonl unless the file exists, the rebuilt logs are written
+// into out/logs.jsonl and the final snapshot into out/snapshots. The logs in Kafka are exported into
+// out/kafka-logs.jsonl to be compared with the rebuilt logs.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/conf"
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/service"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+func main() {
+	productId := flag.String("product", "", "id of the product to replay")
+	snapshotPath := flag.String("snapshot", "",
+		"snapshot directory to start from, \"engine\" for the latest snapshot of the engine, empty for the beginning")
+	outDir := flag.String("out", "replay", "directory of the orders, the rebuilt logs and the final snapshot")
+	compare := flag.Bool("compare", true, "compare the rebuilt logs with the logs in Kafka")
+	logOffset := flag.Int64("log-offset", 0, "offset of the log topic to start comparing from")
+	ignoreTime := flag.Bool("ignore-time", false, "ignore the time of the logs, e.g. for logs written by older versions")
+	flag.Parse()
+
+	err := run(*productId, *snapshotPath, *outDir, *compare, *logOffset, *ignoreTime)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(productId, snapshotPath, outDir string, compare bool, logOffset int64, ignoreTime bool) error {
+	if len(productId) == 0 {
+		return errors.New("product is required")
+	}
+	brokers := conf.GetConfig().Kafka.Brokers
+
+	product, err := service.GetProductById(productId)
+	if err != nil {
+		return err
+	}
+	if product == nil {
+		return fmt.Errorf("product not found: %v", productId)
+	}
+
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return err
+	}
+	ordersPath := filepath.Join(outDir, "orders.jsonl")
+	logsPath := filepath.Join(outDir, "logs.jsonl")
+
+	// the chosen snapshot is copied into the output snapshot store, the engine is restored from it and the
+	// final snapshot replaces it
+	snapshot, err := loadSnapshot(productId, snapshotPath)
+	if err != nil {
+		return err
+	}
+	snapshotStore := matching.NewFileSnapshotStore(filepath.Join(outDir, "snapshots"), 1)
+	var orderOffset, logSeq int64
+	if snapshot != nil {
+		orderOffset, logSeq = snapshot.OrderOffset+1, snapshot.OrderBookSnapshot.LogSeq
+		err = snapshotStore.Store(snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(ordersPath); os.IsNotExist(err) {
+		count, err := exportOrders(brokers, productId, orderOffset, ordersPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v orders exported from offset %v\n", count, orderOffset)
+	}
+
+	err = os.Remove(logsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	orderReader, err := matching.NewFileOrderReader(ordersPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = orderReader.Close() }()
+	logStore, err := matching.NewFileLogStore(logsPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = logStore.Close() }()
+
+	engine := matching.NewEngine(product, orderReader, logStore, snapshotStore)
+	lastOffset, err := engine.Replay()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replayed until order offset %v\n", lastOffset)
+
+	if !compare {
+		return nil
+	}
+
+	kafkaLogsPath := filepath.Join(outDir, "kafka-logs.jsonl")
+	count, err := exportLogs(brokers, productId, logOffset, kafkaLogsPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v logs exported from offset %v\n", count, logOffset)
+
+	kafkaLogs, err := os.Open(kafkaLogsPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = kafkaLogs.Close() }()
+	rebuiltLogs, err := os.Open(logsPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rebuiltLogs.Close() }()
+
+	result, err := compareLogs(kafkaLogs, rebuiltLogs, logSeq, ignoreTime)
+	if err != nil {
+		return err
+	}
+	result.report(os.Stdout)
+	return nil
+}
+
+func loadSnapshot(productId, snapshotPath string) (*matching.Snapshot, error) {
+	switch snapshotPath {
+	case "":
+		return nil, nil
+	case "engine":
+		return matching.NewSnapshotStore(productId).GetLatest()
+	default:
+		snapshot, err := matching.NewFileSnapshotStore(snapshotPath, 1).GetLatest()
+		if err == nil && snapshot == nil {
+			err = fmt.Errorf("snapshot not found: %v", snapshotPath)
+		}
+		return snapshot, err
+	}
+}
+
+// exportOrders saves the messages of the order topic from the offset to the current end of the topic
+func exportOrders(brokers []string, productId string, offset int64, path string) (int, error) {
+	topic := matching.TopicOrderPrefix + productId
+	lastOffset, err := readLastOffset(brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := matching.NewFileOrderWriter(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = readTopic(brokers, topic, offset, lastOffset, func(message kafka.Message) error {
+		count++
+		return writer.Write(message.Offset, message.Value)
+	})
+	if err != nil {
+		_ = writer.Close()
+		return count, err
+	}
+	return count, writer.Close()
+}
+
+// exportLogs saves the logs of the log topic from the offset to the current end of the topic, one log per line
+func exportLogs(brokers []string, productId string, offset int64, path string) (int, error) {
+	topic := matching.TopicBookMessagePrefix + productId
+	lastOffset, err := readLastOffset(brokers, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(file)
+
+	var count int
+	err = readTopic(brokers, topic, offset, lastOffset, func(message kafka.Message) error {
+		count++
+		_, err := writer.Write(append(message.Value, '\n'))
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		_ = file.Close()
+		return count, err
+	}
+	return count, file.Close()
+}
+
+// comparison is the result of comparing the logs in Kafka with the rebuilt logs
+type comparison struct {
+	// number of the logs which are the same
+	compared int64
+
+	// the first sequence where the logs diverge, 0 if they don't
+	divergedSeq int64
+	expected    []byte
+	actual      []byte
+
+	// last sequence of the logs in Kafka which is compared
+	lastSeq int64
+	// the rebuilt logs end before, or continue after the logs in Kafka
+	rebuiltEnded     bool
+	rebuiltContinues bool
+}
+
+func (c *comparison) report(out io.Writer) {
+	if c.divergedSeq != 0 {
+		_, _ = fmt.Fprintf(out, "first diverging sequence: %v\nkafka:   %s\nreplay:  %s\n", c.divergedSeq,
+			bytes.TrimSpace(c.expected), bytes.TrimSpace(c.actual))
+		return
+	}
+
+	_, _ = fmt.Fprintf(out, "no divergence in %v logs\n", c.compared)
+	if c.rebuiltEnded {
+		_, _ = fmt.Fprintf(out, "the rebuilt logs end before sequence %v of kafka\n", c.lastSeq)
+	} else if c.rebuiltContinues {
+		_, _ = fmt.Fprintf(out, "kafka ends at sequence %v, the rebuilt logs continue\n", c.lastSeq)
+	}
+}
+
+// compareLogs compares the logs exported from Kafka with the rebuilt logs sequence by sequence, one log per line.
+// The logs in Kafka up to the sequence of the snapshot are skipped, and so are the duplicated ones written again
+// by an engine which restarts from an older snapshot.
+func compareLogs(kafkaLogs, rebuiltLogs io.Reader, logSeq int64, ignoreTime bool) (*comparison, error) {
+	kafkaReader := bufio.NewReader(kafkaLogs)
+	rebuiltReader := bufio.NewReader(rebuiltLogs)
+	result := &comparison{lastSeq: logSeq}
+
+	for {
+		value, err := readLine(kafkaReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		expected, seq, err := decodeLog(value, ignoreTime)
+		if err != nil {
+			return nil, err
+		}
+		if seq <= result.lastSeq {
+			continue
+		}
+		result.lastSeq = seq
+
+		line, err := readLine(rebuiltReader)
+		if err == io.EOF {
+			result.rebuiltEnded = true
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		actual, _, err := decodeLog(line, ignoreTime)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			result.divergedSeq, result.expected, result.actual = seq, value, line
+			return result, nil
+		}
+		result.compared++
+	}
+
+	_, err := readLine(rebuiltReader)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	result.rebuiltContinues = err == nil
+	return result, nil
+}
+
+// readLine reads the next non-empty line, the last line may have no '\n' at the end
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeLog decodes a log into a generic map for comparison, and returns its sequence
+func decodeLog(value []byte, ignoreTime bool) (map[string]interface{}, int64, error) {
+	var base matching.Base
+	err := json.Unmarshal(value, &base)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var log map[string]interface{}
+	err = json.Unmarshal(value, &log)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ignoreTime {
+		delete(log, "Time")
+	}
+	return log, base.Sequence, nil
+}
+
+func readLastOffset(brokers []string, topic string) (int64, error) {
+	conn, err := kafka.DialLeader(context.Background(), "tcp", brokers[0], topic, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.ReadLastOffset()
+}
+
+// readTopic calls fn with the messages of the topic from the offset until the last offset (exclusive)
+func readTopic(brokers []string, topic string, offset, lastOffset int64, fn func(message kafka.Message) error) error {
+	if offset >= lastOffset {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   brokers,
+		Topic:     topic,
+		Partition: 0,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+	defer func() { _ = reader.Close() }()
+
+	err := reader.SetOffset(offset)
+	if err != nil {
+		return err
+	}
+	for {
+		message, err := reader.FetchMessage(context.Background())
+		if err != nil {
+			return err
+		}
+		err = fn(message)
+		if err != nil {
+			return err
+		}
+		if message.Offset >= lastOffset-1 {
+			return nil
+		}
+	}
+}
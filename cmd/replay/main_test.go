@@ -0,0 +1,135 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLogs returns the logs of the sequences one per line, a sequence with a '*' has another price
+func testLogs(seqs ...string) string {
+	var buf bytes.Buffer
+	for _, seq := range seqs {
+		price := "100"
+		if strings.HasSuffix(seq, "*") {
+			seq, price = strings.TrimSuffix(seq, "*"), "101"
+		}
+		_, _ = fmt.Fprintf(&buf, `{"Type":"open","Sequence":%v,"ProductId":"BTC-USDT","Time":"2019-01-01T00:00:%02vZ",`+
+			`"OrderId":%v,"Price":"%v"}`+"\n", seq, seq, seq, price)
+	}
+	return buf.String()
+}
+
+func TestCompareLogs(t *testing.T) {
+	tests := []struct {
+		name        string
+		kafkaLogs   string
+		rebuiltLogs string
+		logSeq      int64
+		ignoreTime  bool
+		want        comparison
+	}{
+		{
+			name:        "same logs",
+			kafkaLogs:   testLogs("1", "2", "3"),
+			rebuiltLogs: testLogs("1", "2", "3"),
+			want:        comparison{compared: 3, lastSeq: 3},
+		},
+		{
+			name:        "first diverging sequence is reported",
+			kafkaLogs:   testLogs("1", "2", "3", "4"),
+			rebuiltLogs: testLogs("1", "2", "3*", "4*"),
+			want:        comparison{compared: 2, divergedSeq: 3, lastSeq: 3},
+		},
+		{
+			name:        "duplicated sequences in kafka are skipped",
+			kafkaLogs:   testLogs("1", "2", "2", "1", "3"),
+			rebuiltLogs: testLogs("1", "2", "3"),
+			want:        comparison{compared: 3, lastSeq: 3},
+		},
+		{
+			name:        "duplicated sequence is skipped even if it differs",
+			kafkaLogs:   testLogs("1", "2", "2*", "3"),
+			rebuiltLogs: testLogs("1", "2", "3"),
+			want:        comparison{compared: 3, lastSeq: 3},
+		},
+		{
+			name:        "logs up to the sequence of the snapshot are skipped",
+			kafkaLogs:   testLogs("1", "2", "3", "4"),
+			rebuiltLogs: testLogs("3", "4"),
+			logSeq:      2,
+			want:        comparison{compared: 2, lastSeq: 4},
+		},
+		{
+			name:        "rebuilt logs end before kafka",
+			kafkaLogs:   testLogs("1", "2", "3"),
+			rebuiltLogs: testLogs("1", "2"),
+			want:        comparison{compared: 2, lastSeq: 3, rebuiltEnded: true},
+		},
+		{
+			name:        "rebuilt logs continue after kafka",
+			kafkaLogs:   testLogs("1", "2"),
+			rebuiltLogs: testLogs("1", "2", "3"),
+			want:        comparison{compared: 2, lastSeq: 2, rebuiltContinues: true},
+		},
+		{
+			name:        "time is compared unless it is ignored",
+			kafkaLogs:   testLogs("1", "2"),
+			rebuiltLogs: strings.Replace(testLogs("1", "2"), "00:00:02", "00:01:02", 1),
+			want:        comparison{compared: 1, divergedSeq: 2, lastSeq: 2},
+		},
+		{
+			name:        "ignored time",
+			kafkaLogs:   testLogs("1", "2"),
+			rebuiltLogs: strings.Replace(testLogs("1", "2"), "00:00:02", "00:01:02", 1),
+			ignoreTime:  true,
+			want:        comparison{compared: 2, lastSeq: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := compareLogs(strings.NewReader(test.kafkaLogs), strings.NewReader(test.rebuiltLogs),
+				test.logSeq, test.ignoreTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result.expected, result.actual = nil, nil
+			if !reflect.DeepEqual(*result, test.want) {
+				t.Errorf("comparison %+v, want %+v", *result, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareLogsReport(t *testing.T) {
+	result, err := compareLogs(strings.NewReader(testLogs("1", "2")), strings.NewReader(testLogs("1", "2*")), 0,
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	result.report(&out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[0] != "first diverging sequence: 2" ||
+		!strings.Contains(lines[1], `"Price":"100"`) || !strings.Contains(lines[2], `"Price":"101"`) {
+		t.Errorf("unexpected report:\n%v", out.String())
+	}
+}
@@ -24,6 +24,10 @@ type GbeConfig struct {
 	DataSource DataSourceConfig `json:"dataSource"`
 	Redis      RedisConfig      `json:"redis"`
 	Kafka      KafkaConfig      `json:"kafka"`
+	Queue      QueueConfig      `json:"queue"`
+	Snapshot   SnapshotConfig   `json:"snapshot"`
+	Election   ElectionConfig   `json:"election"`
+	Cluster    ClusterConfig    `json:"cluster"`
 	PushServer PushServerConfig `json:"pushServer"`
 	RestServer RestServerConfig `json:"restServer"`
 	JwtSecret  string           `json:"jwtSecret"`
@@ -47,6 +51,53 @@ type KafkaConfig struct {
 	Brokers []string `json:"brokers"`
 }
 
+// QueueConfig selects where the order topics and the log topics are stored, the topics are stored under Dir
+// when the driver is file, and the exchange runs on a single node without Kafka
+type QueueConfig struct {
+	// kafka (default) or file
+	Driver string `json:"driver"`
+	Dir    string `json:"dir"`
+	// size of a segment file, 64MB by default
+	SegmentBytes int64 `json:"segmentBytes"`
+	// sync the segment file after every write
+	Fsync bool `json:"fsync"`
+}
+
+// SnapshotConfig selects where the snapshots of the matching engine are stored
+type SnapshotConfig struct {
+	// redis (default), file or mysql
+	Store string `json:"store"`
+	// directory of the file store, each product is a sub directory
+	Dir string `json:"dir"`
+	// number of the latest snapshots kept by the file and mysql stores, 3 by default
+	Keep int `json:"keep"`
+}
+
+// ElectionConfig runs the matching engines as hot standbys, the leader of each product is elected by a lease
+// in redis, and only the leader writes the logs and the snapshots. It needs the file queue, whose log store
+// rejects the writes of the old leaders. The standbys of a product read and write the same queue directory, so
+// they must run on the same host, or on hosts sharing the disk of the queue directory; a standby on another
+// host without the shared disk can not take over. The snapshots must be shared in the same way, by the redis or
+// the mysql store, or by the file store on the shared disk.
+type ElectionConfig struct {
+	Enabled bool `json:"enabled"`
+	// identifies the node in the lease, the host name by default
+	NodeId string `json:"nodeId"`
+	// ttl of the lease in milliseconds, 5000 by default
+	LeaseMillis int64 `json:"leaseMillis"`
+}
+
+// ClusterConfig distributes the products across the matching nodes, each node only runs the engines and the
+// workers of the products assigned to it. The assignment is static when Assignments is set, otherwise it is read
+// from the product assignment table, where a product can be moved to another node while the nodes are running.
+type ClusterConfig struct {
+	Enabled bool `json:"enabled"`
+	// identifies the node in the assignment, the host name by default
+	NodeId string `json:"nodeId"`
+	// product id -> node id
+	Assignments map[string]string `json:"assignments"`
+}
+
 type PushServerConfig struct {
 	Addr string `json:"addr"`
 	Path string `json:"path"`
@@ -54,6 +105,8 @@ type PushServerConfig struct {
 
 type RestServerConfig struct {
 	Addr string `json:"addr"`
+	// emails of the users allowed to call the admin api
+	Admins []string `json:"admins"`
 }
 
 var config GbeConfig
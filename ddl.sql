@@ -26,6 +26,30 @@ CREATE TABLE `g_bill` (
   KEY `idx_s` (`settled`)
 ) ENGINE=InnoDB AUTO_INCREMENT=12437574 DEFAULT CHARSET=utf8;
 
+CREATE TABLE `g_cancel_timer` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NULL DEFAULT NULL,
+  `updated_at` timestamp NULL DEFAULT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `cancel_time` bigint(20) NOT NULL,
+  `claimed_until` bigint(20) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_uid` (`user_id`),
+  KEY `idx_ct` (`cancel_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `g_cancel_trigger` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NULL DEFAULT NULL,
+  `updated_at` timestamp NULL DEFAULT NULL,
+  `user_id` bigint(20) NOT NULL,
+  `product_id` varchar(255) NOT NULL,
+  `type` varchar(255) NOT NULL,
+  `source` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `idx_uid` (`user_id`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 CREATE TABLE `g_config` (
   `id` bigint(20) NOT NULL AUTO_INCREMENT,
   `created_at` timestamp NULL DEFAULT NULL,
@@ -35,6 +59,19 @@ CREATE TABLE `g_config` (
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=2 DEFAULT CHARSET=utf8;
 
+CREATE TABLE `g_engine_snapshot` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NULL DEFAULT NULL,
+  `updated_at` timestamp NULL DEFAULT NULL,
+  `product_id` varchar(255) NOT NULL,
+  `order_offset` bigint(20) NOT NULL,
+  `log_seq` bigint(20) NOT NULL,
+  `checksum` varchar(64) NOT NULL,
+  `data` longblob NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_pid` (`product_id`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 CREATE TABLE `g_fill` (
   `id` bigint(20) NOT NULL AUTO_INCREMENT,
   `created_at` timestamp NULL DEFAULT NULL,
@@ -51,6 +88,7 @@ CREATE TABLE `g_fill` (
   `side` varchar(255) NOT NULL,
   `done` tinyint(1) NOT NULL DEFAULT '0',
   `done_reason` varchar(255) NOT NULL,
+  `changed` tinyint(1) NOT NULL DEFAULT '0',
   `message_seq` bigint(20) NOT NULL,
   `log_offset` bigint(20) NOT NULL DEFAULT '0',
   `log_seq` bigint(20) NOT NULL DEFAULT '0',
@@ -75,7 +113,25 @@ CREATE TABLE `g_order` (
   `type` varchar(255) NOT NULL,
   `side` varchar(255) NOT NULL,
   `time_in_force` varchar(255) DEFAULT NULL,
+  `expire_time` timestamp NULL DEFAULT NULL,
+  `post_only` tinyint(1) NOT NULL DEFAULT '0',
+  `post_only_slide` tinyint(1) NOT NULL DEFAULT '0',
+  `stop` varchar(255) NOT NULL DEFAULT '',
+  `stop_price` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `display_size` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `trailing_amount` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `trailing_rate` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `hidden` tinyint(1) NOT NULL DEFAULT '0',
+  `min_qty` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `peg` varchar(255) NOT NULL DEFAULT '',
+  `peg_offset` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `peg_cap` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `protection_price` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `self_trade_prevention` varchar(255) NOT NULL DEFAULT '',
+  `group_id` bigint(20) NOT NULL DEFAULT '0',
+  `group_type` varchar(255) NOT NULL DEFAULT '',
   `status` varchar(255) NOT NULL,
+  `done_reason` varchar(255) NOT NULL DEFAULT '',
   `settled` tinyint(1) NOT NULL DEFAULT '0',
   `client_oid` varchar(32) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
@@ -96,9 +152,24 @@ CREATE TABLE `g_product` (
   `quote_increment` double NOT NULL,
   `quote_min_size` decimal(32,16) NOT NULL,
   `quote_max_size` decimal(32,16) NOT NULL,
+  `max_slippage` decimal(32,16) NOT NULL DEFAULT '0.0500000000000000',
+  `status` varchar(255) NOT NULL DEFAULT 'open',
+  `price_band` decimal(32,16) NOT NULL DEFAULT '0.0000000000000000',
+  `band_auction_seconds` bigint(20) NOT NULL DEFAULT '0',
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+CREATE TABLE `g_product_assignment` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `created_at` timestamp NULL DEFAULT NULL,
+  `updated_at` timestamp NULL DEFAULT NULL,
+  `product_id` varchar(255) NOT NULL,
+  `node_id` varchar(255) NOT NULL,
+  `running_node_id` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_pid` (`product_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 CREATE TABLE `g_tick` (
   `id` bigint(20) NOT NULL AUTO_INCREMENT,
   `created_at` timestamp NULL DEFAULT NULL,
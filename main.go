@@ -15,42 +15,40 @@
 package main
 
 import (
-	"github.com/gitbitex/gitbitex-spot/conf"
 	"github.com/gitbitex/gitbitex-spot/matching"
 	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/pushing"
 	"github.com/gitbitex/gitbitex-spot/rest"
-	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/gitbitex/gitbitex-spot/worker"
 	"github.com/prometheus/common/log"
 	"net/http"
 	_ "net/http/pprof"
+	"sync"
 )
 
 func main() {
-	gbeConfig := conf.GetConfig()
-
 	go func() {
 		log.Info(http.ListenAndServe("localhost:6060", nil))
 	}()
 
 	go models.NewBinLogStream().Start()
 
-	matching.StartEngine()
+	// 每个产品的maker运行在运行该产品撮合引擎的节点上，产品迁移到其他节点后，原节点的maker继续跟随log，
+	// maker的写入是幂等的
+	var startedMakers sync.Map
+	matching.StartEngine(func(product *models.Product) {
+		if _, started := startedMakers.LoadOrStore(product.Id, true); started {
+			return
+		}
+		worker.NewTickMaker(product.Id, matching.NewLogReader("tickMaker", product.Id)).Start()
+		worker.NewFillMaker(matching.NewLogReader("fillMaker", product.Id)).Start()
+		worker.NewTradeMaker(matching.NewLogReader("tradeMaker", product.Id)).Start()
+	})
 
 	pushing.StartServer()
 
 	worker.NewFillExecutor().Start()
 	worker.NewBillExecutor().Start()
-	products, err := service.GetProducts()
-	if err != nil {
-		panic(err)
-	}
-	for _, product := range products {
-		worker.NewTickMaker(product.Id, matching.NewKafkaLogReader("tickMaker", product.Id, gbeConfig.Kafka.Brokers)).Start()
-		worker.NewFillMaker(matching.NewKafkaLogReader("fillMaker", product.Id, gbeConfig.Kafka.Brokers)).Start()
-		worker.NewTradeMaker(matching.NewKafkaLogReader("tradeMaker", product.Id, gbeConfig.Kafka.Brokers)).Start()
-	}
 
 	rest.StartServer()
 
@@ -23,8 +23,14 @@ type OrderReader interface {
 	// 设置读取的起始offset
 	SetOffset(offset int64) error
 
-	// 拉取order
-	FetchOrder() (offset int64, order *models.Order, err error)
+	// 拉取order，或者和order写入同一个topic的command，二者有且只有一个不为nil
+	FetchOrder() (offset int64, order *models.Order, command *Command, err error)
+}
+
+// 用于向撮合引擎提交order和command，二者写入同一个topic
+type OrderWriter interface {
+	// 写入一条已经序列化的order或者command
+	Write(value []byte) error
 }
 
 // 用于保存撮合日志
@@ -33,6 +39,30 @@ type LogStore interface {
 	Store(logs []interface{}) error
 }
 
+// 支持fencing的日志存储，保证被standby接管后的旧leader不能再写入日志
+type FencedLogStore interface {
+	// 拒绝fencing token小于token的写入
+	Fence(token int64) error
+
+	// 携带leader的fencing token保存日志，token小于已知最大的token时返回错误
+	StoreFenced(token int64, logs []interface{}) error
+}
+
+// 用于standby接管时找到旧的leader写入的最后一条日志
+type LastSeqReader interface {
+	// 返回最后一条日志的seq，没有日志时返回0
+	LastSeq() (int64, error)
+}
+
+// 基于租约的leader选举，同一个产品同时只有一个撮合引擎是leader
+type Lease interface {
+	// 阻塞直到获得租约，返回的fencing token大于之前所有leader的token
+	Acquire() (token int64, err error)
+
+	// 持续续约，直到租约丢失时返回
+	Hold(token int64) error
+}
+
 // 以观察者模式读取撮合日志
 type LogReader interface {
 	// 获取当前的productId
@@ -47,6 +77,12 @@ type LogReader interface {
 
 // 撮合日志reader观察者
 type LogObserver interface {
+	// 当读到ReceivedLog时回调
+	OnReceivedLog(log *ReceivedLog, offset int64)
+
+	// 当读到RejectLog时回调
+	OnRejectLog(log *RejectLog, offset int64)
+
 	// 当读到OpenLog时回调
 	OnOpenLog(log *OpenLog, offset int64)
 
@@ -55,6 +91,24 @@ type LogObserver interface {
 
 	// 当读到DoneLog是回调
 	OnDoneLog(log *DoneLog, offset int64)
+
+	// 当读到ActivateLog时回调
+	OnActivateLog(log *ActivateLog, offset int64)
+
+	// 当读到ChangeLog时回调
+	OnChangeLog(log *ChangeLog, offset int64)
+
+	// 当读到StatusLog时回调
+	OnStatusLog(log *StatusLog, offset int64)
+
+	// 当读到AuctionLog时回调
+	OnAuctionLog(log *AuctionLog, offset int64)
+
+	// 当读到BreakerLog时回调
+	OnBreakerLog(log *BreakerLog, offset int64)
+
+	// 当读到TrailLog时回调
+	OnTrailLog(log *TrailLog, offset int64)
 }
 
 // 用于保存撮合引擎的快照
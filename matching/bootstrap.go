@@ -16,24 +16,75 @@ package matching
 
 import (
 	"github.com/gitbitex/gitbitex-spot/conf"
+	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/siddontang/go-log/log"
+	"os"
+	"time"
 )
 
-func StartEngine() {
-	gbeConfig := conf.GetConfig()
+// StartEngine starts the engines of the products run by this node, onStart is called after the engine of a
+// product is started, including the products moved to this node later
+func StartEngine(onStart func(product *models.Product)) {
+	clusterConfig := conf.GetConfig().Cluster
+	if clusterConfig.Enabled && len(clusterConfig.Assignments) == 0 {
+		newAssignmentWatcher(NodeId(), onStart).Start()
+		log.Info("match engine ok")
+		return
+	}
 
 	products, err := service.GetProducts()
 	if err != nil {
 		panic(err)
 	}
 	for _, product := range products {
-		orderReader := NewKafkaOrderReader(product.Id, gbeConfig.Kafka.Brokers)
-		snapshotStore := NewRedisSnapshotStore(product.Id)
-		logStore := NewKafkaLogStore(product.Id, gbeConfig.Kafka.Brokers)
-		matchEngine := NewEngine(product, orderReader, logStore, snapshotStore)
-		matchEngine.Start()
+		// 静态分配时只启动分配给本节点的产品
+		if clusterConfig.Enabled && clusterConfig.Assignments[product.Id] != NodeId() {
+			continue
+		}
+		newEngine(product).Start()
+		onStart(product)
 	}
 
 	log.Info("match engine ok")
 }
+
+func newEngine(product *models.Product) *Engine {
+	orderReader := NewOrderReader(product.Id)
+	snapshotStore := NewSnapshotStore(product.Id)
+	logStore := NewLogStore(product.Id)
+
+	electionConfig := conf.GetConfig().Election
+	if !electionConfig.Enabled {
+		return NewEngine(product, orderReader, logStore, snapshotStore)
+	}
+
+	// kafka的日志topic不能拒绝旧的leader的写入，standby需要使用file queue
+	if !supportsStandby(logStore) {
+		panic("election needs a log store which fences the old leaders, set queue.driver to file")
+	}
+
+	// 每个节点都作为standby启动，获得租约的节点成为leader
+	leaseTtl := time.Duration(electionConfig.LeaseMillis) * time.Millisecond
+	if leaseTtl <= 0 {
+		leaseTtl = 5 * time.Second
+	}
+	lease := NewRedisLease(product.Id, nodeIdOrHostname(electionConfig.NodeId), leaseTtl)
+	return NewStandbyEngine(product, orderReader, logStore, snapshotStore, lease)
+}
+
+// NodeId returns the id of this node in the product assignment
+func NodeId() string {
+	return nodeIdOrHostname(conf.GetConfig().Cluster.NodeId)
+}
+
+func nodeIdOrHostname(nodeId string) string {
+	if len(nodeId) != 0 {
+		return nodeId
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+	return hostname
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/conf"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/service"
+	logger "github.com/siddontang/go-log/log"
+	"sync"
+	"time"
+)
+
+const assignmentPollInterval = 3 * time.Second
+
+// assignmentWatcher runs the engines of the products assigned to this node in the product assignment table, and
+// hands a product over when it is assigned to another node. The old node stops the engine with a last snapshot
+// and releases the product, and then the new node claims it and starts the engine from the snapshot, so the two
+// engines never run at the same time. The product is released only after the last snapshot is stored, otherwise
+// the new node would start from an older snapshot and miss the state of the orders after it. A node which is
+// down keeps its products until it is back, or until the running node of the products is cleared by hand.
+type assignmentWatcher struct {
+	nodeId  string
+	onStart func(product *models.Product)
+	engines map[string]*Engine
+
+	// the engines stopped but not released yet, the release is retried in every poll
+	stopping map[string]*Engine
+
+	store     assignmentStore
+	newEngine func(product *models.Product) *Engine
+}
+
+// assignmentStore reads and updates the product assignment table
+type assignmentStore interface {
+	GetProductAssignments() ([]*models.ProductAssignment, error)
+	UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId, runningNodeId string) (bool, error)
+	GetProductById(id string) (*models.Product, error)
+}
+
+type serviceAssignmentStore struct{}
+
+func (serviceAssignmentStore) GetProductAssignments() ([]*models.ProductAssignment, error) {
+	return service.GetProductAssignments()
+}
+
+func (serviceAssignmentStore) UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId,
+	runningNodeId string) (bool, error) {
+	return service.UpdateProductAssignmentRunningNode(productId, oldRunningNodeId, runningNodeId)
+}
+
+func (serviceAssignmentStore) GetProductById(id string) (*models.Product, error) {
+	return service.GetProductById(id)
+}
+
+func newAssignmentWatcher(nodeId string, onStart func(product *models.Product)) *assignmentWatcher {
+	// 引擎在节点之间迁移时，新的节点需要读到原节点的order topic和快照
+	gbeConfig := conf.GetConfig()
+	if gbeConfig.Queue.Driver == QueueDriverFile || gbeConfig.Snapshot.Store == SnapshotStoreFile {
+		panic("live reassignment needs the kafka queue and a shared snapshot store, use static assignments instead")
+	}
+	if gbeConfig.Election.Enabled {
+		panic("live reassignment does not support the standby engines")
+	}
+
+	return &assignmentWatcher{
+		nodeId:    nodeId,
+		onStart:   onStart,
+		engines:   map[string]*Engine{},
+		stopping:  map[string]*Engine{},
+		store:     serviceAssignmentStore{},
+		newEngine: newEngine,
+	}
+}
+
+func (w *assignmentWatcher) Start() {
+	w.reconcile()
+	go func() {
+		for {
+			time.Sleep(assignmentPollInterval)
+			w.reconcile()
+		}
+	}()
+}
+
+func (w *assignmentWatcher) reconcile() {
+	assignments, err := w.store.GetProductAssignments()
+	if err != nil {
+		logger.Warnf("get product assignments error: %v", err)
+		return
+	}
+
+	for _, assignment := range assignments {
+		if engine, found := w.stopping[assignment.ProductId]; found {
+			// 即使又分配给了本节点，也先完成释放，下一轮重新认领
+			w.release(assignment.ProductId, engine)
+			continue
+		}
+
+		engine, running := w.engines[assignment.ProductId]
+		if running && assignment.NodeId != w.nodeId {
+			logger.Infof("%v is assigned to another node, stopping engine", assignment.ProductId)
+			delete(w.engines, assignment.ProductId)
+			w.stopping[assignment.ProductId] = engine
+			w.release(assignment.ProductId, engine)
+		} else if !running && assignment.NodeId == w.nodeId {
+			w.claim(assignment)
+		}
+	}
+}
+
+// release stops the engine of the product assigned to another node, the new node waits until it is released
+func (w *assignmentWatcher) release(productId string, engine *Engine) {
+	// 最后一次快照保存成功之前不释放，engine保持停止，下一轮再次调用Stop重新保存
+	err := engine.Stop()
+	if err != nil {
+		logger.Errorf("store last snapshot of %v error: %v", productId, err)
+		return
+	}
+
+	released, err := w.store.UpdateProductAssignmentRunningNode(productId, w.nodeId, "")
+	if err != nil {
+		// 下一轮仍然可以释放，本节点不会再认领分配给其他节点的产品
+		logger.Errorf("release %v error: %v", productId, err)
+		return
+	}
+	delete(w.stopping, productId)
+	if !released {
+		logger.Warnf("%v is not running on %v", productId, w.nodeId)
+	}
+}
+
+// claim starts the engine of the product assigned to this node after the old node releases it
+func (w *assignmentWatcher) claim(assignment *models.ProductAssignment) {
+	switch assignment.RunningNodeId {
+	case w.nodeId:
+		// 本节点重启前正在运行该产品
+	case "":
+		claimed, err := w.store.UpdateProductAssignmentRunningNode(assignment.ProductId, "", w.nodeId)
+		if err != nil {
+			logger.Errorf("claim %v error: %v", assignment.ProductId, err)
+			return
+		}
+		if !claimed {
+			return
+		}
+	default:
+		// 等待原节点停止引擎
+		return
+	}
+
+	product, err := w.store.GetProductById(assignment.ProductId)
+	if err != nil {
+		logger.Errorf("get product %v error: %v", assignment.ProductId, err)
+		return
+	}
+	if product == nil {
+		logger.Errorf("product not found: %v", assignment.ProductId)
+		return
+	}
+
+	logger.Infof("%v is assigned to %v, starting engine", product.Id, w.nodeId)
+	engine := w.newEngine(product)
+	engine.Start()
+	w.engines[product.Id] = engine
+	w.onStart(product)
+}
+
+// ProductNodeId returns the node which the product is assigned to, it is empty if the product is not assigned
+func ProductNodeId(productId string) (string, error) {
+	clusterConfig := conf.GetConfig().Cluster
+	if len(clusterConfig.Assignments) != 0 {
+		return clusterConfig.Assignments[productId], nil
+	}
+	return sharedAssignmentCache.get(productId)
+}
+
+// CheckProductNode checks the orders of the product can be submitted from this node. All the nodes share the
+// order topics in Kafka, while the order topics in the file queue are only reachable from the node running the
+// engine.
+func CheckProductNode(productId string) error {
+	gbeConfig := conf.GetConfig()
+	if !gbeConfig.Cluster.Enabled {
+		return nil
+	}
+
+	nodeId, err := ProductNodeId(productId)
+	if err != nil {
+		return err
+	}
+	if len(nodeId) == 0 {
+		return fmt.Errorf("product %v is not assigned to any matching node", productId)
+	}
+	if gbeConfig.Queue.Driver == QueueDriverFile && nodeId != NodeId() {
+		return fmt.Errorf("product %v is served by node %v", productId, nodeId)
+	}
+	return nil
+}
+
+// assignmentCache keeps the product assignment table in memory for a poll interval, so that the orders don't
+// query it one by one
+type assignmentCache struct {
+	mu       sync.Mutex
+	nodeIds  map[string]string
+	loadedAt time.Time
+}
+
+var sharedAssignmentCache = &assignmentCache{}
+
+func (c *assignmentCache) get(productId string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.loadedAt) > assignmentPollInterval {
+		assignments, err := service.GetProductAssignments()
+		if err != nil {
+			return "", err
+		}
+		c.nodeIds = map[string]string{}
+		for _, assignment := range assignments {
+			c.nodeIds[assignment.ProductId] = assignment.NodeId
+		}
+		c.loadedAt = time.Now()
+	}
+	return c.nodeIds[productId], nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"errors"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testProductId = "BTC-USDT"
+
+// fakeAssignmentStore keeps the product assignment table in memory, it is only used in the test goroutine
+type fakeAssignmentStore struct {
+	assignment models.ProductAssignment
+}
+
+func (s *fakeAssignmentStore) GetProductAssignments() ([]*models.ProductAssignment, error) {
+	assignment := s.assignment
+	return []*models.ProductAssignment{&assignment}, nil
+}
+
+func (s *fakeAssignmentStore) UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId,
+	runningNodeId string) (bool, error) {
+	if productId != s.assignment.ProductId || oldRunningNodeId != s.assignment.RunningNodeId {
+		return false, nil
+	}
+	s.assignment.RunningNodeId = runningNodeId
+	return true, nil
+}
+
+func (s *fakeAssignmentStore) GetProductById(id string) (*models.Product, error) {
+	if id != testProductId {
+		return nil, nil
+	}
+	return newTestProduct(), nil
+}
+
+// fakeOrderReader returns the orders sent to the chan until it is closed
+type fakeOrderReader struct {
+	orders    chan *offsetOrder
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	offset int64
+}
+
+func newFakeOrderReader() *fakeOrderReader {
+	return &fakeOrderReader{orders: make(chan *offsetOrder), closed: make(chan struct{}), offset: -1}
+}
+
+func (r *fakeOrderReader) SetOffset(offset int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offset = offset
+	return nil
+}
+
+func (r *fakeOrderReader) getOffset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offset
+}
+
+func (r *fakeOrderReader) FetchOrder() (int64, *models.Order, *Command, error) {
+	select {
+	case order := <-r.orders:
+		return order.Offset, order.Order, order.Command, nil
+	case <-r.closed:
+		return 0, nil, nil, io.EOF
+	}
+}
+
+func (r *fakeOrderReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+	return nil
+}
+
+type fakeLogStore struct {
+	mu      sync.Mutex
+	lastSeq int64
+}
+
+func (s *fakeLogStore) Store(logs []interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, log := range logs {
+		s.lastSeq = log.(Log).GetSeq()
+	}
+	return nil
+}
+
+func (s *fakeLogStore) getLastSeq() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// fakeSnapshotStore is shared by the nodes like the redis store, it refuses the snapshots while it is failing
+type fakeSnapshotStore struct {
+	mu      sync.Mutex
+	latest  *Snapshot
+	failing bool
+}
+
+func (s *fakeSnapshotStore) GetLatest() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest, nil
+}
+
+func (s *fakeSnapshotStore) Store(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("snapshot store is down")
+	}
+	s.latest = snapshot
+	return nil
+}
+
+func (s *fakeSnapshotStore) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+// testNode is a matching node whose engines read the orders from fakeOrderReaders
+type testNode struct {
+	watcher *assignmentWatcher
+	readers []*fakeOrderReader
+	started int
+}
+
+func newTestNode(nodeId string, store assignmentStore, logStore LogStore, snapshotStore SnapshotStore) *testNode {
+	node := &testNode{}
+	node.watcher = &assignmentWatcher{
+		nodeId: nodeId,
+		onStart: func(product *models.Product) {
+			node.started++
+		},
+		engines:  map[string]*Engine{},
+		stopping: map[string]*Engine{},
+		store:    store,
+		newEngine: func(product *models.Product) *Engine {
+			reader := newFakeOrderReader()
+			node.readers = append(node.readers, reader)
+			return NewEngine(product, reader, logStore, snapshotStore)
+		},
+	}
+	return node
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", what)
+		}
+	}
+}
+
+func assertRunningNode(t *testing.T, store *fakeAssignmentStore, want string) {
+	t.Helper()
+	if got := store.assignment.RunningNodeId; got != want {
+		t.Fatalf("running node: got %q, want %q", got, want)
+	}
+}
+
+// startOnA starts the product on node a with an order on the book
+func startOnA(t *testing.T, store *fakeAssignmentStore, a *testNode, logStore *fakeLogStore) {
+	t.Helper()
+	a.watcher.reconcile()
+	assertRunningNode(t, store, "a")
+	if a.started != 1 {
+		t.Fatalf("engines started on a: got %v, want 1", a.started)
+	}
+
+	a.readers[0].orders <- &offsetOrder{Offset: 1, Order: limitOrder(1, 1, models.SideBuy, "100", "1")}
+	waitFor(t, "logs of the order", func() bool {
+		return logStore.getLastSeq() == 2
+	})
+}
+
+func TestAssignmentHandoff(t *testing.T) {
+	store := &fakeAssignmentStore{assignment: models.ProductAssignment{ProductId: testProductId, NodeId: "a"}}
+	logStore := &fakeLogStore{}
+	snapshotStore := &fakeSnapshotStore{}
+	a := newTestNode("a", store, logStore, snapshotStore)
+	b := newTestNode("b", store, logStore, snapshotStore)
+
+	startOnA(t, store, a, logStore)
+	store.assignment.NodeId = "b"
+
+	// b can't claim the product until a releases it
+	b.watcher.reconcile()
+	assertRunningNode(t, store, "a")
+	if b.started != 0 {
+		t.Fatalf("engines started on b: got %v, want 0", b.started)
+	}
+
+	// a stops the engine with a last snapshot, and then releases the product
+	a.watcher.reconcile()
+	assertRunningNode(t, store, "")
+	snapshot, _ := snapshotStore.GetLatest()
+	if snapshot == nil || snapshot.OrderOffset != 1 || len(snapshot.OrderBookSnapshot.Orders) != 1 {
+		t.Fatalf("last snapshot: got %+v", snapshot)
+	}
+
+	// b claims the product, and starts the engine after the last order of the snapshot
+	b.watcher.reconcile()
+	assertRunningNode(t, store, "b")
+	if b.started != 1 {
+		t.Fatalf("engines started on b: got %v, want 1", b.started)
+	}
+	waitFor(t, "order offset of the engine on b", func() bool {
+		return b.readers[0].getOffset() == 2
+	})
+
+	a.watcher.reconcile()
+	if a.started != 1 || len(a.watcher.engines) != 0 {
+		t.Fatalf("a runs the product again: started %v, engines %v", a.started, a.watcher.engines)
+	}
+}
+
+func TestAssignmentKeptUntilLastSnapshotStored(t *testing.T) {
+	store := &fakeAssignmentStore{assignment: models.ProductAssignment{ProductId: testProductId, NodeId: "a"}}
+	logStore := &fakeLogStore{}
+	snapshotStore := &fakeSnapshotStore{}
+	a := newTestNode("a", store, logStore, snapshotStore)
+	b := newTestNode("b", store, logStore, snapshotStore)
+
+	startOnA(t, store, a, logStore)
+	store.assignment.NodeId = "b"
+
+	// the product is kept by a while the last snapshot can't be stored
+	snapshotStore.setFailing(true)
+	for i := 0; i < 2; i++ {
+		a.watcher.reconcile()
+		b.watcher.reconcile()
+		assertRunningNode(t, store, "a")
+		if b.started != 0 {
+			t.Fatalf("engines started on b: got %v, want 0", b.started)
+		}
+	}
+	if snapshot, _ := snapshotStore.GetLatest(); snapshot != nil {
+		t.Fatalf("snapshot stored by the failing store: %+v", snapshot)
+	}
+
+	snapshotStore.setFailing(false)
+	a.watcher.reconcile()
+	assertRunningNode(t, store, "")
+	snapshot, _ := snapshotStore.GetLatest()
+	if snapshot == nil || snapshot.OrderOffset != 1 || len(snapshot.OrderBookSnapshot.Orders) != 1 {
+		t.Fatalf("last snapshot: got %+v", snapshot)
+	}
+
+	b.watcher.reconcile()
+	assertRunningNode(t, store, "b")
+}
+
+func TestAssignmentBackWhileStopping(t *testing.T) {
+	store := &fakeAssignmentStore{assignment: models.ProductAssignment{ProductId: testProductId, NodeId: "a"}}
+	logStore := &fakeLogStore{}
+	snapshotStore := &fakeSnapshotStore{}
+	a := newTestNode("a", store, logStore, snapshotStore)
+
+	startOnA(t, store, a, logStore)
+	store.assignment.NodeId = "b"
+	snapshotStore.setFailing(true)
+	a.watcher.reconcile()
+
+	// the stopped engine is released before the product is claimed again with a new engine
+	store.assignment.NodeId = "a"
+	snapshotStore.setFailing(false)
+	a.watcher.reconcile()
+	assertRunningNode(t, store, "")
+	a.watcher.reconcile()
+	assertRunningNode(t, store, "a")
+	if a.started != 2 {
+		t.Fatalf("engines started on a: got %v, want 2", a.started)
+	}
+	waitFor(t, "order offset of the new engine", func() bool {
+		return a.readers[1].getOffset() == 2
+	})
+}
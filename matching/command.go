@@ -0,0 +1,65 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+type CommandType string
+
+const (
+	// 修改orderBook中订单的价格或者数量
+	CommandTypeAmend = CommandType("amend")
+	// 修改产品的交易状态
+	CommandTypeProductStatus = CommandType("productStatus")
+	// 下一组联动的订单(OCO或者bracket)，组内的订单在同一个sequence中被加入orderBook
+	CommandTypePlaceGroup = CommandType("placeGroup")
+	// 在同一个sequence中撤销用户的所有订单，可以只撤销一侧或者一个价格区间内的订单
+	CommandTypeMassCancel = CommandType("massCancel")
+	// 只推进orderBook的时钟，使GTT订单和熔断后的集合竞价在没有新的订单时也能按时结束
+	CommandTypeClock = CommandType("clock")
+)
+
+// Command是除了下单和撤单之外，对orderBook的操作指令。Command和order写入同一个topic，
+// 从而和order严格有序，并且能够随order一起重放。CommandType不为空的消息是Command，否则是order。
+type Command struct {
+	CommandType CommandType
+	ProductId   string
+	UserId      int64
+	OrderId     int64
+	Side        models.Side
+
+	// amend: 新的剩余数量，只能减少，为0表示不修改
+	Size decimal.Decimal
+
+	// amend: 新的价格，为0表示不修改
+	Price decimal.Decimal
+
+	// productStatus: 新的交易状态
+	Status models.ProductStatus
+
+	// placeGroup: 组内的所有订单，第一个订单的id是订单组的id，bracket的第一个订单是入场单
+	Orders []*models.Order
+
+	// massCancel: 只撤销价格在[LowPrice, HighPrice]之间的订单，为0表示该侧不限制。Side为空表示撤销两侧的订单
+	LowPrice  decimal.Decimal
+	HighPrice decimal.Decimal
+
+	// 指令的创建时间，和order的CreatedAt一样用于推进orderBook的时钟
+	Time time.Time
+}
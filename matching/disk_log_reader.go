@@ -0,0 +1,62 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+	logger "github.com/siddontang/go-log/log"
+	"path/filepath"
+	"time"
+)
+
+type DiskLogReader struct {
+	readerId  string
+	productId string
+	reader    *filelog.Reader
+	observer  LogObserver
+}
+
+func NewDiskLogReader(readerId, productId string, dir string) LogReader {
+	reader := filelog.NewReader(filepath.Join(dir, TopicBookMessagePrefix+productId))
+	return &DiskLogReader{readerId: readerId, productId: productId, reader: reader}
+}
+
+func (r *DiskLogReader) GetProductId() string {
+	return r.productId
+}
+
+func (r *DiskLogReader) RegisterObserver(observer LogObserver) {
+	r.observer = observer
+}
+
+func (r *DiskLogReader) Run(seq, offset int64) {
+	logger.Infof("%v:%v read from %v", r.productId, r.readerId, offset)
+
+	err := r.reader.SetOffset(offset)
+	if err != nil {
+		panic(err)
+	}
+
+	dispatcher := newLogDispatcher(r.readerId, r.productId, r.observer, seq)
+	for {
+		offset, value, err := r.reader.Read()
+		if err != nil {
+			logger.Error(err)
+			time.Sleep(time.Second)
+			continue
+		}
+		dispatcher.dispatch(value, offset)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"encoding/json"
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+	"path/filepath"
+)
+
+// DiskLogStore appends the logs to the log topic stored by filelog, the logs of a Store call are written at once
+type DiskLogStore struct {
+	log *filelog.Log
+
+	// follows the logs appended by the leader in another process, see LastSeq
+	tail        *filelog.Reader
+	tailStarted bool
+	lastSeq     int64
+}
+
+func NewDiskLogStore(productId string, dir string, options filelog.Options) (*DiskLogStore, error) {
+	log, err := filelog.Open(filepath.Join(dir, TopicBookMessagePrefix+productId), options)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskLogStore{log: log, tail: filelog.NewReader(filepath.Join(dir, TopicBookMessagePrefix+productId))}, nil
+}
+
+func (s *DiskLogStore) Store(logs []interface{}) error {
+	values, err := marshalLogs(logs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.log.Append(values...)
+	return err
+}
+
+func (s *DiskLogStore) Fence(token int64) error {
+	return s.log.Fence(token)
+}
+
+// StoreFenced appends the logs unless a writer with a newer token has fenced the log
+func (s *DiskLogStore) StoreFenced(token int64, logs []interface{}) error {
+	values, err := marshalLogs(logs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.log.AppendFenced(token, values...)
+	return err
+}
+
+// LastSeq reads the logs appended since the last call, it is only called by the committer of the engine
+func (s *DiskLogStore) LastSeq() (int64, error) {
+	if !s.tailStarted {
+		// 从打开时的最后一条日志开始读取
+		offset := s.log.NextOffset() - 1
+		if offset < 0 {
+			offset = filelog.FirstOffset
+		}
+		err := s.tail.SetOffset(offset)
+		if err != nil {
+			return 0, err
+		}
+		s.tailStarted = true
+	}
+
+	for {
+		_, value, err := s.tail.TryRead()
+		if err == filelog.ErrNotReady {
+			return s.lastSeq, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var base Base
+		err = json.Unmarshal(value, &base)
+		if err != nil {
+			return 0, err
+		}
+		s.lastSeq = base.Sequence
+	}
+}
+
+func marshalLogs(logs []interface{}) ([][]byte, error) {
+	var values [][]byte
+	for _, log := range logs {
+		val, err := json.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
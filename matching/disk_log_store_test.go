@@ -0,0 +1,121 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newTestDiskLogStore opens the log store of the product in dir, the stores opened on the same dir act as the
+// leader and the standbys of the product
+func newTestDiskLogStore(t *testing.T, dir string) *DiskLogStore {
+	s, err := NewDiskLogStore("BTC-USDT", dir, filelog.Options{SegmentBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testLogs(seqs ...int64) []interface{} {
+	var logs []interface{}
+	for _, seq := range seqs {
+		logs = append(logs, &DoneLog{Base: Base{Type: LogTypeDone, Sequence: seq, ProductId: "BTC-USDT"}})
+	}
+	return logs
+}
+
+func assertLastSeq(t *testing.T, s *DiskLogStore, want int64) {
+	t.Helper()
+	got, err := s.LastSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("last seq: got %v, want %v", got, want)
+	}
+}
+
+func TestDiskLogStoreRejectsStaleToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	oldLeader := newTestDiskLogStore(t, dir)
+	newLeader := newTestDiskLogStore(t, dir)
+
+	err = oldLeader.StoreFenced(1, testLogs(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new leader fences the log before it takes over
+	err = newLeader.Fence(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = oldLeader.StoreFenced(1, testLogs(2))
+	if err != filelog.ErrFenced {
+		t.Fatalf("store of the old leader: got %v, want %v", err, filelog.ErrFenced)
+	}
+	err = oldLeader.Fence(1)
+	if err != filelog.ErrFenced {
+		t.Fatalf("fence of the old leader: got %v, want %v", err, filelog.ErrFenced)
+	}
+
+	err = newLeader.StoreFenced(2, testLogs(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = oldLeader.StoreFenced(1, testLogs(3))
+	if err != filelog.ErrFenced {
+		t.Fatalf("store of the old leader after the take over: got %v, want %v", err, filelog.ErrFenced)
+	}
+
+	// only the logs of the leaders in their terms are in the log
+	assertLastSeq(t, newTestDiskLogStore(t, dir), 2)
+}
+
+func TestDiskLogStoreLastSeqFollowsWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	leader := newTestDiskLogStore(t, dir)
+	standby := newTestDiskLogStore(t, dir)
+	assertLastSeq(t, standby, 0)
+
+	err = leader.StoreFenced(1, testLogs(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLastSeq(t, standby, 2)
+
+	err = leader.StoreFenced(1, testLogs(3, 4, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLastSeq(t, standby, 5)
+	assertLastSeq(t, standby, 5)
+
+	// a standby started later begins at the last log
+	assertLastSeq(t, newTestDiskLogStore(t, dir), 5)
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"path/filepath"
+)
+
+// DiskOrderReader reads the order topic stored by filelog, it is used instead of Kafka on a single node
+type DiskOrderReader struct {
+	reader *filelog.Reader
+}
+
+func NewDiskOrderReader(productId string, dir string) *DiskOrderReader {
+	return &DiskOrderReader{reader: filelog.NewReader(filepath.Join(dir, TopicOrderPrefix+productId))}
+}
+
+func (s *DiskOrderReader) SetOffset(offset int64) error {
+	return s.reader.SetOffset(offset)
+}
+
+func (s *DiskOrderReader) FetchOrder() (offset int64, order *models.Order, command *Command, err error) {
+	offset, value, err := s.reader.Read()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	order, command, err = decodeOrderMessage(value)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return offset, order, command, nil
+}
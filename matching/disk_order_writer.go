@@ -0,0 +1,38 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+	"path/filepath"
+)
+
+// DiskOrderWriter appends orders and commands to the order topic stored by filelog
+type DiskOrderWriter struct {
+	log *filelog.Log
+}
+
+func NewDiskOrderWriter(productId string, dir string, options filelog.Options) (*DiskOrderWriter, error) {
+	log, err := filelog.Open(filepath.Join(dir, TopicOrderPrefix+productId), options)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskOrderWriter{log: log}, nil
+}
+
+func (s *DiskOrderWriter) Write(value []byte) error {
+	_, err := s.log.Append(value)
+	return err
+}
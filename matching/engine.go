@@ -15,8 +15,10 @@
 package matching
 
 import (
+	"errors"
 	"github.com/gitbitex/gitbitex-spot/models"
 	logger "github.com/siddontang/go-log/log"
+	"io"
 	"time"
 )
 
@@ -53,17 +55,43 @@ type Engine struct {
 
 	// 持久化snapshot的存储方式，应该支持多种方式，如本地磁盘，redis等
 	snapshotStore SnapshotStore
+
+	// standby的engine通过租约选举leader，为nil时engine总是leader
+	lease Lease
+
+	// 成为leader时获得的fencing token，写入日志时携带
+	token int64
+
+	// 成为leader时关闭，standby不进行快照
+	leading chan struct{}
+
+	// 停止engine的请求，engine保存最后一次快照后通过该请求携带的chan返回
+	stopCh chan chan error
+
+	// applier停止后关闭，fetcher随之退出
+	stopped chan struct{}
+
+	// 停止时没有保存成功的日志和最后一次快照，再次调用Stop时重新保存
+	lastLogs     []interface{}
+	lastSnapshot *Snapshot
 }
 
 // 快照是engine在某一时候的一致性内存状态
 type Snapshot struct {
 	OrderBookSnapshot orderBookSnapshot
 	OrderOffset       int64
+
+	// 订单簿上的订单，由runSnapshots在保存前复制到OrderBookSnapshot中
+	frozen *frozenOrders
+
+	// 停止engine时的最后一次快照，保存后将结果写入该chan，engine随之退出
+	done chan error
 }
 
 type offsetOrder struct {
-	Offset int64
-	Order  *models.Order
+	Offset  int64
+	Order   *models.Order
+	Command *Command
 }
 
 func NewEngine(product *models.Product, orderReader OrderReader, logStore LogStore, snapshotStore SnapshotStore) *Engine {
@@ -78,6 +106,8 @@ func NewEngine(product *models.Product, orderReader OrderReader, logStore LogSto
 		snapshotStore:        snapshotStore,
 		orderReader:          orderReader,
 		logStore:             logStore,
+		stopCh:               make(chan chan error),
+		stopped:              make(chan struct{}),
 	}
 
 	// 获取最新的snapshot，并使用snapshot进行恢复
@@ -91,13 +121,58 @@ func NewEngine(product *models.Product, orderReader OrderReader, logStore LogSto
 	return e
 }
 
+// NewStandbyEngine creates an engine which follows the order stream as a hot standby until it is elected as the
+// leader by the lease, only the leader writes the logs and the snapshots. The log store must reject the writes
+// of the old leaders by itself, checking the token before writing can't stop an old leader which pauses between
+// the check and the write.
+func NewStandbyEngine(product *models.Product, orderReader OrderReader, logStore LogStore,
+	snapshotStore SnapshotStore, lease Lease) *Engine {
+	if !supportsStandby(logStore) {
+		logger.Fatalf("log store of %v does not support standby", product.Id)
+	}
+
+	e := NewEngine(product, orderReader, logStore, snapshotStore)
+	e.lease = lease
+	e.leading = make(chan struct{})
+	return e
+}
+
 func (e *Engine) Start() {
+	// the applier updates the seq as soon as it starts, the committer starts from the seq restored from the snapshot
+	seq := e.OrderBook.logSeq
+
 	go e.runFetcher()
 	go e.runApplier()
-	go e.runCommitter()
+	go e.runCommitter(seq)
 	go e.runSnapshots()
 }
 
+// Stop takes a last snapshot at the last applied order, and returns after the logs before it and the snapshot
+// are stored, so that the engine can be started again on another node from the snapshot. The orders fetched
+// but not applied are read again by the new engine. If the logs or the snapshot can't be stored, the engine
+// stays stopped, and Stop can be called again to store them. A standby engine can't be stopped.
+func (e *Engine) Stop() error {
+	if e.lease != nil {
+		return errors.New("standby engine can't be stopped")
+	}
+
+	select {
+	case <-e.stopped:
+		return e.storeLastSnapshot()
+	default:
+	}
+
+	done := make(chan error, 1)
+	e.stopCh <- done
+	err := <-done
+
+	// unblock the fetcher waiting for the next order
+	if closer, ok := e.orderReader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return err
+}
+
 // 负责不断的拉取order，写入chan
 func (e *Engine) runFetcher() {
 	var offset = e.orderOffset
@@ -110,29 +185,33 @@ func (e *Engine) runFetcher() {
 	}
 
 	for {
-		offset, order, err := e.orderReader.FetchOrder()
+		offset, order, command, err := e.orderReader.FetchOrder()
 		if err != nil {
+			select {
+			case <-e.stopped:
+				return
+			default:
+			}
 			logger.Error(err)
 			continue
 		}
-		e.orderCh <- &offsetOrder{offset, order}
+
+		select {
+		case e.orderCh <- &offsetOrder{offset, order, command}:
+		case <-e.stopped:
+			return
+		}
 	}
 }
 
 // 从本地队列获取order，执行orderBook操作，同时要响应snapshot请求
 func (e *Engine) runApplier() {
-	var orderOffset int64
+	var orderOffset = e.orderOffset
 
 	for {
 		select {
 		case offsetOrder := <-e.orderCh:
-			// put or cancel order
-			var logs []Log
-			if offsetOrder.Order.Status == models.OrderStatusCancelling {
-				logs = e.OrderBook.CancelOrder(offsetOrder.Order)
-			} else {
-				logs = e.OrderBook.ApplyOrder(offsetOrder.Order)
-			}
+			logs := e.apply(offsetOrder)
 
 			// 将orderBook产生的log写入chan进行持久化
 			for _, log := range logs {
@@ -152,20 +231,97 @@ func (e *Engine) runApplier() {
 			logger.Infof("should take snapshot: %v %v-[%v]-%v->",
 				e.productId, snapshot.OrderOffset, delta, orderOffset)
 
-			// 执行快照，并将快照数据写入批准chan
-			snapshot.OrderBookSnapshot = e.OrderBook.Snapshot()
+			// 上一次快照的订单还没有复制完，orderBook同时只能冻结一次
+			if e.OrderBook.snapshotting() {
+				continue
+			}
+
+			// 执行快照，订单簿上的订单只冻结不复制，并将快照数据写入批准chan
+			snapshot.OrderBookSnapshot, snapshot.frozen = e.OrderBook.freeze()
 			snapshot.OrderOffset = orderOffset
 			e.snapshotApproveReqCh <- snapshot
+
+		case done := <-e.stopCh:
+			// 不再执行新的order，最后一次快照在当前goroutine中完成复制，之后orderBook不再变化，
+			// 仍在复制中的上一次快照也不受影响
+			close(e.stopped)
+			e.snapshotApproveReqCh <- &Snapshot{
+				OrderBookSnapshot: e.OrderBook.Snapshot(),
+				OrderOffset:       orderOffset,
+				done:              done,
+			}
+			return
 		}
 	}
 }
 
+// put or cancel order, or apply command
+func (e *Engine) apply(offsetOrder *offsetOrder) []Log {
+	if offsetOrder.Command != nil {
+		return e.OrderBook.ApplyCommand(offsetOrder.Command)
+	} else if offsetOrder.Order.Status == models.OrderStatusCancelling {
+		return e.OrderBook.CancelOrder(offsetOrder.Order)
+	}
+	return e.OrderBook.ApplyOrder(offsetOrder.Order)
+}
+
+// Replay在当前goroutine中从快照的offset开始依次执行order，直到orderReader返回io.EOF，日志按顺序写入logStore，
+// 最后保存一次快照。orderBook的时钟来自order和command，同样的快照和输入总是得到同样的日志，用于离线重建
+// orderBook并和线上的日志对比。返回最后执行的order的offset。
+func (e *Engine) Replay() (int64, error) {
+	orderOffset := e.orderOffset
+	offset := orderOffset
+	if offset > 0 {
+		offset = offset + 1
+	}
+	err := e.orderReader.SetOffset(offset)
+	if err != nil {
+		return orderOffset, err
+	}
+
+	seq := e.OrderBook.logSeq
+	for {
+		offset, order, command, err := e.orderReader.FetchOrder()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return orderOffset, err
+		}
+
+		var logs []interface{}
+		for _, log := range e.apply(&offsetOrder{offset, order, command}) {
+			if log.GetSeq() <= seq {
+				continue
+			}
+			seq = log.GetSeq()
+			logs = append(logs, log)
+		}
+		if len(logs) != 0 {
+			err = e.logStore.Store(logs)
+			if err != nil {
+				return orderOffset, err
+			}
+		}
+		orderOffset = offset
+	}
+
+	snapshot := &Snapshot{
+		OrderBookSnapshot: e.OrderBook.Snapshot(),
+		OrderOffset:       orderOffset,
+	}
+	return orderOffset, e.snapshotStore.Store(snapshot)
+}
+
 // 将orderBook产生的log进行持久化，同时需要响应snapshot审批
-func (e *Engine) runCommitter() {
-	var seq = e.OrderBook.logSeq
+func (e *Engine) runCommitter(seq int64) {
 	var pending *Snapshot = nil
 	var logs []interface{}
 
+	if e.lease != nil {
+		seq = e.runStandby()
+	}
+
 	for {
 		select {
 		case log := <-e.logCh:
@@ -184,7 +340,7 @@ func (e *Engine) runCommitter() {
 			}
 
 			// store log, clean buffer
-			err := e.logStore.Store(logs)
+			err := e.storeLogs(logs)
 			if err != nil {
 				panic(err)
 			}
@@ -197,6 +353,28 @@ func (e *Engine) runCommitter() {
 			}
 
 		case snapshot := <-e.snapshotApproveReqCh:
+			// 停止前的日志都已经在chan中，全部写入后批准最后一次快照
+			if snapshot.done != nil {
+				for len(e.logCh) > 0 {
+					log := <-e.logCh
+					if log.GetSeq() > seq {
+						seq = log.GetSeq()
+						logs = append(logs, log)
+					}
+				}
+				if len(logs) != 0 {
+					err := e.storeLogs(logs)
+					if err != nil {
+						e.lastLogs = logs
+						e.lastSnapshot = snapshot
+						snapshot.done <- err
+						return
+					}
+				}
+				e.snapshotCh <- snapshot
+				return
+			}
+
 			// 写入的seq已经达到或者超过snapshot的seq，批准snapshot请求
 			if seq >= snapshot.OrderBookSnapshot.LogSeq {
 				e.snapshotCh <- snapshot
@@ -214,8 +392,113 @@ func (e *Engine) runCommitter() {
 	}
 }
 
+// standby的engine和leader读取同一个order流，保持orderBook的状态，但是不写入日志。生成的日志暂存在内存中，并且定时
+// 清理leader已经写入的部分。获得租约后接管写入，返回已经写入的最后一条日志的seq
+func (e *Engine) runStandby() int64 {
+	tokenCh := make(chan int64, 1)
+	go func() {
+		token, err := e.lease.Acquire()
+		if err != nil {
+			logger.Fatalf("acquire lease of %v error: %v", e.productId, err)
+		}
+		tokenCh <- token
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var logs []Log
+	for {
+		select {
+		case log := <-e.logCh:
+			logs = append(logs, log)
+
+		case <-ticker.C:
+			lastSeq, err := e.logStore.(LastSeqReader).LastSeq()
+			if err != nil {
+				logger.Warnf("read last seq of %v error: %v", e.productId, err)
+				continue
+			}
+			logs = logsAfter(logs, lastSeq)
+
+		case token := <-tokenCh:
+			logger.Infof("%v is the leader, fencing token=%v", e.productId, token)
+			e.token = token
+			seq := e.takeOver(logs)
+
+			// 租约丢失时退出进程，由新的leader接管，即使旧的leader还没有退出，它的写入也会被fencing拒绝
+			go func() {
+				err := e.lease.Hold(token)
+				logger.Fatalf("lost lease of %v: %v", e.productId, err)
+			}()
+			close(e.leading)
+			return seq
+		}
+	}
+}
+
+// takeOver fences the old leader, and then writes the logs the old leader has not written
+func (e *Engine) takeOver(logs []Log) int64 {
+	err := e.logStore.(FencedLogStore).Fence(e.token)
+	if err != nil {
+		logger.Fatalf("fence log store of %v error: %v", e.productId, err)
+	}
+
+	// 旧的leader已经不能再写入，最后一条日志不会再变化
+	lastSeq, err := e.logStore.(LastSeqReader).LastSeq()
+	if err != nil {
+		logger.Fatalf("read last seq of %v error: %v", e.productId, err)
+	}
+
+	var tail []interface{}
+	for _, log := range logsAfter(logs, lastSeq) {
+		tail = append(tail, log)
+	}
+	if len(tail) == 0 {
+		return lastSeq
+	}
+	if tail[0].(Log).GetSeq() != lastSeq+1 {
+		logger.Fatalf("logs of %v between %v and %v are lost", e.productId, lastSeq, tail[0].(Log).GetSeq())
+	}
+	err = e.storeLogs(tail)
+	if err != nil {
+		logger.Fatalf("store logs of %v error: %v", e.productId, err)
+	}
+	return tail[len(tail)-1].(Log).GetSeq()
+}
+
+// supportsStandby reports whether the log store fences the old leaders and tells the last seq to the standbys
+func supportsStandby(logStore LogStore) bool {
+	_, fenced := logStore.(FencedLogStore)
+	_, lastSeqReader := logStore.(LastSeqReader)
+	return fenced && lastSeqReader
+}
+
+// storeLogs writes the logs with the fencing token of the leader
+func (e *Engine) storeLogs(logs []interface{}) error {
+	if e.lease == nil {
+		return e.logStore.Store(logs)
+	}
+	return e.logStore.(FencedLogStore).StoreFenced(e.token, logs)
+}
+
+// logsAfter returns the logs whose seq is greater than the seq
+func logsAfter(logs []Log, seq int64) []Log {
+	for i, log := range logs {
+		if log.GetSeq() > seq {
+			return logs[i:]
+		}
+	}
+	return nil
+}
+
 // 定时发起快照请求，同时负责持久化通过审批的快照
 func (e *Engine) runSnapshots() {
+	// standby的快照可能包含还没有写入的日志，只有leader进行快照
+	if e.lease != nil {
+		<-e.leading
+	}
+
 	// 最后一次快照时的order orderOffset
 	orderOffset := e.orderOffset
 
@@ -228,8 +511,23 @@ func (e *Engine) runSnapshots() {
 			}
 
 		case snapshot := <-e.snapshotCh:
+			// copy the orders out of the frozen order book
+			if snapshot.frozen != nil {
+				snapshot.OrderBookSnapshot.Orders = snapshot.frozen.copyOrders()
+				snapshot.frozen = nil
+			}
+
 			// store snapshot
 			err := e.snapshotStore.Store(snapshot)
+			if snapshot.done != nil {
+				logger.Infof("engine stopped: product=%v OrderOffset=%v LogSeq=%v err=%v",
+					e.productId, snapshot.OrderOffset, snapshot.OrderBookSnapshot.LogSeq, err)
+				if err != nil {
+					e.lastSnapshot = snapshot
+				}
+				snapshot.done <- err
+				return
+			}
 			if err != nil {
 				logger.Warnf("store snapshot failed: %v", err)
 				continue
@@ -243,6 +541,29 @@ func (e *Engine) runSnapshots() {
 	}
 }
 
+// storeLastSnapshot stores the logs and the last snapshot which failed to be stored when the engine was stopped,
+// the logs are stored before the snapshot
+func (e *Engine) storeLastSnapshot() error {
+	if len(e.lastLogs) != 0 {
+		err := e.storeLogs(e.lastLogs)
+		if err != nil {
+			return err
+		}
+		e.lastLogs = nil
+	}
+
+	if e.lastSnapshot != nil {
+		err := e.snapshotStore.Store(e.lastSnapshot)
+		if err != nil {
+			return err
+		}
+		logger.Infof("last snapshot stored: product=%v OrderOffset=%v LogSeq=%v",
+			e.productId, e.lastSnapshot.OrderOffset, e.lastSnapshot.OrderBookSnapshot.LogSeq)
+		e.lastSnapshot = nil
+	}
+	return nil
+}
+
 func (e *Engine) restore(snapshot *Snapshot) {
 	logger.Infof("restoring: %+v", *snapshot)
 	e.orderOffset = snapshot.OrderOffset
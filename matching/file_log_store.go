@@ -0,0 +1,52 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// FileLogStore appends the logs to a file, one JSON per line
+type FileLogStore struct {
+	file *os.File
+}
+
+func NewFileLogStore(path string) (*FileLogStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLogStore{file: file}, nil
+}
+
+func (s *FileLogStore) Store(logs []interface{}) error {
+	var buf []byte
+	for _, log := range logs {
+		val, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, val...)
+		buf = append(buf, '\n')
+	}
+
+	_, err := s.file.Write(buf)
+	return err
+}
+
+func (s *FileLogStore) Close() error {
+	return s.file.Close()
+}
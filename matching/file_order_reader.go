@@ -0,0 +1,144 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"io"
+	"os"
+)
+
+// FileOrderMessage is a message of the order topic saved in a file with its offset, one JSON per line
+type FileOrderMessage struct {
+	Offset int64
+	Value  json.RawMessage
+}
+
+// FileOrderReader reads the orders and commands from a file written by FileOrderWriter, it returns io.EOF at the
+// end of the file
+type FileOrderReader struct {
+	file   *os.File
+	reader *bufio.Reader
+
+	// the message read ahead by SetOffset
+	next *FileOrderMessage
+}
+
+func NewFileOrderReader(path string) (*FileOrderReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOrderReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (s *FileOrderReader) SetOffset(offset int64) error {
+	_, err := s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	s.reader.Reset(s.file)
+	s.next = nil
+
+	for {
+		message, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if message.Offset >= offset {
+			s.next = message
+			return nil
+		}
+	}
+}
+
+func (s *FileOrderReader) FetchOrder() (offset int64, order *models.Order, command *Command, err error) {
+	message := s.next
+	s.next = nil
+	if message == nil {
+		message, err = s.readMessage()
+		if err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	order, command, err = decodeOrderMessage(message.Value)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return message.Offset, order, command, nil
+}
+
+func (s *FileOrderReader) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileOrderReader) readMessage() (*FileOrderMessage, error) {
+	for {
+		// the last line may have no '\n' at the end of the file
+		line, err := s.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var message FileOrderMessage
+		err = json.Unmarshal(line, &message)
+		if err != nil {
+			return nil, err
+		}
+		return &message, nil
+	}
+}
+
+// FileOrderWriter saves the messages of the order topic into a file which is read by FileOrderReader
+type FileOrderWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func NewFileOrderWriter(path string) (*FileOrderWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOrderWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (w *FileOrderWriter) Write(offset int64, value []byte) error {
+	buf, err := json.Marshal(&FileOrderMessage{Offset: offset, Value: value})
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(append(buf, '\n'))
+	return err
+}
+
+func (w *FileOrderWriter) Close() error {
+	err := w.writer.Flush()
+	if err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
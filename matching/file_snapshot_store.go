@@ -0,0 +1,154 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	logger "github.com/siddontang/go-log/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const snapshotFileSuffix = ".snapshot"
+
+// FileSnapshotStore keeps the latest snapshots in a directory, one file per snapshot named by its order offset.
+// A file is the json of the meta in the first line followed by the snapshot.
+type FileSnapshotStore struct {
+	dir  string
+	keep int
+}
+
+func NewFileSnapshotStore(dir string, keep int) SnapshotStore {
+	return &FileSnapshotStore{dir: dir, keep: keep}
+}
+
+func (s *FileSnapshotStore) Store(snapshot *Snapshot) error {
+	meta, data, err := encodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	metaBuf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免留下写了一半的快照
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%v", snapshot.OrderOffset, snapshotFileSuffix))
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(append(metaBuf, '\n'), data...))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	return s.prune()
+}
+
+// GetLatest returns the newest snapshot which passes the verification
+func (s *FileSnapshotStore) GetLatest() (*Snapshot, error) {
+	paths, err := s.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		snapshot, err := s.read(paths[i])
+		if err != nil {
+			logger.Warnf("skip snapshot %v: %v", paths[i], err)
+			continue
+		}
+		return snapshot, nil
+	}
+	if len(paths) != 0 {
+		return nil, errNoValidSnapshot
+	}
+	return nil, nil
+}
+
+func (s *FileSnapshotStore) read(path string) (*Snapshot, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return nil, fmt.Errorf("snapshot meta not found")
+	}
+	var meta SnapshotMeta
+	err = json.Unmarshal(buf[:i], &meta)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(&meta, buf[i+1:])
+}
+
+// prune removes the snapshots older than the latest keep ones
+func (s *FileSnapshotStore) prune() error {
+	paths, err := s.paths()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(paths)-s.keep; i++ {
+		err = os.Remove(paths[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paths returns the snapshot files from the oldest to the newest
+func (s *FileSnapshotStore) paths() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), snapshotFileSuffix) {
+			paths = append(paths, filepath.Join(s.dir, file.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSnapshot(orderOffset int64) *Snapshot {
+	o := NewOrderBook(newTestProduct())
+	o.ApplyOrder(limitOrder(orderOffset, 1, models.SideBuy, "100", "1"))
+	return &Snapshot{OrderOffset: orderOffset, OrderBookSnapshot: o.Snapshot()}
+}
+
+func snapshotPath(dir string, orderOffset int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%v", orderOffset, snapshotFileSuffix))
+}
+
+// damageSnapshot rewrites the snapshot file of the offset with the damaged content
+func damageSnapshot(t *testing.T, dir string, orderOffset int64, damage func(buf []byte) []byte) {
+	path := snapshotPath(dir, orderOffset)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, damage(buf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSnapshotStoreGetLatest(t *testing.T) {
+	flipData := func(buf []byte) []byte {
+		buf[len(buf)-1] ^= 0xff
+		return buf
+	}
+	tests := []struct {
+		name    string
+		damaged map[int64]func(buf []byte) []byte
+		// order offset of the snapshot returned, 0 for none
+		want    int64
+		wantErr error
+	}{
+		{
+			name: "latest snapshot",
+			want: 3,
+		},
+		{
+			name:    "checksum mismatch of the latest falls back to the previous",
+			damaged: map[int64]func([]byte) []byte{3: flipData},
+			want:    2,
+		},
+		{
+			name: "torn latest falls back to the previous",
+			damaged: map[int64]func([]byte) []byte{3: func(buf []byte) []byte {
+				return buf[:len(buf)/2]
+			}},
+			want: 2,
+		},
+		{
+			name: "latest without meta falls back to the previous",
+			damaged: map[int64]func([]byte) []byte{3: func(buf []byte) []byte {
+				return buf[bytes.IndexByte(buf, '\n')+1:]
+			}},
+			want: 2,
+		},
+		{
+			name: "snapshot which does not match its meta falls back to the previous",
+			damaged: map[int64]func([]byte) []byte{3: func(buf []byte) []byte {
+				return bytes.Replace(buf, []byte(`"OrderOffset":3`), []byte(`"OrderOffset":4`), 1)
+			}},
+			want: 2,
+		},
+		{
+			name:    "several corrupted snapshots fall back to the oldest",
+			damaged: map[int64]func([]byte) []byte{3: flipData, 2: flipData},
+			want:    1,
+		},
+		{
+			name:    "all snapshots corrupted",
+			damaged: map[int64]func([]byte) []byte{3: flipData, 2: flipData, 1: flipData},
+			wantErr: errNoValidSnapshot,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "snapshots")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = os.RemoveAll(dir) }()
+
+			store := NewFileSnapshotStore(dir, 3)
+			for orderOffset := int64(1); orderOffset <= 3; orderOffset++ {
+				err = store.Store(newTestSnapshot(orderOffset))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for orderOffset, damage := range test.damaged {
+				damageSnapshot(t, dir, orderOffset, damage)
+			}
+
+			snapshot, err := store.GetLatest()
+			if err != test.wantErr {
+				t.Fatalf("error %v, want %v", err, test.wantErr)
+			}
+			if test.want == 0 {
+				if snapshot != nil {
+					t.Errorf("snapshot %v returned, want none", snapshot.OrderOffset)
+				}
+				return
+			}
+			if snapshot == nil || snapshot.OrderOffset != test.want {
+				t.Fatalf("snapshot %+v, want order offset %v", snapshot, test.want)
+			}
+			orders := snapshot.OrderBookSnapshot.Orders
+			if len(orders) != 1 || orders[0].OrderId != test.want {
+				t.Errorf("orders %+v, want order %v", orders, test.want)
+			}
+		})
+	}
+}
+
+func TestFileSnapshotStoreKeep(t *testing.T) {
+	tests := []struct {
+		name  string
+		keep  int
+		count int64
+		want  []int64
+	}{
+		{name: "older snapshots are pruned", keep: 3, count: 5, want: []int64{3, 4, 5}},
+		{name: "fewer snapshots than kept", keep: 3, count: 2, want: []int64{1, 2}},
+		{name: "only the latest is kept", keep: 1, count: 3, want: []int64{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "snapshots")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = os.RemoveAll(dir) }()
+
+			store := NewFileSnapshotStore(dir, test.keep)
+			for orderOffset := int64(1); orderOffset <= test.count; orderOffset++ {
+				err = store.Store(newTestSnapshot(orderOffset))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names, want []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			for _, orderOffset := range test.want {
+				want = append(want, filepath.Base(snapshotPath(dir, orderOffset)))
+			}
+			if fmt.Sprint(names) != fmt.Sprint(want) {
+				t.Errorf("files %v, want %v", names, want)
+			}
+		})
+	}
+}
+
+func TestFileSnapshotStoreEmpty(t *testing.T) {
+	snapshot, err := NewFileSnapshotStore(filepath.Join(os.TempDir(), "no-such-snapshots"), 3).GetLatest()
+	if snapshot != nil || err != nil {
+		t.Errorf("snapshot %+v, error %v, want none", snapshot, err)
+	}
+}
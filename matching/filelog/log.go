@@ -0,0 +1,363 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filelog is an append-only log stored in segment files, it replaces Kafka when the exchange runs on a
+// single node. Every record has an offset, which starts from 0 and increases by 1, and a CRC which detects torn
+// writes and corruption. A segment file is named by the offset of its first record, and a record is
+//
+//	length(4) crc32(4) offset(8) value(length)
+//
+// where the crc covers the offset and the value.
+package filelog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	headerSize          = 16
+	segmentSuffix       = ".log"
+	fenceFile           = "fence"
+	lockFile            = "lock"
+	defaultSegmentBytes = 64 << 20
+)
+
+var (
+	ErrCorrupted = errors.New("filelog: corrupted record")
+	ErrFenced    = errors.New("filelog: fenced by a newer writer")
+)
+
+type Options struct {
+	// a new segment is started when the active one reaches this size
+	SegmentBytes int64
+
+	// sync the segment file after every append, otherwise the data is flushed by the OS
+	Fsync bool
+}
+
+// Log appends records to the last segment of a directory
+type Log struct {
+	dir     string
+	options Options
+
+	mu          sync.Mutex
+	segment     *os.File
+	segmentSize int64
+	nextOffset  int64
+
+	// the fencing token is kept in a file, and it is checked and raised under the file lock, so that a writer in
+	// another process is fenced as well
+	lockFile *os.File
+}
+
+// the logs opened in this process, dir -> *Log
+var logs = map[string]*Log{}
+var logsMu sync.Mutex
+
+// Open opens the log of the directory for appending, the callers in the same process share the same log. The
+// torn record left at the end of the last segment by a crash is truncated.
+func Open(dir string, options Options) (*Log, error) {
+	dir = filepath.Clean(dir)
+	if options.SegmentBytes <= 0 {
+		options.SegmentBytes = defaultSegmentBytes
+	}
+
+	logsMu.Lock()
+	defer logsMu.Unlock()
+	if l, found := logs[dir]; found {
+		return l, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, options: options}
+	l.lockFile, err = os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = l.openTail()
+	if err != nil {
+		return nil, err
+	}
+
+	logs[dir] = l
+	return l, nil
+}
+
+// Append appends the values as records, and returns the offset of the first one
+func (l *Log) Append(values ...[]byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.append(values)
+}
+
+// AppendFenced appends the values like Append if the fencing token is not older than the ones seen before, so
+// that a writer which is replaced by a newer one can no longer append
+func (l *Log) AppendFenced(token int64, values ...[]byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+
+	err = l.raiseFence(token)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(values)
+}
+
+// Fence rejects the appends with a token older than the given one from now on
+func (l *Log) Fence(token int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+
+	return l.raiseFence(token)
+}
+
+// raiseFence must be called under the file lock. When the fence is raised, the records appended by the former
+// writer, which may be in another process, are loaded.
+func (l *Log) raiseFence(token int64) error {
+	fence, err := readFence(l.dir)
+	if err != nil {
+		return err
+	}
+	if token < fence {
+		return ErrFenced
+	}
+	if token == fence {
+		return nil
+	}
+
+	// the fence survives restarts, write a temp file and rename it
+	path := filepath.Join(l.dir, fenceFile)
+	err = ioutil.WriteFile(path+".tmp", []byte(strconv.FormatInt(token, 10)), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return err
+	}
+
+	err = l.segment.Close()
+	if err != nil {
+		return err
+	}
+	return l.openTail()
+}
+
+func (l *Log) append(values [][]byte) (int64, error) {
+	if l.segmentSize >= l.options.SegmentBytes {
+		err := l.rollSegment()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	firstOffset := l.nextOffset
+	var buf []byte
+	for i, value := range values {
+		buf = append(buf, encodeRecord(firstOffset+int64(i), value)...)
+	}
+
+	_, err := l.segment.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+	if l.options.Fsync {
+		err = l.segment.Sync()
+		if err != nil {
+			return 0, err
+		}
+	}
+	l.segmentSize += int64(len(buf))
+	l.nextOffset += int64(len(values))
+
+	notifierOf(l.dir).broadcast()
+	return firstOffset, nil
+}
+
+// NextOffset returns the offset of the next record to be appended
+func (l *Log) NextOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextOffset
+}
+
+func (l *Log) rollSegment() error {
+	err := l.segment.Sync()
+	if err != nil {
+		return err
+	}
+	err = l.segment.Close()
+	if err != nil {
+		return err
+	}
+	return l.createSegment(l.nextOffset)
+}
+
+// openTail opens the last segment for appending, the torn record at the end of it is truncated
+func (l *Log) openTail() error {
+	bases, err := segmentBases(l.dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		l.nextOffset = 0
+		return l.createSegment(0)
+	}
+
+	base := bases[len(bases)-1]
+	size, nextOffset, err := recoverSegment(segmentPath(l.dir, base), base)
+	if err != nil {
+		return err
+	}
+
+	l.segment, err = os.OpenFile(segmentPath(l.dir, base), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	err = l.segment.Truncate(size)
+	if err != nil {
+		return err
+	}
+	_, err = l.segment.Seek(size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	l.segmentSize = size
+	l.nextOffset = nextOffset
+	return nil
+}
+
+func (l *Log) createSegment(base int64) error {
+	segment, err := os.OpenFile(segmentPath(l.dir, base), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.segment = segment
+	l.segmentSize = 0
+	return nil
+}
+
+// recoverSegment reads the records of the segment until the first one which is torn or corrupted, and returns
+// the size of the valid records and the offset after them.
+func recoverSegment(path string, base int64) (size, nextOffset int64, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nextOffset = base
+	for {
+		offset, value, ok := decodeRecord(buf[size:])
+		if !ok || offset != nextOffset {
+			return size, nextOffset, nil
+		}
+		size += int64(headerSize + len(value))
+		nextOffset++
+	}
+}
+
+func encodeRecord(offset int64, value []byte) []byte {
+	buf := make([]byte, headerSize+len(value))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(value)))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(offset))
+	copy(buf[headerSize:], value)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+	return buf
+}
+
+// decodeRecord decodes the record at the head of the buffer, it returns false if the record is incomplete or the
+// crc does not match
+func decodeRecord(buf []byte) (offset int64, value []byte, ok bool) {
+	if len(buf) < headerSize {
+		return 0, nil, false
+	}
+	length := int64(binary.BigEndian.Uint32(buf[0:4]))
+	if int64(len(buf)) < headerSize+length {
+		return 0, nil, false
+	}
+	if crc32.ChecksumIEEE(buf[8:headerSize+length]) != binary.BigEndian.Uint32(buf[4:8]) {
+		return 0, nil, false
+	}
+	return int64(binary.BigEndian.Uint64(buf[8:16])), buf[headerSize : headerSize+length], true
+}
+
+func readFence(dir string) (int64, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, fenceFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(string(buf), 10, 64)
+}
+
+func segmentPath(dir string, base int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%v", base, segmentSuffix))
+}
+
+// segmentBases returns the base offsets of the segments of the directory in ascending order
+func segmentBases(dir string) ([]int64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var bases []int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		return bases[i] < bases[j]
+	})
+	return bases, nil
+}
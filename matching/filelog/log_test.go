@@ -0,0 +1,323 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filelog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func openLog(t *testing.T, dir string, options Options) *Log {
+	l, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// closeLog closes the files of the log and forgets it, so that the next Open reads the directory again like
+// another process
+func closeLog(l *Log) {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+	_ = l.segment.Close()
+	_ = l.lockFile.Close()
+	delete(logs, l.dir)
+}
+
+func appendValues(t *testing.T, l *Log, values ...string) {
+	for _, value := range values {
+		_, err := l.Append([]byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readAll reads the records from the offset until the end of the log
+func readAll(t *testing.T, dir string, offset int64) []string {
+	reader := NewReader(dir)
+	defer func() { _ = reader.Close() }()
+	err := reader.SetOffset(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	for {
+		o, value, err := reader.TryRead()
+		if err == ErrNotReady {
+			return values
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, fmt.Sprintf("%v:%s", o, value))
+	}
+}
+
+func TestRoundTripAcrossSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	l := openLog(t, dir, Options{SegmentBytes: 40})
+	defer closeLog(l)
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		value := fmt.Sprintf("value-%v", i)
+		appendValues(t, l, value)
+		want = append(want, fmt.Sprintf("%v:%v", i, value))
+	}
+
+	bases, err := segmentBases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bases) < 3 {
+		t.Fatalf("segments %v, want the log rolled into several segments", bases)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   []string
+	}{
+		{name: "from the first record", offset: FirstOffset, want: want},
+		{name: "from a record in a later segment", offset: 7, want: want[7:]},
+		{name: "from the first record of a segment", offset: bases[1], want: want[bases[1]:]},
+		{name: "from the end", offset: LastOffset},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := readAll(t, dir, test.offset)
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("records %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if next := l.NextOffset(); next != 10 {
+		t.Errorf("next offset %v, want 10", next)
+	}
+}
+
+func TestRecoverTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(buf []byte) []byte
+	}{
+		{
+			name: "torn record",
+			damage: func(buf []byte) []byte {
+				return buf[:len(buf)-3]
+			},
+		},
+		{
+			name: "torn header",
+			damage: func(buf []byte) []byte {
+				return buf[:len(buf)-len("value-2")-headerSize+5]
+			},
+		},
+		{
+			name: "corrupted record",
+			damage: func(buf []byte) []byte {
+				buf[len(buf)-1] ^= 0xff
+				return buf
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer func() { _ = os.RemoveAll(dir) }()
+			l := openLog(t, dir, Options{})
+			appendValues(t, l, "value-0", "value-1", "value-2")
+			closeLog(l)
+
+			path := segmentPath(dir, 0)
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(path, test.damage(buf), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the damaged record is truncated and its offset is taken by the next append
+			l = openLog(t, dir, Options{})
+			defer closeLog(l)
+			if next := l.NextOffset(); next != 2 {
+				t.Fatalf("next offset %v, want 2", next)
+			}
+			appendValues(t, l, "value-3")
+
+			got := readAll(t, dir, FirstOffset)
+			want := []string{"0:value-0", "1:value-1", "2:value-3"}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("records %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReaderRejectsCorruptedRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	l := openLog(t, dir, Options{})
+	appendValues(t, l, "value-0", "value-1", "value-2")
+	closeLog(l)
+
+	// corrupt the value of the second record in place, the reader can't tell it from a record being written
+	// until the crc keeps failing
+	path := segmentPath(dir, 0)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[2*headerSize+len("value-0")] ^= 0xff
+	err = ioutil.WriteFile(path, buf, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(dir)
+	defer func() { _ = reader.Close() }()
+	err = reader.SetOffset(FirstOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, _, err := reader.TryRead()
+	if err != nil || offset != 0 {
+		t.Fatalf("first record %v %v, want 0", offset, err)
+	}
+	for i := 0; i < maxCRCRetries; i++ {
+		_, _, err = reader.TryRead()
+		if err != ErrNotReady {
+			t.Fatalf("read %v of the corrupted record returned %v, want ErrNotReady", i, err)
+		}
+	}
+	_, _, err = reader.TryRead()
+	if err != ErrCorrupted {
+		t.Errorf("read of the corrupted record returned %v, want ErrCorrupted", err)
+	}
+}
+
+func TestFencePersists(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	l := openLog(t, dir, Options{})
+
+	_, err := l.AppendFenced(2, []byte("leader-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.AppendFenced(1, []byte("leader-1"))
+	if err != ErrFenced {
+		t.Fatalf("append with a stale token returned %v, want ErrFenced", err)
+	}
+	closeLog(l)
+
+	// the fence is read from the file after a restart
+	if fence, err := readFence(dir); err != nil || fence != 2 {
+		t.Fatalf("fence %v %v, want 2", fence, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fenceFile+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("temp fence file is left: %v", err)
+	}
+	l = openLog(t, dir, Options{})
+	defer closeLog(l)
+	_, err = l.AppendFenced(1, []byte("leader-1"))
+	if err != ErrFenced {
+		t.Fatalf("append with a stale token after a restart returned %v, want ErrFenced", err)
+	}
+
+	// a new leader fences the log before it appends
+	err = l.Fence(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.AppendFenced(2, []byte("leader-2"))
+	if err != ErrFenced {
+		t.Fatalf("append of the replaced leader returned %v, want ErrFenced", err)
+	}
+	_, err = l.AppendFenced(3, []byte("leader-3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, dir, FirstOffset)
+	want := []string{"0:leader-2", "1:leader-3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestReaderFollowsWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	l := openLog(t, dir, Options{SegmentBytes: 64})
+	defer closeLog(l)
+
+	reader := NewReader(dir)
+	defer func() { _ = reader.Close() }()
+	err := reader.SetOffset(FirstOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = reader.TryRead()
+	if err != ErrNotReady {
+		t.Fatalf("read of an empty log returned %v, want ErrNotReady", err)
+	}
+
+	appendValues(t, l, "value-0")
+	offset, value, err := reader.TryRead()
+	if err != nil || offset != 0 || string(value) != "value-0" {
+		t.Fatalf("record %v %s %v, want 0 value-0", offset, value, err)
+	}
+	_, _, err = reader.TryRead()
+	if err != ErrNotReady {
+		t.Fatalf("read after the last record returned %v, want ErrNotReady", err)
+	}
+
+	// the writer appends and rolls the segments while the reader waits for the records
+	const count = 100
+	go func() {
+		for i := 1; i < count; i++ {
+			_, _ = l.Append([]byte(fmt.Sprintf("value-%v", i)))
+		}
+	}()
+	for i := 1; i < count; i++ {
+		offset, value, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != int64(i) || string(value) != fmt.Sprintf("value-%v", i) {
+			t.Fatalf("record %v %s, want %v value-%v", offset, value, i, i)
+		}
+	}
+}
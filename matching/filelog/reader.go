@@ -0,0 +1,226 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filelog
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// read from the end of the log, only the records appended later are read
+	LastOffset = -1
+	// read from the first record of the log
+	FirstOffset = -2
+
+	// the appends of other processes are not notified, they are found by polling
+	pollInterval = 100 * time.Millisecond
+
+	// a record whose crc does not match may still be being written, it is corrupted if it does not match after
+	// being read again so many times
+	maxCRCRetries = 20
+)
+
+// Reader reads the records of a log in order, and follows the tail of the log. A reader is not safe for
+// concurrent use.
+type Reader struct {
+	dir string
+
+	// the current segment, and the file position and the offset of the next record in it
+	segment  *os.File
+	base     int64
+	position int64
+	offset   int64
+
+	crcFailures int
+}
+
+func NewReader(dir string) *Reader {
+	return &Reader{dir: filepath.Clean(dir)}
+}
+
+// SetOffset moves the reader to the record of the offset, or to the end of the log if the offset is beyond it. The
+// offsets before the first segment start from the first record.
+func (r *Reader) SetOffset(offset int64) error {
+	bases, err := segmentBases(r.dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		// the log is not created yet, the first segment starts from 0
+		bases = []int64{0}
+	}
+
+	base := bases[0]
+	if offset == LastOffset {
+		base = bases[len(bases)-1]
+	}
+	for _, b := range bases {
+		if b <= offset {
+			base = b
+		}
+	}
+	r.openSegment(base)
+
+	for offset == LastOffset || r.offset < offset {
+		_, _, err := r.next()
+		if err == ErrNotReady {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read returns the next record, it waits until the record is appended
+func (r *Reader) Read() (offset int64, value []byte, err error) {
+	for {
+		// take the channel before reading, so that an append after the read is never missed
+		appended := notifierOf(r.dir).channel()
+
+		offset, value, err = r.TryRead()
+		if err != ErrNotReady {
+			return offset, value, err
+		}
+
+		select {
+		case <-appended:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// TryRead returns the next record without waiting, the error is ErrNotReady if the record is not appended yet
+func (r *Reader) TryRead() (offset int64, value []byte, err error) {
+	for {
+		offset, value, err = r.next()
+		if err != ErrNotReady {
+			return offset, value, err
+		}
+
+		// the current segment is complete when the next one is created, read it again in case records were
+		// appended before the roll
+		if _, err := os.Stat(segmentPath(r.dir, r.offset)); err == nil && r.offset != r.base {
+			offset, value, err = r.next()
+			if err != ErrNotReady {
+				return offset, value, err
+			}
+			r.openSegment(r.offset)
+			continue
+		}
+		return 0, nil, ErrNotReady
+	}
+}
+
+func (r *Reader) Close() error {
+	if r.segment == nil {
+		return nil
+	}
+	return r.segment.Close()
+}
+
+type readerError string
+
+func (e readerError) Error() string {
+	return string(e)
+}
+
+// ErrNotReady means the next record is not completely appended yet
+const ErrNotReady = readerError("filelog: record not ready")
+
+func (r *Reader) openSegment(base int64) {
+	if r.segment != nil {
+		_ = r.segment.Close()
+		r.segment = nil
+	}
+	r.base = base
+	r.position = 0
+	r.offset = base
+	r.crcFailures = 0
+}
+
+func (r *Reader) next() (int64, []byte, error) {
+	if r.segment == nil {
+		segment, err := os.Open(segmentPath(r.dir, r.base))
+		if os.IsNotExist(err) {
+			return 0, nil, ErrNotReady
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		r.segment = segment
+	}
+
+	header := make([]byte, headerSize)
+	n, _ := r.segment.ReadAt(header, r.position)
+	if n < headerSize {
+		return 0, nil, ErrNotReady
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	buf := make([]byte, headerSize+length)
+	n, _ = r.segment.ReadAt(buf, r.position)
+	if int64(n) < headerSize+length {
+		return 0, nil, ErrNotReady
+	}
+
+	if crc32.ChecksumIEEE(buf[8:]) != binary.BigEndian.Uint32(buf[4:8]) {
+		r.crcFailures++
+		if r.crcFailures > maxCRCRetries {
+			return 0, nil, ErrCorrupted
+		}
+		return 0, nil, ErrNotReady
+	}
+	r.crcFailures = 0
+
+	offset := int64(binary.BigEndian.Uint64(buf[8:16]))
+	if offset != r.offset {
+		return 0, nil, ErrCorrupted
+	}
+	r.position += headerSize + length
+	r.offset++
+	return offset, buf[headerSize:], nil
+}
+
+// notifier wakes up the readers of a log in this process when records are appended
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var notifiers sync.Map
+
+func notifierOf(dir string) *notifier {
+	n, _ := notifiers.LoadOrStore(dir, &notifier{ch: make(chan struct{})})
+	return n.(*notifier)
+}
+
+func (n *notifier) channel() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *notifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
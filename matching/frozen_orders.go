@@ -0,0 +1,83 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"sync"
+)
+
+// frozenOrders is a copy on write view of the orders on the book at the time of a snapshot. Freezing the order
+// book only collects the pointers of the orders, and the snapshot goroutine copies the orders out later while
+// the order book goes on matching. The order book saves an order into the view before the order is updated in
+// place for the first time, so that the view always sees the orders as they were at the time of the snapshot.
+type frozenOrders struct {
+	version int64
+	orders  []*BookOrder
+
+	// the orders updated before they are copied, order -> value at the time of the snapshot
+	mu    sync.Mutex
+	saved map[*BookOrder]BookOrder
+	done  bool
+}
+
+func newFrozenOrders(version int64, size int) *frozenOrders {
+	return &frozenOrders{
+		version: version,
+		orders:  make([]*BookOrder, 0, size),
+		saved:   map[*BookOrder]BookOrder{},
+	}
+}
+
+// save keeps the value of the order before it is updated, it is called in the order book goroutine
+func (f *frozenOrders) save(order *BookOrder) {
+	if order.frozenVersion >= f.version {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.done {
+		f.saved[order] = *order
+	}
+	order.frozenVersion = f.version
+}
+
+// copyOrders returns the orders at the time of the snapshot, it is called in the snapshot goroutine. The lock
+// is taken order by order, so that the order book is never blocked for long.
+func (f *frozenOrders) copyOrders() []BookOrder {
+	orders := make([]BookOrder, len(f.orders))
+	for i, order := range f.orders {
+		f.mu.Lock()
+		if saved, found := f.saved[order]; found {
+			orders[i] = saved
+		} else {
+			orders[i] = *order
+		}
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done = true
+	f.saved = nil
+	f.orders = nil
+	return orders
+}
+
+func (f *frozenOrders) isDone() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.done
+}
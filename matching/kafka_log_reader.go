@@ -16,7 +16,6 @@ package matching
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/segmentio/kafka-go"
 	logger "github.com/siddontang/go-log/log"
 )
@@ -31,7 +30,7 @@ type KafkaLogReader struct {
 func NewKafkaLogReader(readerId, productId string, brokers []string) LogReader {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:   brokers,
-		Topic:     topicBookMessagePrefix + productId,
+		Topic:     TopicBookMessagePrefix + productId,
 		Partition: 0,
 		MinBytes:  1,
 		MaxBytes:  10e6,
@@ -50,61 +49,18 @@ func (r *KafkaLogReader) RegisterObserver(observer LogObserver) {
 func (r *KafkaLogReader) Run(seq, offset int64) {
 	logger.Infof("%v:%v read from %v", r.productId, r.readerId, offset)
 
-	var lastSeq = seq
-
 	err := r.reader.SetOffset(offset)
 	if err != nil {
 		panic(err)
 	}
 
+	dispatcher := newLogDispatcher(r.readerId, r.productId, r.observer, seq)
 	for {
 		kMessage, err := r.reader.FetchMessage(context.Background())
 		if err != nil {
 			logger.Error(err)
 			continue
 		}
-
-		var base Base
-		err = json.Unmarshal(kMessage.Value, &base)
-		if err != nil {
-			panic(err)
-		}
-
-		if base.Sequence <= lastSeq {
-			// 丢弃重复的log
-			logger.Infof("%v:%v discard log :%+v", r.productId, r.readerId, base)
-			continue
-		} else if lastSeq > 0 && base.Sequence != lastSeq+1 {
-			// seq发生不连续，可能是撮合引擎发生了严重错误
-			logger.Fatalf("non-sequence detected, lastSeq=%v seq=%v", lastSeq, base.Sequence)
-		}
-		lastSeq = base.Sequence
-
-		switch base.Type {
-		case LogTypeOpen:
-			var log OpenLog
-			err := json.Unmarshal(kMessage.Value, &log)
-			if err != nil {
-				panic(err)
-			}
-			r.observer.OnOpenLog(&log, kMessage.Offset)
-
-		case LogTypeMatch:
-			var log MatchLog
-			err := json.Unmarshal(kMessage.Value, &log)
-			if err != nil {
-				panic(err)
-			}
-			r.observer.OnMatchLog(&log, kMessage.Offset)
-
-		case LogTypeDone:
-			var log DoneLog
-			err := json.Unmarshal(kMessage.Value, &log)
-			if err != nil {
-				panic(err)
-			}
-			r.observer.OnDoneLog(&log, kMessage.Offset)
-
-		}
+		dispatcher.dispatch(kMessage.Value, kMessage.Offset)
 	}
 }
@@ -22,7 +22,7 @@ import (
 )
 
 const (
-	topicBookMessagePrefix = "matching_message_"
+	TopicBookMessagePrefix = "matching_message_"
 )
 
 type KafkaLogStore struct {
@@ -34,7 +34,7 @@ func NewKafkaLogStore(productId string, brokers []string) *KafkaLogStore {
 
 	s.logWriter = kafka.NewWriter(kafka.WriterConfig{
 		Brokers:      brokers,
-		Topic:        topicBookMessagePrefix + productId,
+		Topic:        TopicBookMessagePrefix + productId,
 		Balancer:     &kafka.LeastBytes{},
 		BatchTimeout: 5 * time.Millisecond,
 	})
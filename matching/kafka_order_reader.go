@@ -46,16 +46,37 @@ func (s *KafkaOrderReader) SetOffset(offset int64) error {
 	return s.orderReader.SetOffset(offset)
 }
 
-func (s *KafkaOrderReader) FetchOrder() (offset int64, order *models.Order, err error) {
+func (s *KafkaOrderReader) FetchOrder() (offset int64, order *models.Order, command *Command, err error) {
 	message, err := s.orderReader.FetchMessage(context.Background())
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
 
-	err = json.Unmarshal(message.Value, &order)
+	order, command, err = decodeOrderMessage(message.Value)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
+	return message.Offset, order, command, nil
+}
+
+func (s *KafkaOrderReader) Close() error {
+	return s.orderReader.Close()
+}
 
-	return message.Offset, order, nil
+// decodeOrderMessage decodes a message of the order topic, which is either an order or a command
+func decodeOrderMessage(value []byte) (order *models.Order, command *Command, err error) {
+	// 带有CommandType的消息是command，否则是order
+	err = json.Unmarshal(value, &command)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(command.CommandType) != 0 {
+		return nil, command, nil
+	}
+
+	err = json.Unmarshal(value, &order)
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, nil, nil
 }
@@ -0,0 +1,41 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+type KafkaOrderWriter struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaOrderWriter(productId string, brokers []string) *KafkaOrderWriter {
+	s := &KafkaOrderWriter{}
+
+	s.writer = kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        TopicOrderPrefix + productId,
+		Balancer:     &kafka.LeastBytes{},
+		BatchTimeout: 5 * time.Millisecond,
+	})
+	return s
+}
+
+func (s *KafkaOrderWriter) Write(value []byte) error {
+	return s.writer.WriteMessages(context.Background(), kafka.Message{Value: value})
+}
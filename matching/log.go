@@ -23,9 +23,25 @@ import (
 type LogType string
 
 const (
-	LogTypeMatch = LogType("match")
-	LogTypeOpen  = LogType("open")
-	LogTypeDone  = LogType("done")
+	// 订单被撮合引擎接收，之后的状态变化由open、match、done等日志描述
+	LogTypeReceived = LogType("received")
+	// 订单被撮合引擎拒绝，如订单重复提交
+	LogTypeRejected = LogType("rejected")
+	LogTypeMatch    = LogType("match")
+	LogTypeOpen     = LogType("open")
+	LogTypeDone     = LogType("done")
+	// 止损/止盈单被触发，成为普通的limit/market订单
+	LogTypeActivate = LogType("activate")
+	// 订单在orderBook中的数量或者价格发生了变化，如自成交保护减少了maker的数量，或者订单被修改
+	LogTypeChange = LogType("change")
+	// 产品的交易状态发生了变化
+	LogTypeStatus = LogType("status")
+	// 集合竞价的参考价格或者数量发生了变化
+	LogTypeAuction = LogType("auction")
+	// 成交价格超出价格区间，触发熔断
+	LogTypeBreaker = LogType("breaker")
+	// 跟踪止损/止盈单的触发价格随成交价格移动
+	LogTypeTrail = LogType("trail")
 )
 
 type Log interface {
@@ -36,37 +52,97 @@ type Base struct {
 	Type      LogType
 	Sequence  int64
 	ProductId string
-	Time      time.Time
+	// 撮合引擎的时钟，来自order和command的时间而不是系统时间，重放同样的输入得到同样的日志
+	Time time.Time
 }
 
 type ReceivedLog struct {
 	Base
 	OrderId   int64
 	Size      decimal.Decimal
+	Funds     decimal.Decimal
 	Price     decimal.Decimal
 	Side      models.Side
 	OrderType models.OrderType
+
+	// an iceberg order only shows the display size of its size, a hidden order is not shown at all
+	DisplaySize decimal.Decimal
+	Hidden      bool
+}
+
+func newReceivedLog(logSeq int64, productId string, logTime time.Time, order *models.Order) *ReceivedLog {
+	return &ReceivedLog{
+		Base:        Base{LogTypeReceived, logSeq, productId, logTime},
+		OrderId:     order.Id,
+		Size:        order.Size,
+		Funds:       order.Funds,
+		Price:       order.Price,
+		Side:        order.Side,
+		OrderType:   order.Type,
+		DisplaySize: order.DisplaySize,
+		Hidden:      order.Hidden,
+	}
 }
 
 func (l *ReceivedLog) GetSeq() int64 {
 	return l.Sequence
 }
 
+// RejectLog is emitted for an order which never enters the order book, e.g. it is submitted repeatedly
+type RejectLog struct {
+	Base
+	OrderId   int64
+	Size      decimal.Decimal
+	Funds     decimal.Decimal
+	Price     decimal.Decimal
+	Side      models.Side
+	OrderType models.OrderType
+	Reason    models.DoneReason
+
+	// an iceberg order only shows the display size of its size, a hidden order is not shown at all
+	DisplaySize decimal.Decimal
+	Hidden      bool
+}
+
+func newRejectLog(logSeq int64, productId string, logTime time.Time, order *models.Order,
+	reason models.DoneReason) *RejectLog {
+	return &RejectLog{
+		Base:        Base{LogTypeRejected, logSeq, productId, logTime},
+		OrderId:     order.Id,
+		Size:        order.Size,
+		Funds:       order.Funds,
+		Price:       order.Price,
+		Side:        order.Side,
+		OrderType:   order.Type,
+		Reason:      reason,
+		DisplaySize: order.DisplaySize,
+		Hidden:      order.Hidden,
+	}
+}
+
+func (l *RejectLog) GetSeq() int64 {
+	return l.Sequence
+}
+
 type OpenLog struct {
 	Base
 	OrderId       int64
 	RemainingSize decimal.Decimal
 	Price         decimal.Decimal
 	Side          models.Side
+
+	// a hidden order is on the book but not shown
+	Hidden bool
 }
 
-func newOpenLog(logSeq int64, productId string, takerOrder *BookOrder) *OpenLog {
+func newOpenLog(logSeq int64, productId string, logTime time.Time, takerOrder *BookOrder) *OpenLog {
 	return &OpenLog{
-		Base:          Base{LogTypeOpen, logSeq, productId, time.Now()},
+		Base:          Base{LogTypeOpen, logSeq, productId, logTime},
 		OrderId:       takerOrder.OrderId,
-		RemainingSize: takerOrder.Size,
+		RemainingSize: takerOrder.visibleSize(),
 		Price:         takerOrder.Price,
 		Side:          takerOrder.Side,
+		Hidden:        takerOrder.Hidden,
 	}
 }
 
@@ -81,16 +157,34 @@ type DoneLog struct {
 	RemainingSize decimal.Decimal
 	Reason        models.DoneReason
 	Side          models.Side
+
+	// part of the remaining size shown on the book, it is less than RemainingSize for an iceberg order, a hidden
+	// order is not shown at all
+	VisibleSize decimal.Decimal
+	Hidden      bool
 }
 
-func newDoneLog(logSeq int64, productId string, order *BookOrder, remainingSize decimal.Decimal, reason models.DoneReason) *DoneLog {
+func newDoneLog(logSeq int64, productId string, logTime time.Time, order *BookOrder, remainingSize decimal.Decimal,
+	reason models.DoneReason) *DoneLog {
+	// an iceberg order which is not on the book, e.g. an IOC taker, would show a slice of the display size
+	visibleSize := remainingSize
+	if order.isIceberg() {
+		slice := order.VisibleSize
+		if slice.IsZero() {
+			slice = order.DisplaySize
+		}
+		visibleSize = decimal.Min(slice, remainingSize)
+	}
+
 	return &DoneLog{
-		Base:          Base{LogTypeDone, logSeq, productId, time.Now()},
+		Base:          Base{LogTypeDone, logSeq, productId, logTime},
 		OrderId:       order.OrderId,
 		Price:         order.Price,
 		RemainingSize: remainingSize,
 		Reason:        reason,
 		Side:          order.Side,
+		VisibleSize:   visibleSize,
+		Hidden:        order.Hidden,
 	}
 }
 
@@ -98,6 +192,42 @@ func (l *DoneLog) GetSeq() int64 {
 	return l.Sequence
 }
 
+type ChangeLog struct {
+	Base
+	OrderId  int64
+	Price    decimal.Decimal
+	OldPrice decimal.Decimal
+	OldSize  decimal.Decimal
+	NewSize  decimal.Decimal
+	Side     models.Side
+
+	// size shown on the book before and after the change, they are less than OldSize and NewSize for an iceberg
+	// order
+	OldVisibleSize decimal.Decimal
+	VisibleSize    decimal.Decimal
+	Hidden         bool
+}
+
+func newChangeLog(logSeq int64, productId string, logTime time.Time, order *BookOrder,
+	oldSize, oldVisibleSize, oldPrice decimal.Decimal) *ChangeLog {
+	return &ChangeLog{
+		Base:           Base{LogTypeChange, logSeq, productId, logTime},
+		OrderId:        order.OrderId,
+		Price:          order.Price,
+		OldPrice:       oldPrice,
+		OldSize:        oldSize,
+		NewSize:        order.Size,
+		Side:           order.Side,
+		OldVisibleSize: oldVisibleSize,
+		VisibleSize:    order.visibleSize(),
+		Hidden:         order.Hidden,
+	}
+}
+
+func (l *ChangeLog) GetSeq() int64 {
+	return l.Sequence
+}
+
 type MatchLog struct {
 	Base
 	TradeId      int64
@@ -106,20 +236,161 @@ type MatchLog struct {
 	Side         models.Side
 	Price        decimal.Decimal
 	Size         decimal.Decimal
+
+	// the maker is a hidden order which is not shown on the book
+	MakerHidden bool
 }
 
-func newMatchLog(logSeq int64, productId string, tradeSeq int64, takerOrder, makerOrder *BookOrder, price, size decimal.Decimal) *MatchLog {
+func newMatchLog(logSeq int64, productId string, logTime time.Time, tradeSeq int64, takerOrder, makerOrder *BookOrder,
+	price, size decimal.Decimal) *MatchLog {
 	return &MatchLog{
-		Base:         Base{LogTypeMatch, logSeq, productId, time.Now()},
+		Base:         Base{LogTypeMatch, logSeq, productId, logTime},
 		TradeId:      tradeSeq,
 		TakerOrderId: takerOrder.OrderId,
 		MakerOrderId: makerOrder.OrderId,
 		Side:         makerOrder.Side,
 		Price:        price,
 		Size:         size,
+		MakerHidden:  makerOrder.Hidden,
 	}
 }
 
 func (l *MatchLog) GetSeq() int64 {
 	return l.Sequence
 }
+
+type ActivateLog struct {
+	Base
+	OrderId   int64
+	Size      decimal.Decimal
+	Funds     decimal.Decimal
+	Price     decimal.Decimal
+	StopPrice decimal.Decimal
+	Stop      models.Stop
+	Side      models.Side
+	OrderType models.OrderType
+
+	// the exit of a bracket is released by the fill of its entry rather than triggered by its stop price, a
+	// stop order still waits for its stop price after it is released
+	Released bool
+
+	// an iceberg order only shows the display size of its size, a hidden order is not shown at all
+	DisplaySize decimal.Decimal
+	Hidden      bool
+}
+
+func newActivateLog(logSeq int64, productId string, logTime time.Time, order *models.Order) *ActivateLog {
+	return &ActivateLog{
+		Base:        Base{LogTypeActivate, logSeq, productId, logTime},
+		OrderId:     order.Id,
+		Size:        order.Size,
+		Funds:       order.Funds,
+		Price:       order.Price,
+		StopPrice:   order.StopPrice,
+		Stop:        order.Stop,
+		Side:        order.Side,
+		OrderType:   order.Type,
+		DisplaySize: order.DisplaySize,
+		Hidden:      order.Hidden,
+	}
+}
+
+func (l *ActivateLog) GetSeq() int64 {
+	return l.Sequence
+}
+
+type StatusLog struct {
+	Base
+	OldStatus models.ProductStatus
+	Status    models.ProductStatus
+}
+
+func newStatusLog(logSeq int64, productId string, logTime time.Time,
+	oldStatus, status models.ProductStatus) *StatusLog {
+	return &StatusLog{
+		Base:      Base{LogTypeStatus, logSeq, productId, logTime},
+		OldStatus: oldStatus,
+		Status:    status,
+	}
+}
+
+func (l *StatusLog) GetSeq() int64 {
+	return l.Sequence
+}
+
+type AuctionLog struct {
+	Base
+	// indicative uncross price and volume of the call auction, they are zero if the book is not crossed
+	Price  decimal.Decimal
+	Volume decimal.Decimal
+	// bid volume minus ask volume at the indicative price
+	Imbalance decimal.Decimal
+}
+
+func newAuctionLog(logSeq int64, productId string, logTime time.Time,
+	price, volume, imbalance decimal.Decimal) *AuctionLog {
+	return &AuctionLog{
+		Base:      Base{LogTypeAuction, logSeq, productId, logTime},
+		Price:     price,
+		Volume:    volume,
+		Imbalance: imbalance,
+	}
+}
+
+func (l *AuctionLog) GetSeq() int64 {
+	return l.Sequence
+}
+
+type BreakerLog struct {
+	Base
+	// the price band around the reference price
+	ReferencePrice decimal.Decimal
+	LowPrice       decimal.Decimal
+	HighPrice      decimal.Decimal
+	// price of the trade which would be made outside the price band
+	Price decimal.Decimal
+	// the trading status after the trip, and the end of the call auction if the status is auction
+	Status models.ProductStatus
+	Until  time.Time
+}
+
+func newBreakerLog(logSeq int64, productId string, logTime time.Time,
+	referencePrice, lowPrice, highPrice, price decimal.Decimal,
+	status models.ProductStatus, until time.Time) *BreakerLog {
+	return &BreakerLog{
+		Base:           Base{LogTypeBreaker, logSeq, productId, logTime},
+		ReferencePrice: referencePrice,
+		LowPrice:       lowPrice,
+		HighPrice:      highPrice,
+		Price:          price,
+		Status:         status,
+		Until:          until,
+	}
+}
+
+func (l *BreakerLog) GetSeq() int64 {
+	return l.Sequence
+}
+
+type TrailLog struct {
+	Base
+	OrderId int64
+	// the new stop price of the trailing stop order
+	StopPrice decimal.Decimal
+	Stop      models.Stop
+	Side      models.Side
+}
+
+func newTrailLog(logSeq int64, productId string, logTime time.Time, order *models.Order) *TrailLog {
+	return &TrailLog{
+		Base:      Base{LogTypeTrail, logSeq, productId, logTime},
+		OrderId:   order.Id,
+		StopPrice: order.StopPrice,
+		Stop:      order.Stop,
+		Side:      order.Side,
+	}
+}
+
+func (l *TrailLog) GetSeq() int64 {
+	return l.Sequence
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"encoding/json"
+	logger "github.com/siddontang/go-log/log"
+)
+
+// logDispatcher decodes the logs read from the log topic, discards the duplicated ones and notifies the observer,
+// it is shared by the LogReader implementations
+type logDispatcher struct {
+	readerId  string
+	productId string
+	observer  LogObserver
+	lastSeq   int64
+}
+
+func newLogDispatcher(readerId, productId string, observer LogObserver, seq int64) *logDispatcher {
+	return &logDispatcher{readerId: readerId, productId: productId, observer: observer, lastSeq: seq}
+}
+
+func (d *logDispatcher) dispatch(value []byte, offset int64) {
+	var base Base
+	err := json.Unmarshal(value, &base)
+	if err != nil {
+		panic(err)
+	}
+
+	if base.Sequence <= d.lastSeq {
+		// 丢弃重复的log
+		logger.Infof("%v:%v discard log :%+v", d.productId, d.readerId, base)
+		return
+	} else if d.lastSeq > 0 && base.Sequence != d.lastSeq+1 {
+		// seq发生不连续，可能是撮合引擎发生了严重错误
+		logger.Fatalf("non-sequence detected, lastSeq=%v seq=%v", d.lastSeq, base.Sequence)
+	}
+	d.lastSeq = base.Sequence
+
+	switch base.Type {
+	case LogTypeOpen:
+		var log OpenLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnOpenLog(&log, offset)
+
+	case LogTypeReceived:
+		var log ReceivedLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnReceivedLog(&log, offset)
+
+	case LogTypeRejected:
+		var log RejectLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnRejectLog(&log, offset)
+
+	case LogTypeMatch:
+		var log MatchLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnMatchLog(&log, offset)
+
+	case LogTypeDone:
+		var log DoneLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnDoneLog(&log, offset)
+
+	case LogTypeActivate:
+		var log ActivateLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnActivateLog(&log, offset)
+
+	case LogTypeChange:
+		var log ChangeLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnChangeLog(&log, offset)
+
+	case LogTypeStatus:
+		var log StatusLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnStatusLog(&log, offset)
+
+	case LogTypeAuction:
+		var log AuctionLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnAuctionLog(&log, offset)
+
+	case LogTypeBreaker:
+		var log BreakerLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnBreakerLog(&log, offset)
+
+	case LogTypeTrail:
+		var log TrailLog
+		err := json.Unmarshal(value, &log)
+		if err != nil {
+			panic(err)
+		}
+		d.observer.OnTrailLog(&log, offset)
+
+	}
+}
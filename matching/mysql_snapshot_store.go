@@ -0,0 +1,73 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/service"
+	logger "github.com/siddontang/go-log/log"
+)
+
+// MysqlSnapshotStore keeps the latest snapshots of a product in the engine snapshot table
+type MysqlSnapshotStore struct {
+	productId string
+	keep      int
+}
+
+func NewMysqlSnapshotStore(productId string, keep int) SnapshotStore {
+	return &MysqlSnapshotStore{productId: productId, keep: keep}
+}
+
+func (s *MysqlSnapshotStore) Store(snapshot *Snapshot) error {
+	meta, data, err := encodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return service.AddEngineSnapshot(&models.EngineSnapshot{
+		CreatedAt:   meta.CreatedAt,
+		ProductId:   s.productId,
+		OrderOffset: meta.OrderOffset,
+		LogSeq:      meta.LogSeq,
+		Checksum:    meta.Checksum,
+		Data:        data,
+	}, s.keep)
+}
+
+// GetLatest returns the newest snapshot which passes the verification
+func (s *MysqlSnapshotStore) GetLatest() (*Snapshot, error) {
+	engineSnapshots, err := service.GetEngineSnapshotsByProductId(s.productId, s.keep)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, engineSnapshot := range engineSnapshots {
+		snapshot, err := decodeSnapshot(&SnapshotMeta{
+			OrderOffset: engineSnapshot.OrderOffset,
+			LogSeq:      engineSnapshot.LogSeq,
+			CreatedAt:   engineSnapshot.CreatedAt,
+			Checksum:    engineSnapshot.Checksum,
+		}, engineSnapshot.Data)
+		if err != nil {
+			logger.Warnf("skip snapshot %v: %v", engineSnapshot.Id, err)
+			continue
+		}
+		return snapshot, nil
+	}
+	if len(engineSnapshots) != 0 {
+		return nil, errNoValidSnapshot
+	}
+	return nil, nil
+}
@@ -22,6 +22,8 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/siddontang/go-log/log"
 	"math"
+	"sort"
+	"time"
 )
 
 const (
@@ -44,13 +46,51 @@ type orderBook struct {
 	// to prevent the order from being submitted to the order book repeatedly,
 	// a sliding window de duplication strategy is adopted.
 	orderIdWindow Window
+
+	// the clock of the order book, it only moves forward with the time of the orders read from the order
+	// stream, so that GTT orders expire at the same point no matter when the stream is (re)played.
+	time time.Time
+
+	// GTT orders ordered by expire time
+	// expireTimeOrderIdKey -> side
+	expiryQueue *treemap.Map
+
+	// stop orders waiting to be triggered
+	triggerBook *triggerBook
+
+	// OCO and bracket groups whose orders are cancelled together
+	groups *groupBook
+
+	// pegged orders on the book, orderId -> side, the orders which are done are removed when they are repriced
+	pegOrders map[int64]models.Side
+
+	// trading status of the product, it decides which orders are accepted
+	status models.ProductStatus
+
+	// price of the last trade, it is the reference price of the call auction
+	lastPrice decimal.Decimal
+
+	// the last published indicative price and volume of the call auction
+	indicativePrice  decimal.Decimal
+	indicativeVolume decimal.Decimal
+
+	// end time of the call auction started by the circuit breaker, the continuous trading is reopened when the
+	// clock reaches it. It is zero if the circuit breaker is not tripped, or the product is halted by it.
+	breakerUntil time.Time
+
+	// strictly continuously increasing queue SEQ, it is the time priority of the orders in the depth queue,
+	// an order takes a new one when it loses its priority, e.g. its price is amended
+	queueSeq int64
+
+	// the orders of the last snapshot, they are copied out of the book by the snapshot goroutine
+	frozen *frozenOrders
 }
 
 type orderBookSnapshot struct {
 	// order book product id
 	ProductId string
 
-	// all orders
+	// all orders, the orders of an older snapshot have no Hidden and MinQty, they are restored as lit orders
 	Orders []BookOrder
 
 	// trade seq at snapshot time
@@ -61,13 +101,43 @@ type orderBookSnapshot struct {
 
 	// state of de duplication window
 	OrderIdWindow Window
+
+	// clock of the order book at snapshot time
+	Time time.Time
+
+	// all untriggered stop orders
+	StopOrders []models.Order
+
+	// all order groups
+	Groups []orderGroup
+
+	// queue seq at snapshot time
+	QueueSeq int64
+
+	// trading status at snapshot time
+	Status models.ProductStatus
+
+	// last trade price and call auction state at snapshot time
+	LastPrice        decimal.Decimal
+	IndicativePrice  decimal.Decimal
+	IndicativeVolume decimal.Decimal
+
+	// circuit breaker state at snapshot time
+	BreakerUntil time.Time
 }
 
 type priceOrderIdKey struct {
 	price   decimal.Decimal
+	hidden  bool
+	seq     int64
 	orderId int64
 }
 
+type expireTimeOrderIdKey struct {
+	expireTime time.Time
+	orderId    int64
+}
+
 func NewOrderBook(product *models.Product) *orderBook {
 	asks := &depth{
 		queue:  treemap.NewWith(priceOrderIdKeyAscComparator),
@@ -82,58 +152,241 @@ func NewOrderBook(product *models.Product) *orderBook {
 		product:       product,
 		depths:        map[models.Side]*depth{models.SideBuy: bids, models.SideSell: asks},
 		orderIdWindow: newWindow(0, orderIdWindowCap),
+		expiryQueue:   treemap.NewWith(expireTimeOrderIdKeyComparator),
+		triggerBook:   newTriggerBook(),
+		groups:        newGroupBook(),
+		pegOrders:     map[int64]models.Side{},
+		// the status in the database is not sequenced with the orders, the book starts open and only the status
+		// commands in the order topic change it, so that a replay from the beginning is deterministic
+		status: models.ProductStatusOpen,
 	}
 	return orderBook
 }
 
 func (o *orderBook) ApplyOrder(order *models.Order) (logs []Log) {
-	// prevent orders from being submitted repeatedly to the matching engine
-	err := o.orderIdWindow.put(order.Id)
-	if err != nil {
-		log.Error(err)
-		return logs
+	// publish the new indicative price and volume if the order changes them in call auction
+	defer func() { logs = append(logs, o.updateIndicative()...) }()
+	// reprice the pegged orders at the top of book left by the order and its follow ups
+	defer func() { logs = append(logs, o.repricePegs()...) }()
+	// cancel the siblings of grouped orders and trigger stop orders in the same sequence step
+	defer func() { logs = o.followUpLogs(logs) }()
+
+	// expire GTT orders before the new order has a chance to match them
+	logs = o.expireOrders(order.CreatedAt)
+	logs = append(logs, o.endBreakerAuction()...)
+
+	// prevent orders from being submitted repeatedly to the matching engine, the dedup window is checked first
+	// so that a duplicate is never taken as a new order
+	reason := o.putOrderId(order.Id)
+	if len(reason) == 0 {
+		reason = o.validateOrder(order)
+	}
+	if len(reason) != 0 {
+		log.Warnf("order %v rejected: %v", order.Id, reason)
+		return append(logs, newRejectLog(o.nextLogSeq(), o.product.Id, o.time, order, reason))
+	}
+
+	logs = append(logs, newReceivedLog(o.nextLogSeq(), o.product.Id, o.time, order))
+	return append(logs, o.acceptOrder(order)...)
+}
+
+// putOrderId puts the id of the new order into the dedup window, it returns the reject reason if the id is refused
+func (o *orderBook) putOrderId(orderId int64) models.DoneReason {
+	switch o.orderIdWindow.put(orderId) {
+	case nil:
+		return ""
+	case errExpiredVal:
+		return models.DoneReasonExpiredWindow
+	default:
+		return models.DoneReasonDuplicate
+	}
+}
+
+// validateOrder returns the reject reason of a new order which can never enter the order book, the orders which
+// are only refused by the current trading status are done with statusRejected instead.
+func (o *orderBook) validateOrder(order *models.Order) models.DoneReason {
+	if o.status == models.ProductStatusHalted {
+		return models.DoneReasonProductHalted
+	}
+
+	// the price of a pegged order is decided by the engine
+	if order.Type == models.OrderTypeLimit && len(order.Peg) == 0 && order.Price.LessThanOrEqual(decimal.Zero) {
+		return models.DoneReasonInvalidPrice
+	}
+	if order.Type == models.OrderTypeMarket && order.Side == models.SideBuy {
+		if order.Size.LessThanOrEqual(decimal.Zero) && order.Funds.LessThanOrEqual(decimal.Zero) {
+			return models.DoneReasonInvalidSize
+		}
+	} else if order.Size.LessThanOrEqual(decimal.Zero) {
+		return models.DoneReasonInvalidSize
+	}
+	return ""
+}
+
+// acceptOrder puts the new order into the order book, the trading status decides whether it is accepted
+func (o *orderBook) acceptOrder(order *models.Order) []Log {
+	if !o.isAcceptable(order) {
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(order), order.Size,
+			models.DoneReasonStatusRejected)
+		return []Log{doneLog}
+	}
+
+	// a stop order waits in the trigger book until a trade reaches its stop price
+	if len(order.Stop) != 0 {
+		o.triggerBook.add(order)
+		if isTrailing(order) && o.lastPrice.GreaterThan(decimal.Zero) {
+			if trailLog := o.trailStopOrder(order, o.lastPrice); trailLog != nil {
+				return []Log{trailLog}
+			}
+		}
+		return nil
+	}
+
+	return o.executeOrder(order)
+}
+
+// executeOrder matches the order, or collects it in the order book without matching in call auction
+func (o *orderBook) executeOrder(order *models.Order) []Log {
+	// a pegged order is priced at the current top of book, it is cancelled if there is no reference price
+	if len(order.Peg) != 0 {
+		price, ok := o.pegPrice(newBookOrder(order))
+		if !ok {
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(order), order.Size,
+				models.DoneReasonPegUnavailable)
+			return []Log{doneLog}
+		}
+		peggedOrder := *order
+		peggedOrder.Price = price
+		order = &peggedOrder
+	}
+
+	if o.status == models.ProductStatusAuction {
+		return []Log{o.restOrder(newBookOrder(order))}
 	}
+	return o.matchOrder(order)
+}
 
+// matchOrder matches the taker order with the orders on the opposite depth, the uncompleted size of a limit
+// order is put into the order book.
+func (o *orderBook) matchOrder(order *models.Order) (logs []Log) {
 	takerOrder := newBookOrder(order)
 
+	// the price band is around the price of the last trade before the order, so that a single order cannot
+	// sweep the book far away from it
+	reference := o.lastPrice
+
 	// If it's a Market-Buy order, set price to infinite high, and if it's market-sell,
 	// set price to zero, which ensures that prices will cross.
+	// A market order with a protection price uses it as the price instead, and stops matching there.
 	if takerOrder.Type == models.OrderTypeMarket {
-		if takerOrder.Side == models.SideBuy {
+		if order.ProtectionPrice.GreaterThan(decimal.Zero) {
+			takerOrder.Price = order.ProtectionPrice
+		} else if takerOrder.Side == models.SideBuy {
 			takerOrder.Price = decimal.NewFromFloat(math.MaxFloat32)
 		} else {
 			takerOrder.Price = decimal.Zero
 		}
+
+		// a market buy order by size is matched by its size, its funds is only the hold
+		if takerOrder.Side == models.SideBuy && takerOrder.Size.GreaterThan(decimal.Zero) {
+			takerOrder.Funds = decimal.Zero
+		}
 	}
 
-	makerDepth := o.depths[takerOrder.Side.Opposite()]
-	for itr := makerDepth.queue.Iterator(); itr.Next(); {
-		makerOrder := makerDepth.orders[itr.Value().(int64)]
+	// a FOK order is rejected as a whole if it cannot be filled completely, and nothing is matched
+	if takerOrder.TimeInForce == models.TimeInForceFOK &&
+		!o.isFillable(takerOrder, takerOrder.Size, reference, order.SelfTradePrevention) {
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, takerOrder, takerOrder.Size, models.DoneReasonFOKRejected)
+		return append(logs, doneLog)
+	}
+
+	// an order with a minimum quantity is cancelled as a whole if less than MinQty can be filled at once, the
+	// condition only applies when it takes liquidity. A limit order which doesn't cross the best opposite price
+	// is put into the order book, and so is the remaining size after a fill of at least MinQty.
+	if takerOrder.MinQty.GreaterThan(decimal.Zero) && o.takesLiquidity(takerOrder) &&
+		!o.isFillable(takerOrder, takerOrder.MinQty, reference, order.SelfTradePrevention) {
+		remainingSize := takerOrder.Size
+		if takerOrder.Type == models.OrderTypeMarket {
+			takerOrder.Price = decimal.Zero
+			remainingSize = decimal.Zero
+		}
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, takerOrder, remainingSize,
+			models.DoneReasonMinQtyRejected)
+		return append(logs, doneLog)
+	}
+
+	// a post only order must never take liquidity, if it would cross the best opposite price, it is either
+	// rejected or slid one tick behind the best opposite price. All orders are post only in post only status.
+	if order.PostOnly || o.status == models.ProductStatusPostOnly {
+		bestOrder := o.depths[takerOrder.Side.Opposite()].bestOrder()
+		if bestOrder != nil && isCrossed(takerOrder, bestOrder) {
+			if order.PostOnlySlide {
+				if takerOrder.Side == models.SideBuy {
+					takerOrder.Price = bestOrder.Price.Sub(o.priceTick())
+				} else {
+					takerOrder.Price = bestOrder.Price.Add(o.priceTick())
+				}
+			}
+
+			if !order.PostOnlySlide || takerOrder.Price.LessThanOrEqual(decimal.Zero) {
+				doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, takerOrder, takerOrder.Size,
+					models.DoneReasonPostOnlyRejected)
+				return append(logs, doneLog)
+			}
+		}
+	}
 
+	// the reason why the taker is cancelled while matching
+	var cancelReason models.DoneReason
+	// the price of the trade which would be made outside the price band
+	var tripPrice decimal.Decimal
+	var tripped bool
+
+	// the maker at the head of the queue is either removed or moved back (iceberg refill) in each round
+	makerDepth := o.depths[takerOrder.Side.Opposite()]
+	for makerOrder := makerDepth.bestOrder(); makerOrder != nil; makerOrder = makerDepth.bestOrder() {
 		// check whether there is price crossing between the taker and the maker
-		if (takerOrder.Side == models.SideBuy && takerOrder.Price.LessThan(makerOrder.Price)) ||
-			(takerOrder.Side == models.SideSell && takerOrder.Price.GreaterThan(makerOrder.Price)) {
+		if !isCrossed(takerOrder, makerOrder) {
+			break
+		}
+
+		// a trade outside the price band trips the circuit breaker instead of being made, unless the taker
+		// is already completed
+		if !o.isInBand(reference, makerOrder.Price) {
+			tripped = takerOrder.Size.GreaterThan(decimal.Zero) || (takerOrder.isMarketBuyByFunds() &&
+				takerOrder.Funds.Div(makerOrder.Price).Truncate(o.product.BaseScale).GreaterThan(decimal.Zero))
+			tripPrice = makerOrder.Price
 			break
 		}
 
+		// the taker and the maker belong to the same user, prevent the trade instead of matching
+		if takerOrder.UserId == makerOrder.UserId && len(order.SelfTradePrevention) != 0 {
+			stpLogs, takerCancelled := o.preventSelfTrade(order.SelfTradePrevention, takerOrder, makerOrder)
+			logs = append(logs, stpLogs...)
+			if takerCancelled {
+				cancelReason = models.DoneReasonSelfTradePrevented
+				break
+			}
+			continue
+		}
+
 		// trade price
 		var price = makerOrder.Price
 		// trade size
 		var size decimal.Decimal
 
-		if takerOrder.Type == models.OrderTypeLimit ||
-			(takerOrder.Type == models.OrderTypeMarket && takerOrder.Side == models.SideSell) {
+		if !takerOrder.isMarketBuyByFunds() {
 			if takerOrder.Size.IsZero() {
 				break
 			}
 
 			// Take the minimum size of taker and maker as trade size
-			size = decimal.Min(takerOrder.Size, makerOrder.Size)
+			size = decimal.Min(takerOrder.Size, makerOrder.visibleSize())
 
 			// adjust the size of taker order
 			takerOrder.Size = takerOrder.Size.Sub(size)
 
-		} else if takerOrder.Type == models.OrderTypeMarket && takerOrder.Side == models.SideBuy {
+		} else {
 			if takerOrder.Funds.IsZero() {
 				break
 			}
@@ -145,61 +398,105 @@ func (o *orderBook) ApplyOrder(order *models.Order) (logs []Log) {
 			}
 
 			// Take the minimum size of taker and maker as trade size
-			size = decimal.Min(takerSize, makerOrder.Size)
+			size = decimal.Min(takerSize, makerOrder.visibleSize())
 			funds := size.Mul(price)
 
 			// adjust the funds of taker order
 			takerOrder.Funds = takerOrder.Funds.Sub(funds)
-		} else {
-			log.Fatal("unknown orderType and side combination")
 		}
 
 		// adjust the size of maker order
-		err := makerDepth.decrSize(makerOrder.OrderId, size)
-		if err != nil {
-			log.Fatal(err)
-		}
+		o.fillBookOrder(makerOrder, size)
 
 		// matched,write a log
-		matchLog := newMatchLog(o.nextLogSeq(), o.product.Id, o.nextTradeSeq(), takerOrder, makerOrder, price, size)
+		matchLog := newMatchLog(o.nextLogSeq(), o.product.Id, o.time, o.nextTradeSeq(), takerOrder, makerOrder, price, size)
 		logs = append(logs, matchLog)
+		o.lastPrice = price
 
 		// maker is filled
-		if makerOrder.Size.IsZero() {
-			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, makerOrder, makerOrder.Size, models.DoneReasonFilled)
-			logs = append(logs, doneLog)
-		}
+		logs = append(logs, o.afterFill(makerOrder)...)
 	}
 
-	if takerOrder.Type == models.OrderTypeLimit && takerOrder.Size.GreaterThan(decimal.Zero) {
-		// If taker has an uncompleted size, put taker in orderBook
-		o.depths[takerOrder.Side].add(*takerOrder)
+	// the uncompleted size of a limit order is put into the order book as usual, and it is matched when the
+	// continuous trading is reopened, otherwise it is cancelled
+	if tripped && (takerOrder.Type != models.OrderTypeLimit || takerOrder.TimeInForce == models.TimeInForceIOC ||
+		takerOrder.TimeInForce == models.TimeInForceFOK) {
+		cancelReason = models.DoneReasonCircuitBreaker
+	}
+
+	if len(cancelReason) != 0 {
+		var remainingSize = takerOrder.Size
+		if takerOrder.Type == models.OrderTypeMarket {
+			takerOrder.Price = decimal.Zero
+			remainingSize = decimal.Zero
+		}
 
-		openLog := newOpenLog(o.nextLogSeq(), o.product.Id, takerOrder)
-		logs = append(logs, openLog)
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, takerOrder, remainingSize, cancelReason)
+		logs = append(logs, doneLog)
+
+	} else if takerOrder.Type == models.OrderTypeLimit && takerOrder.Size.GreaterThan(decimal.Zero) &&
+		takerOrder.TimeInForce != models.TimeInForceIOC && takerOrder.TimeInForce != models.TimeInForceFOK {
+		// If taker has an uncompleted size, put taker in orderBook
+		logs = append(logs, o.restOrder(takerOrder))
 
 	} else {
 		var remainingSize = takerOrder.Size
 		var reason = models.DoneReasonFilled
 
+		if takerOrder.Type == models.OrderTypeLimit && takerOrder.Size.GreaterThan(decimal.Zero) {
+			// the uncompleted size of an IOC order is cancelled immediately, a FOK order which can't be filled
+			// completely after all is rejected
+			reason = models.DoneReasonIOCCancelled
+			if takerOrder.TimeInForce == models.TimeInForceFOK {
+				reason = models.DoneReasonFOKRejected
+			}
+		}
+
 		if takerOrder.Type == models.OrderTypeMarket {
-			takerOrder.Price = decimal.Zero
-			remainingSize = decimal.Zero
-			if (takerOrder.Side == models.SideSell && takerOrder.Size.GreaterThan(decimal.Zero)) ||
-				(takerOrder.Side == models.SideBuy && takerOrder.Funds.GreaterThan(decimal.Zero)) {
+			if (!takerOrder.isMarketBuyByFunds() && takerOrder.Size.GreaterThan(decimal.Zero)) ||
+				(takerOrder.isMarketBuyByFunds() && takerOrder.Funds.GreaterThan(decimal.Zero)) {
+				// the unfilled part is cancelled, either because the book is exhausted or because the
+				// protection price is reached
 				reason = models.DoneReasonCancelled
+				bestOrder := o.depths[takerOrder.Side.Opposite()].bestOrder()
+				if order.ProtectionPrice.GreaterThan(decimal.Zero) && bestOrder != nil &&
+					!isCrossed(takerOrder, bestOrder) {
+					reason = models.DoneReasonPriceProtected
+				}
 			}
+			takerOrder.Price = decimal.Zero
+			remainingSize = decimal.Zero
 		}
 
-		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, takerOrder, remainingSize, reason)
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, takerOrder, remainingSize, reason)
 		logs = append(logs, doneLog)
 	}
+
+	if tripped {
+		logs = append(logs, o.tripBreaker(reference, tripPrice)...)
+	}
 	return logs
 }
 
 func (o *orderBook) CancelOrder(order *models.Order) (logs []Log) {
+	defer func() { logs = append(logs, o.updateIndicative()...) }()
+	defer func() { logs = append(logs, o.repricePegs()...) }()
+	defer func() { logs = o.followUpLogs(logs) }()
+
+	// the cancel advances the clock with the time it is sent, the order being cancelled may be created long ago
+	logs = o.expireOrders(order.UpdatedAt)
+	logs = append(logs, o.endBreakerAuction()...)
+
+	// the orders are cancelled in any trading status, a halted product only refuses new orders
 	_ = o.orderIdWindow.put(order.Id)
 
+	stopOrder := o.triggerBook.remove(order.Id)
+	if stopOrder != nil {
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(stopOrder), stopOrder.Size,
+			models.DoneReasonCancelled)
+		return append(logs, doneLog)
+	}
+
 	bookOrder, found := o.depths[order.Side].orders[order.Id]
 	if !found {
 		return logs
@@ -212,123 +509,1264 @@ func (o *orderBook) CancelOrder(order *models.Order) (logs []Log) {
 		panic(err)
 	}
 
-	doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, bookOrder, remainingSize, models.DoneReasonCancelled)
+	doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, bookOrder, remainingSize, models.DoneReasonCancelled)
 	return append(logs, doneLog)
 }
 
-func (o *orderBook) Snapshot() orderBookSnapshot {
-	snapshot := orderBookSnapshot{
-		Orders:        make([]BookOrder, len(o.depths[models.SideSell].orders)+len(o.depths[models.SideBuy].orders)),
-		LogSeq:        o.logSeq,
-		TradeSeq:      o.tradeSeq,
-		OrderIdWindow: o.orderIdWindow,
+func (o *orderBook) ApplyCommand(command *Command) (logs []Log) {
+	defer func() { logs = append(logs, o.updateIndicative()...) }()
+	defer func() { logs = append(logs, o.repricePegs()...) }()
+	defer func() { logs = o.followUpLogs(logs) }()
+
+	logs = o.expireOrders(command.Time)
+	logs = append(logs, o.endBreakerAuction()...)
+
+	switch command.CommandType {
+	case CommandTypeAmend:
+		logs = append(logs, o.amendOrder(command)...)
+	case CommandTypeProductStatus:
+		logs = append(logs, o.updateStatus(command.Status)...)
+	case CommandTypePlaceGroup:
+		logs = append(logs, o.placeGroup(command.Orders)...)
+	case CommandTypeMassCancel:
+		logs = append(logs, o.massCancel(command)...)
+	case CommandTypeClock:
+		// the clock has been advanced above
+	default:
+		log.Errorf("unknown command type: %v", command.CommandType)
 	}
+	return logs
+}
 
-	i := 0
-	for _, order := range o.depths[models.SideSell].orders {
-		snapshot.Orders[i] = *order
-		i++
+// massCancel cancels all the orders of the user on the book in one step, optionally only the orders on one side
+// or with a price in the price range. Untriggered stop orders are not on the book, they are cancelled only if no
+// price range is given.
+func (o *orderBook) massCancel(command *Command) (logs []Log) {
+	inRange := func(price decimal.Decimal) bool {
+		if command.LowPrice.GreaterThan(decimal.Zero) && price.LessThan(command.LowPrice) {
+			return false
+		}
+		if command.HighPrice.GreaterThan(decimal.Zero) && price.GreaterThan(command.HighPrice) {
+			return false
+		}
+		return true
 	}
-	for _, order := range o.depths[models.SideBuy].orders {
-		snapshot.Orders[i] = *order
-		i++
+
+	for _, side := range []models.Side{models.SideBuy, models.SideSell} {
+		if len(command.Side) != 0 && command.Side != side {
+			continue
+		}
+
+		// collect the orders in queue order first, the queue can not be changed while iterating
+		depth := o.depths[side]
+		var orders []*BookOrder
+		for itr := depth.queue.Iterator(); itr.Next(); {
+			order := depth.orders[itr.Value().(int64)]
+			if order.UserId == command.UserId && inRange(order.Price) {
+				orders = append(orders, order)
+			}
+		}
+
+		for _, order := range orders {
+			remainingSize := order.Size
+			err := depth.decrSize(order.OrderId, order.Size)
+			if err != nil {
+				panic(err)
+			}
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, order, remainingSize, models.DoneReasonCancelled)
+			logs = append(logs, doneLog)
+		}
 	}
 
-	return snapshot
+	if command.LowPrice.GreaterThan(decimal.Zero) || command.HighPrice.GreaterThan(decimal.Zero) {
+		return logs
+	}
+	for _, stopOrder := range o.triggerBook.ordersOf(command.UserId) {
+		if len(command.Side) != 0 && command.Side != stopOrder.Side {
+			continue
+		}
+		o.triggerBook.remove(stopOrder.Id)
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(stopOrder), stopOrder.Size,
+			models.DoneReasonCancelled)
+		logs = append(logs, doneLog)
+	}
+	return logs
 }
 
-func (o *orderBook) Restore(snapshot *orderBookSnapshot) {
-	o.logSeq = snapshot.LogSeq
-	o.tradeSeq = snapshot.TradeSeq
-	o.orderIdWindow = snapshot.OrderIdWindow
-	if o.orderIdWindow.Cap == 0 {
-		o.orderIdWindow = newWindow(0, orderIdWindowCap)
+// amendOrder changes the price or the size of an order on the book. A size decrease keeps the position of the
+// order in the queue, while a price change puts the order at the end of the queue of the new price. Only
+// decreasing the size is allowed, and the new price must not cross the opposite depth except in call auction,
+// the amend is ignored otherwise. The size of the command is the new remaining size, so that applying the same
+// amend again changes nothing.
+func (o *orderBook) amendOrder(command *Command) (logs []Log) {
+	bookOrder, found := o.depths[command.Side].orders[command.OrderId]
+	if !found || bookOrder.UserId != command.UserId {
+		return logs
 	}
 
-	for _, order := range snapshot.Orders {
-		o.depths[order.Side].add(order)
+	newSize := bookOrder.Size
+	if command.Size.GreaterThan(decimal.Zero) && command.Size.LessThan(bookOrder.Size) {
+		newSize = command.Size
+	}
+	// the price of a pegged order is only moved by the engine
+	newPrice := bookOrder.Price
+	if command.Price.GreaterThan(decimal.Zero) && len(bookOrder.Peg) == 0 {
+		newPrice = command.Price
+	}
+	if newSize.Equal(bookOrder.Size) && newPrice.Equal(bookOrder.Price) {
+		return logs
+	}
+
+	oldSize, oldVisibleSize, oldPrice := bookOrder.Size, bookOrder.visibleSize(), bookOrder.Price
+	depth := o.depths[bookOrder.Side]
+
+	if newPrice.Equal(oldPrice) {
+		// the visible slice of an iceberg order is capped to the new size, it is not refilled in place
+		err := depth.decrSize(bookOrder.OrderId, oldSize.Sub(newSize))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	} else {
+		amendedOrder := *bookOrder
+		amendedOrder.Price = newPrice
+		bestOrder := o.depths[bookOrder.Side.Opposite()].bestOrder()
+		// orders are allowed to cross in call auction, they are matched at the uncross
+		if o.status != models.ProductStatusAuction && bestOrder != nil && isCrossed(&amendedOrder, bestOrder) {
+			log.Warnf("amend of order %v ignored, price %v crosses the book", bookOrder.OrderId, newPrice)
+			return logs
+		}
+
+		// 将order从原价格的队列中删除，然后以新的时间优先级加入新价格的队列
+		err := depth.decrSize(bookOrder.OrderId, oldSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		amendedOrder.Size = newSize
+		// an iceberg order shows a new slice at the new price
+		if amendedOrder.isIceberg() {
+			amendedOrder.VisibleSize = decimal.Min(amendedOrder.DisplaySize, newSize)
+		}
+		amendedOrder.QueueSeq = o.nextQueueSeq()
+		depth.add(amendedOrder)
+		bookOrder = depth.orders[amendedOrder.OrderId]
 	}
+
+	changeLog := newChangeLog(o.nextLogSeq(), o.product.Id, o.time, bookOrder, oldSize, oldVisibleSize, oldPrice)
+	return append(logs, changeLog)
 }
 
-func (o *orderBook) nextLogSeq() int64 {
-	o.logSeq++
-	return o.logSeq
+// updateStatus changes the trading status of the product, every transition is written as a StatusLog.
+func (o *orderBook) updateStatus(status models.ProductStatus) (logs []Log) {
+	if _, err := models.NewProductStatusFromString(status.String()); err != nil {
+		log.Error(err)
+		return logs
+	}
+	if status == o.status {
+		return logs
+	}
+
+	// the orders collected in call auction are uncrossed when the continuous trading is opened, the book may
+	// also be left crossed by an auction which was halted before the opening
+	if status == models.ProductStatusOpen {
+		logs = o.uncross()
+	}
+	if o.status == models.ProductStatusAuction {
+		o.indicativePrice = decimal.Zero
+		o.indicativeVolume = decimal.Zero
+	}
+
+	statusLog := newStatusLog(o.nextLogSeq(), o.product.Id, o.time, o.status, status)
+	logs = append(logs, statusLog)
+	o.status = status
+	o.breakerUntil = time.Time{}
+	return logs
 }
 
-func (o *orderBook) nextTradeSeq() int64 {
-	o.tradeSeq++
-	return o.tradeSeq
+// isInBand checks whether a trade at the price is inside the price band around the reference price, all
+// prices are inside the band if the price band is not configured or there is no reference price yet.
+func (o *orderBook) isInBand(reference, price decimal.Decimal) bool {
+	if o.product.PriceBand.LessThanOrEqual(decimal.Zero) || reference.IsZero() {
+		return true
+	}
+	low, high := o.priceBand(reference)
+	return price.GreaterThanOrEqual(low) && price.LessThanOrEqual(high)
 }
 
-type depth struct {
-	// all orders
-	orders map[int64]*BookOrder
+// priceBand returns the lowest and the highest trade price allowed around the reference price
+func (o *orderBook) priceBand(reference decimal.Decimal) (low, high decimal.Decimal) {
+	band := reference.Mul(o.product.PriceBand)
+	return reference.Sub(band), reference.Add(band)
+}
 
-	// price first, time first order queue for order match
-	// priceOrderIdKey -> orderId
-	queue *treemap.Map
+// tripBreaker stops the continuous trading when a trade would be made outside the price band. The product is
+// moved into a short call auction, or halted until it is opened again if no auction duration is configured.
+func (o *orderBook) tripBreaker(reference, price decimal.Decimal) (logs []Log) {
+	status := models.ProductStatusHalted
+	var until time.Time
+	if o.product.BandAuctionSeconds > 0 {
+		status = models.ProductStatusAuction
+		until = o.time.Add(time.Duration(o.product.BandAuctionSeconds) * time.Second)
+	}
+
+	low, high := o.priceBand(reference)
+	breakerLog := newBreakerLog(o.nextLogSeq(), o.product.Id, o.time, reference, low, high, price, status, until)
+	logs = append(logs, breakerLog)
+	logs = append(logs, o.updateStatus(status)...)
+	o.breakerUntil = until
+	return logs
 }
 
-func (d *depth) add(order BookOrder) {
-	d.orders[order.OrderId] = &order
-	d.queue.Put(&priceOrderIdKey{order.Price, order.OrderId}, order.OrderId)
+// endBreakerAuction reopens the continuous trading when the clock reaches the end of the call auction started
+// by the circuit breaker
+func (o *orderBook) endBreakerAuction() []Log {
+	if o.breakerUntil.IsZero() || o.time.Before(o.breakerUntil) {
+		return nil
+	}
+	return o.updateStatus(models.ProductStatusOpen)
 }
 
-func (d *depth) decrSize(orderId int64, size decimal.Decimal) error {
-	order, found := d.orders[orderId]
-	if !found {
-		return errors.New(fmt.Sprintf("order %v not found on book", orderId))
+// isAcceptable checks whether a new order is accepted in the current trading status
+func (o *orderBook) isAcceptable(order *models.Order) bool {
+	switch o.status {
+	case models.ProductStatusOpen:
+		return true
+	case models.ProductStatusPostOnly:
+		return order.Type == models.OrderTypeLimit && order.TimeInForce != models.TimeInForceIOC &&
+			order.TimeInForce != models.TimeInForceFOK
+	case models.ProductStatusAuction:
+		// stop orders wait in the trigger book, they are triggered after the uncross
+		if len(order.Stop) != 0 {
+			return true
+		}
+		// there is no top of book to peg to in call auction
+		if len(order.Peg) != 0 {
+			return false
+		}
+		return order.Type == models.OrderTypeLimit && order.TimeInForce != models.TimeInForceIOC &&
+			order.TimeInForce != models.TimeInForceFOK
+	default:
+		// halted and cancel only
+		return false
 	}
+}
 
-	if order.Size.LessThan(size) {
-		return errors.New(fmt.Sprintf("order %v Size %v less than %v", orderId, order.Size, size))
+// equilibrium returns the uncross price of the call auction, which maximizes the executed volume. Ties are broken
+// on the smallest imbalance, then on the distance to the last trade price, and then on the lower price. The
+// volume is zero if the book is not crossed.
+func (o *orderBook) equilibrium() (price, volume, imbalance decimal.Decimal) {
+	bidPrices, bidSizes := o.depths[models.SideBuy].levels()
+	askPrices, askSizes := o.depths[models.SideSell].levels()
+	if len(bidPrices) == 0 || len(askPrices) == 0 || bidPrices[0].LessThan(askPrices[0]) {
+		return price, volume, imbalance
 	}
 
-	order.Size = order.Size.Sub(size)
-	if order.Size.IsZero() {
-		delete(d.orders, orderId)
-		d.queue.Remove(&priceOrderIdKey{order.Price, order.OrderId})
+	// only the prices between the lowest ask and the highest bid are able to execute, they are walked from
+	// the highest down, so the bid volume grows while the ask volume shrinks
+	var candidates []decimal.Decimal
+	for _, p := range bidPrices {
+		if p.GreaterThanOrEqual(askPrices[0]) {
+			candidates = append(candidates, p)
+		}
+	}
+	for _, p := range askPrices {
+		if p.LessThanOrEqual(bidPrices[0]) {
+			candidates = append(candidates, p)
+		}
 	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].GreaterThan(candidates[j])
+	})
 
-	return nil
-}
+	askVolume := decimal.Zero
+	askIdx := 0
+	for ; askIdx < len(askPrices) && askPrices[askIdx].LessThanOrEqual(bidPrices[0]); askIdx++ {
+		askVolume = askVolume.Add(askSizes[askIdx])
+	}
+	bidVolume := decimal.Zero
+	bidIdx := 0
 
-type BookOrder struct {
-	OrderId int64
-	Size    decimal.Decimal
-	Funds   decimal.Decimal
-	Price   decimal.Decimal
-	Side    models.Side
-	Type    models.OrderType
+	for i, p := range candidates {
+		if i > 0 && p.Equal(candidates[i-1]) {
+			continue
+		}
+		for ; bidIdx < len(bidPrices) && bidPrices[bidIdx].GreaterThanOrEqual(p); bidIdx++ {
+			bidVolume = bidVolume.Add(bidSizes[bidIdx])
+		}
+		for ; askIdx > 0 && askPrices[askIdx-1].GreaterThan(p); askIdx-- {
+			askVolume = askVolume.Sub(askSizes[askIdx-1])
+		}
+
+		v := decimal.Min(bidVolume, askVolume)
+		imb := bidVolume.Sub(askVolume)
+		if volume.IsZero() || v.GreaterThan(volume) {
+			price, volume, imbalance = p, v, imb
+			continue
+		}
+		if v.LessThan(volume) {
+			continue
+		}
+		if imb.Abs().LessThan(imbalance.Abs()) {
+			price, volume, imbalance = p, v, imb
+			continue
+		}
+		if imb.Abs().GreaterThan(imbalance.Abs()) || o.lastPrice.IsZero() {
+			continue
+		}
+		// candidates are descending, so the lower price wins when the distance is the same
+		if !p.Sub(o.lastPrice).Abs().GreaterThan(price.Sub(o.lastPrice).Abs()) {
+			price, volume, imbalance = p, v, imb
+		}
+	}
+	return price, volume, imbalance
 }
 
-func newBookOrder(order *models.Order) *BookOrder {
-	return &BookOrder{
-		OrderId: order.Id,
-		Size:    order.Size,
-		Funds:   order.Funds,
-		Price:   order.Price,
-		Side:    order.Side,
-		Type:    order.Type,
+// updateIndicative returns an AuctionLog when the indicative price or volume of the call auction is changed
+func (o *orderBook) updateIndicative() []Log {
+	if o.status != models.ProductStatusAuction {
+		return nil
+	}
+
+	price, volume, imbalance := o.equilibrium()
+	if price.Equal(o.indicativePrice) && volume.Equal(o.indicativeVolume) {
+		return nil
 	}
+	o.indicativePrice = price
+	o.indicativeVolume = volume
+
+	return []Log{newAuctionLog(o.nextLogSeq(), o.product.Id, o.time, price, volume, imbalance)}
 }
 
-func priceOrderIdKeyAscComparator(a, b interface{}) int {
-	aAsserted := a.(*priceOrderIdKey)
-	bAsserted := b.(*priceOrderIdKey)
+// uncross matches the crossed orders collected in call auction, all trades are made at the equilibrium price.
+// The order which comes later into the queue is the taker of a trade. Self trade prevention is not applied.
+func (o *orderBook) uncross() (logs []Log) {
+	price, volume, _ := o.equilibrium()
 
-	x := aAsserted.price.Cmp(bAsserted.price)
-	if x != 0 {
-		return x
+	for volume.GreaterThan(decimal.Zero) {
+		bidOrder := o.depths[models.SideBuy].bestOrder()
+		askOrder := o.depths[models.SideSell].bestOrder()
+		if bidOrder == nil || askOrder == nil || bidOrder.Price.LessThan(price) || askOrder.Price.GreaterThan(price) {
+			break
+		}
+
+		takerOrder, makerOrder := bidOrder, askOrder
+		if askOrder.QueueSeq > bidOrder.QueueSeq ||
+			(askOrder.QueueSeq == bidOrder.QueueSeq && askOrder.OrderId > bidOrder.OrderId) {
+			takerOrder, makerOrder = askOrder, bidOrder
+		}
+
+		size := decimal.Min(decimal.Min(bidOrder.visibleSize(), askOrder.visibleSize()), volume)
+		o.fillBookOrder(takerOrder, size)
+		o.fillBookOrder(makerOrder, size)
+		volume = volume.Sub(size)
+
+		matchLog := newMatchLog(o.nextLogSeq(), o.product.Id, o.time, o.nextTradeSeq(), takerOrder, makerOrder, price, size)
+		logs = append(logs, matchLog)
+		o.lastPrice = price
+
+		logs = append(logs, o.afterFill(makerOrder)...)
+		logs = append(logs, o.afterFill(takerOrder)...)
 	}
+	return logs
+}
 
-	y := aAsserted.orderId - bAsserted.orderId
-	if y == 0 {
-		return 0
-	} else if y > 0 {
-		return 1
-	} else {
-		return -1
+// restOrder puts the uncompleted limit order into the order book
+func (o *orderBook) restOrder(order *BookOrder) *OpenLog {
+	if order.isIceberg() {
+		order.VisibleSize = decimal.Min(order.DisplaySize, order.Size)
+	}
+	order.QueueSeq = o.nextQueueSeq()
+	o.depths[order.Side].add(*order)
+	if order.TimeInForce == models.TimeInForceGTT {
+		o.expiryQueue.Put(&expireTimeOrderIdKey{order.ExpireTime, order.OrderId}, order.Side)
+	}
+	if len(order.Peg) != 0 {
+		o.pegOrders[order.OrderId] = order.Side
+	}
+
+	return newOpenLog(o.nextLogSeq(), o.product.Id, o.time, order)
+}
+
+// fillBookOrder decreases the size of an order on the book by a trade, an iceberg order is filled from its
+// visible slice.
+func (o *orderBook) fillBookOrder(order *BookOrder, size decimal.Decimal) {
+	o.depths[order.Side].beforeUpdate(order)
+	if order.isIceberg() {
+		order.VisibleSize = order.VisibleSize.Sub(size)
+	}
+	err := o.depths[order.Side].decrSize(order.OrderId, size)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// afterFill returns the DoneLog of an order on the book which is filled, or the OpenLog of the refilled slice
+// of an iceberg order whose visible slice is used up.
+func (o *orderBook) afterFill(order *BookOrder) []Log {
+	if order.Size.IsZero() {
+		return []Log{newDoneLog(o.nextLogSeq(), o.product.Id, o.time, order, order.Size, models.DoneReasonFilled)}
+	} else if order.isIceberg() && order.VisibleSize.IsZero() {
+		return []Log{o.refillIceberg(order)}
+	}
+	return nil
+}
+
+// refillIceberg shows the next slice of the reserve of an iceberg order whose visible slice is used up, the
+// refilled slice takes a new time priority. The returned OpenLog only exposes the visible slice.
+func (o *orderBook) refillIceberg(order *BookOrder) *OpenLog {
+	o.depths[order.Side].beforeUpdate(order)
+	order.VisibleSize = decimal.Min(order.DisplaySize, order.Size)
+	o.depths[order.Side].requeue(order, o.nextQueueSeq())
+	return newOpenLog(o.nextLogSeq(), o.product.Id, o.time, order)
+}
+
+// preventSelfTrade handles a taker which would trade with a maker of the same user according to the self trade
+// prevention mode of the taker. Cancelled makers are removed from the order book, a cancelled taker is left to
+// the caller.
+func (o *orderBook) preventSelfTrade(stp models.SelfTradePrevention, takerOrder, makerOrder *BookOrder) (
+	logs []Log, takerCancelled bool) {
+	cancelMaker := func() {
+		remainingSize := makerOrder.Size
+		err := o.depths[makerOrder.Side].decrSize(makerOrder.OrderId, makerOrder.Size)
+		if err != nil {
+			log.Fatal(err)
+		}
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, makerOrder, remainingSize,
+			models.DoneReasonSelfTradePrevented)
+		logs = append(logs, doneLog)
+	}
+
+	switch stp {
+	case models.SelfTradePreventionCancelOldest:
+		cancelMaker()
+
+	case models.SelfTradePreventionCancelNewest:
+		takerCancelled = true
+
+	case models.SelfTradePreventionCancelBoth:
+		cancelMaker()
+		takerCancelled = true
+
+	case models.SelfTradePreventionDecrementAndCancel:
+		// the size of a market buy order is calculated by its funds at the maker price
+		takerSize := takerOrder.Size
+		if takerOrder.isMarketBuyByFunds() {
+			takerSize = takerOrder.Funds.Div(makerOrder.Price).Truncate(o.product.BaseScale)
+		}
+
+		if takerSize.IsZero() {
+			// the funds of a market buy order can't buy the smallest size at the maker price, there is nothing
+			// to decrement the maker by
+			takerCancelled = true
+
+		} else if takerSize.LessThan(makerOrder.Size) {
+			// the smaller taker is cancelled, and the maker is decremented by the size of the taker
+			oldSize, oldVisibleSize := makerOrder.Size, makerOrder.visibleSize()
+			err := o.depths[makerOrder.Side].decrSize(makerOrder.OrderId, takerSize)
+			if err != nil {
+				log.Fatal(err)
+			}
+			changeLog := newChangeLog(o.nextLogSeq(), o.product.Id, o.time, makerOrder, oldSize, oldVisibleSize,
+				makerOrder.Price)
+			logs = append(logs, changeLog)
+			takerCancelled = true
+
+		} else {
+			// the smaller maker is cancelled, and the taker is decremented by the size of the maker, if they
+			// have the same size, both of them are cancelled
+			takerCancelled = takerSize.Equal(makerOrder.Size)
+			if !takerCancelled {
+				if takerOrder.isMarketBuyByFunds() {
+					takerOrder.Funds = takerOrder.Funds.Sub(makerOrder.Size.Mul(makerOrder.Price))
+				} else {
+					takerOrder.Size = takerOrder.Size.Sub(makerOrder.Size)
+				}
+			}
+			cancelMaker()
+		}
+
+	default:
+		log.Fatalf("unknown self trade prevention: %v", stp)
+	}
+	return logs, takerCancelled
+}
+
+// followUpLogs follows up the logs of an operation on the order book in the same sequence step. The siblings
+// of the grouped orders which are filled, triggered or done are cancelled first, then the stop orders
+// triggered by the trades are activated one by one, and their logs are followed up in turn.
+func (o *orderBook) followUpLogs(logs []Log) []Log {
+	var groupScanned, tradeScanned int
+	var stopOrders []*models.Order
+
+	for {
+		if groupScanned < len(logs) {
+			newLogs := logs[groupScanned:]
+			groupScanned = len(logs)
+			logs = append(logs, o.followUpGroups(newLogs)...)
+			continue
+		}
+
+		if tradeScanned < len(logs) {
+			// trailing stop orders follow the trades one by one, so that a stop price is moved by a trade
+			// before it is checked against the later trades
+			var triggered []*models.Order
+			newLogs := logs[tradeScanned:]
+			tradeScanned = len(logs)
+			for _, l := range newLogs {
+				matchLog, ok := l.(*MatchLog)
+				if !ok {
+					continue
+				}
+				logs = append(logs, o.trailStopOrders(matchLog.Price)...)
+				triggered = append(triggered, o.triggerBook.trigger(matchLog.Price, matchLog.Price)...)
+			}
+			sort.SliceStable(triggered, func(i, j int) bool {
+				return triggered[i].Id < triggered[j].Id
+			})
+			stopOrders = append(stopOrders, triggered...)
+			continue
+		}
+
+		if len(stopOrders) == 0 {
+			return logs
+		}
+		stopOrder := stopOrders[0]
+		stopOrders = stopOrders[1:]
+
+		// a grouped stop order is cancelled if its sibling is filled or done after it is triggered
+		if stopOrder.GroupId != 0 && o.groups.groupOf(stopOrder.Id) == nil {
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(stopOrder), stopOrder.Size,
+				models.DoneReasonGroupCancelled)
+			logs = append(logs, doneLog)
+			continue
+		}
+		logs = append(logs, o.activateOrder(stopOrder)...)
+	}
+}
+
+// followUpGroups cancels the siblings of the grouped orders in the logs which are filled, triggered or done,
+// and releases or cancels the exits of a bracket whose entry is done.
+func (o *orderBook) followUpGroups(logs []Log) (followLogs []Log) {
+	for _, l := range logs {
+		switch l := l.(type) {
+		case *MatchLog:
+			followLogs = append(followLogs, o.cancelSiblings(l.TakerOrderId)...)
+			followLogs = append(followLogs, o.cancelSiblings(l.MakerOrderId)...)
+
+		case *ActivateLog:
+			if !l.Released {
+				followLogs = append(followLogs, o.cancelSiblings(l.OrderId)...)
+			}
+
+		case *DoneLog:
+			if l.Reason == models.DoneReasonGroupCancelled {
+				continue
+			}
+			group := o.groups.groupOf(l.OrderId)
+			if group != nil && len(group.PendingOrders) != 0 {
+				followLogs = append(followLogs, o.releaseExits(group, l.Reason == models.DoneReasonFilled)...)
+			} else {
+				followLogs = append(followLogs, o.cancelSiblings(l.OrderId)...)
+			}
+		}
+	}
+	return followLogs
+}
+
+// placeGroup puts a group of orders into the order book. The exits of a bracket wait until the entry is
+// completely filled, while the legs of an OCO are put into the order book at once.
+func (o *orderBook) placeGroup(orders []*models.Order) (logs []Log) {
+	if len(orders) == 0 {
+		return logs
+	}
+
+	// prevent the group from being submitted repeatedly to the matching engine, the whole group is rejected if
+	// any order of it is refused
+	reasons := make([]models.DoneReason, len(orders))
+	var rejected bool
+	for i, order := range orders {
+		reasons[i] = o.putOrderId(order.Id)
+		if len(reasons[i]) == 0 {
+			reasons[i] = o.validateOrder(order)
+		}
+		rejected = rejected || len(reasons[i]) != 0
+	}
+	if rejected {
+		for i, order := range orders {
+			reason := reasons[i]
+			if len(reason) == 0 {
+				reason = models.DoneReasonGroupRejected
+			}
+			log.Warnf("order %v of group %v rejected: %v", order.Id, order.GroupId, reason)
+			logs = append(logs, newRejectLog(o.nextLogSeq(), o.product.Id, o.time, order, reason))
+		}
+		return logs
+	}
+	for _, order := range orders {
+		logs = append(logs, newReceivedLog(o.nextLogSeq(), o.product.Id, o.time, order))
+	}
+
+	group := &orderGroup{GroupId: orders[0].GroupId, GroupType: orders[0].GroupType}
+	if group.GroupType == models.OrderGroupTypeBracket {
+		group.OrderIds = []int64{orders[0].Id}
+		group.PendingOrders = orders[1:]
+		o.groups.add(group)
+		return append(logs, o.acceptOrder(orders[0])...)
+	}
+
+	for _, order := range orders {
+		group.OrderIds = append(group.OrderIds, order.Id)
+	}
+	o.groups.add(group)
+	return append(logs, o.acceptLegs(group, orders)...)
+}
+
+// acceptLegs puts the legs of an OCO into the order book, the stop orders first because they never match at
+// once. If a leg is already done when it is put, e.g. it is rejected, the legs after it are cancelled without
+// being put into the order book.
+func (o *orderBook) acceptLegs(group *orderGroup, legs []*models.Order) (logs []Log) {
+	legs = append([]*models.Order{}, legs...)
+	sort.SliceStable(legs, func(i, j int) bool {
+		return len(legs[i].Stop) != 0 && len(legs[j].Stop) == 0
+	})
+
+	for i, leg := range legs {
+		logs = append(logs, o.acceptOrder(leg)...)
+
+		_, triggerable := o.triggerBook.orders[leg.Id]
+		_, open := o.depths[leg.Side].orders[leg.Id]
+		if triggerable || open {
+			continue
+		}
+
+		o.groups.remove(group.GroupId)
+		for _, other := range legs[i+1:] {
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(other), other.Size,
+				models.DoneReasonGroupCancelled)
+			logs = append(logs, doneLog)
+		}
+		break
+	}
+	return logs
+}
+
+// releaseExits releases the exits of a bracket as an OCO when the entry is completely filled, otherwise the
+// exits are cancelled.
+func (o *orderBook) releaseExits(group *orderGroup, filled bool) (logs []Log) {
+	exits := group.PendingOrders
+	o.groups.remove(group.GroupId)
+
+	if !filled {
+		for _, exit := range exits {
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(exit), exit.Size,
+				models.DoneReasonGroupCancelled)
+			logs = append(logs, doneLog)
+		}
+		return logs
+	}
+
+	exitGroup := &orderGroup{GroupId: group.GroupId, GroupType: group.GroupType}
+	for _, exit := range exits {
+		exitGroup.OrderIds = append(exitGroup.OrderIds, exit.Id)
+
+		activateLog := newActivateLog(o.nextLogSeq(), o.product.Id, o.time, exit)
+		activateLog.Released = true
+		logs = append(logs, activateLog)
+	}
+	o.groups.add(exitGroup)
+	return append(logs, o.acceptLegs(exitGroup, exits)...)
+}
+
+// cancelSiblings cancels the other orders linked with the order, and unlinks the group. The entry of a bracket
+// has no siblings until it is done.
+func (o *orderBook) cancelSiblings(orderId int64) (logs []Log) {
+	group := o.groups.groupOf(orderId)
+	if group == nil || len(group.PendingOrders) != 0 {
+		return logs
+	}
+	o.groups.remove(group.GroupId)
+
+	for _, siblingId := range group.OrderIds {
+		if siblingId == orderId {
+			continue
+		}
+
+		stopOrder := o.triggerBook.remove(siblingId)
+		if stopOrder != nil {
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(stopOrder), stopOrder.Size,
+				models.DoneReasonGroupCancelled)
+			logs = append(logs, doneLog)
+			continue
+		}
+
+		// a triggered stop order which is not activated yet is not found here, it is cancelled when it is
+		// activated
+		for _, depth := range o.depths {
+			bookOrder, found := depth.orders[siblingId]
+			if !found {
+				continue
+			}
+			remainingSize := bookOrder.Size
+			err := depth.decrSize(siblingId, bookOrder.Size)
+			if err != nil {
+				log.Fatal(err)
+			}
+			doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, bookOrder, remainingSize,
+				models.DoneReasonGroupCancelled)
+			logs = append(logs, doneLog)
+		}
+	}
+	return logs
+}
+
+// trailStopOrders moves the stop prices of the trailing stop orders with the trade price
+func (o *orderBook) trailStopOrders(price decimal.Decimal) (logs []Log) {
+	for _, order := range o.triggerBook.trailing() {
+		if trailLog := o.trailStopOrder(order, price); trailLog != nil {
+			logs = append(logs, trailLog)
+		}
+	}
+	return logs
+}
+
+// trailStopOrder moves the stop price of the trailing stop order to the trade price less or plus the offset.
+// The stop price of a loss order only rises and the stop price of an entry order only falls, so that the order
+// is triggered when the price reverses by the offset from its best price. It returns nil if the stop price does
+// not move.
+func (o *orderBook) trailStopOrder(order *models.Order, price decimal.Decimal) *TrailLog {
+	offset := order.TrailingAmount
+	if order.TrailingRate.GreaterThan(decimal.Zero) {
+		offset = price.Mul(order.TrailingRate).Round(o.product.QuoteScale)
+	}
+
+	var stopPrice decimal.Decimal
+	if order.Stop == models.StopLoss {
+		stopPrice = price.Sub(offset)
+		if stopPrice.LessThanOrEqual(order.StopPrice) {
+			return nil
+		}
+	} else {
+		stopPrice = price.Add(offset)
+		if stopPrice.GreaterThanOrEqual(order.StopPrice) {
+			return nil
+		}
+	}
+
+	o.triggerBook.move(order, stopPrice)
+	return newTrailLog(o.nextLogSeq(), o.product.Id, o.time, order)
+}
+
+// repricePegs moves the pegged orders on the book to their prices at the current top of book, a moved order
+// loses its queue priority and is written as a ChangeLog. Pegged orders keep their prices if the continuous
+// trading is not open, or if there is no reference price.
+func (o *orderBook) repricePegs() (logs []Log) {
+	if len(o.pegOrders) == 0 ||
+		(o.status != models.ProductStatusOpen && o.status != models.ProductStatusPostOnly) {
+		return logs
+	}
+
+	orderIds := make([]int64, 0, len(o.pegOrders))
+	for orderId := range o.pegOrders {
+		orderIds = append(orderIds, orderId)
+	}
+	sort.Slice(orderIds, func(i, j int) bool {
+		return orderIds[i] < orderIds[j]
+	})
+
+	for _, orderId := range orderIds {
+		side := o.pegOrders[orderId]
+		order, found := o.depths[side].orders[orderId]
+		if !found {
+			delete(o.pegOrders, orderId)
+			continue
+		}
+
+		price, ok := o.pegPrice(order)
+		if !ok || price.Equal(order.Price) {
+			continue
+		}
+		oldPrice := order.Price
+		o.depths[side].reprice(order, price, o.nextQueueSeq())
+		logs = append(logs, newChangeLog(o.nextLogSeq(), o.product.Id, o.time, order, order.Size,
+			order.visibleSize(), oldPrice))
+	}
+	return logs
+}
+
+// pegPrice returns the price of the pegged order at the current top of book, which is the reference price plus
+// the offset, rounded to the price tick away from the opposite side and limited by the cap. The reference prices
+// only come from the lit orders which are not pegged, so that pegged orders never follow each other. The price
+// is kept one tick behind the best opposite order, a pegged order never takes liquidity. It returns false if
+// there is no reference price.
+func (o *orderBook) pegPrice(order *BookOrder) (decimal.Decimal, bool) {
+	bestBid := o.depths[models.SideBuy].referencePrice()
+	bestAsk := o.depths[models.SideSell].referencePrice()
+
+	var price decimal.Decimal
+	switch order.Peg {
+	case models.PegTypeBestBid:
+		price = bestBid
+	case models.PegTypeBestAsk:
+		price = bestAsk
+	case models.PegTypeMid:
+		if bestBid.GreaterThan(decimal.Zero) && bestAsk.GreaterThan(decimal.Zero) {
+			price = bestBid.Add(bestAsk).Div(decimal.New(2, 0))
+		}
+	}
+	if price.IsZero() {
+		return price, false
+	}
+	price = price.Add(order.PegOffset)
+
+	tick := o.priceTick()
+	if order.Side == models.SideBuy {
+		price = price.Div(tick).Floor().Mul(tick)
+		if order.PegCap.GreaterThan(decimal.Zero) {
+			price = decimal.Min(price, order.PegCap)
+		}
+	} else {
+		price = price.Div(tick).Ceil().Mul(tick)
+		if order.PegCap.GreaterThan(decimal.Zero) {
+			price = decimal.Max(price, order.PegCap)
+		}
+	}
+
+	bestOrder := o.depths[order.Side.Opposite()].bestOrder()
+	if bestOrder != nil {
+		if order.Side == models.SideBuy && price.GreaterThanOrEqual(bestOrder.Price) {
+			price = bestOrder.Price.Sub(tick)
+		} else if order.Side == models.SideSell && price.LessThanOrEqual(bestOrder.Price) {
+			price = bestOrder.Price.Add(tick)
+		}
+	}
+	return price, price.GreaterThan(decimal.Zero)
+}
+
+// activateOrder executes a triggered stop order in the current trading status, which may have been changed
+// by the circuit breaker since the order was accepted. The order is rejected if it is not accepted as a new
+// order anymore.
+func (o *orderBook) activateOrder(order *models.Order) (logs []Log) {
+	logs = append(logs, newActivateLog(o.nextLogSeq(), o.product.Id, o.time, order))
+
+	activatedOrder := *order
+	activatedOrder.Stop = ""
+	if !o.isAcceptable(&activatedOrder) {
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, newBookOrder(order), order.Size,
+			models.DoneReasonStatusRejected)
+		return append(logs, doneLog)
+	}
+	return append(logs, o.executeOrder(order)...)
+}
+
+// expireOrders moves the clock of the order book forward to the given time, and cancels all the GTT orders
+// whose expire time has been reached.
+func (o *orderBook) expireOrders(now time.Time) (logs []Log) {
+	if now.After(o.time) {
+		o.time = now
+	}
+
+	for !o.expiryQueue.Empty() {
+		key, value := o.expiryQueue.Min()
+		expireKey := key.(*expireTimeOrderIdKey)
+		if expireKey.expireTime.After(o.time) {
+			break
+		}
+		o.expiryQueue.Remove(expireKey)
+
+		// the order may have been filled or cancelled before it expires
+		side := value.(models.Side)
+		bookOrder, found := o.depths[side].orders[expireKey.orderId]
+		if !found {
+			continue
+		}
+
+		remainingSize := bookOrder.Size
+		err := o.depths[side].decrSize(bookOrder.OrderId, bookOrder.Size)
+		if err != nil {
+			panic(err)
+		}
+
+		doneLog := newDoneLog(o.nextLogSeq(), o.product.Id, o.time, bookOrder, remainingSize, models.DoneReasonExpired)
+		logs = append(logs, doneLog)
+	}
+	return logs
+}
+
+// takesLiquidity checks whether the taker order would match when it arrives, a market order always takes
+// liquidity, a limit order only when it crosses the best opposite price
+func (o *orderBook) takesLiquidity(takerOrder *BookOrder) bool {
+	if takerOrder.Type == models.OrderTypeMarket {
+		return true
+	}
+	bestOrder := o.depths[takerOrder.Side.Opposite()].bestOrder()
+	return bestOrder != nil && isCrossed(takerOrder, bestOrder)
+}
+
+// isFillable checks whether the size of the taker order can be filled at once by the orders on the opposite
+// depth, hidden orders included. With self trade prevention, the orders of the same user are no liquidity: they
+// are cancelled with cancel oldest, and the taker is cancelled or decremented at them with the other modes.
+func (o *orderBook) isFillable(takerOrder *BookOrder, size, reference decimal.Decimal,
+	stp models.SelfTradePrevention) bool {
+	remainingSize := size
+
+	makerDepth := o.depths[takerOrder.Side.Opposite()]
+	for itr := makerDepth.queue.Iterator(); itr.Next(); {
+		makerOrder := makerDepth.orders[itr.Value().(int64)]
+
+		if !isCrossed(takerOrder, makerOrder) || !o.isInBand(reference, makerOrder.Price) {
+			break
+		}
+
+		if takerOrder.UserId == makerOrder.UserId && len(stp) != 0 {
+			if stp == models.SelfTradePreventionCancelOldest {
+				continue
+			}
+			break
+		}
+
+		remainingSize = remainingSize.Sub(makerOrder.Size)
+		if remainingSize.LessThanOrEqual(decimal.Zero) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *orderBook) Snapshot() orderBookSnapshot {
+	snapshot, frozen := o.freeze()
+	snapshot.Orders = frozen.copyOrders()
+	return snapshot
+}
+
+// freeze takes a snapshot of the order book except the orders on the book, which are returned as a frozen view
+// to be copied later, so that the order book is only blocked for collecting the pointers of the orders
+func (o *orderBook) freeze() (orderBookSnapshot, *frozenOrders) {
+	// the bitmap of the window is updated in place
+	orderIdWindow := o.orderIdWindow
+	orderIdWindow.Bitmap = o.orderIdWindow.Bitmap.Data(true)
+
+	snapshot := orderBookSnapshot{
+		LogSeq:        o.logSeq,
+		TradeSeq:      o.tradeSeq,
+		OrderIdWindow: orderIdWindow,
+		Time:          o.time,
+		QueueSeq:      o.queueSeq,
+		Status:        o.status,
+
+		LastPrice:        o.lastPrice,
+		IndicativePrice:  o.indicativePrice,
+		IndicativeVolume: o.indicativeVolume,
+		BreakerUntil:     o.breakerUntil,
+	}
+
+	version := int64(1)
+	if o.frozen != nil {
+		version = o.frozen.version + 1
+	}
+	frozen := newFrozenOrders(version, len(o.depths[models.SideSell].orders)+len(o.depths[models.SideBuy].orders))
+	for _, order := range o.depths[models.SideSell].orders {
+		frozen.orders = append(frozen.orders, order)
+	}
+	for _, order := range o.depths[models.SideBuy].orders {
+		frozen.orders = append(frozen.orders, order)
+	}
+	o.frozen = frozen
+	o.depths[models.SideSell].frozen = frozen
+	o.depths[models.SideBuy].frozen = frozen
+
+	for _, order := range o.triggerBook.orders {
+		snapshot.StopOrders = append(snapshot.StopOrders, *order)
+	}
+	snapshot.Groups = o.groups.snapshot()
+
+	return snapshot, frozen
+}
+
+// snapshotting returns true if the orders of the last snapshot are still being copied, the order book can not
+// be frozen again until then
+func (o *orderBook) snapshotting() bool {
+	return o.frozen != nil && !o.frozen.isDone()
+}
+
+func (o *orderBook) Restore(snapshot *orderBookSnapshot) {
+	o.logSeq = snapshot.LogSeq
+	o.tradeSeq = snapshot.TradeSeq
+	o.orderIdWindow = snapshot.OrderIdWindow
+	if o.orderIdWindow.Cap == 0 {
+		o.orderIdWindow = newWindow(0, orderIdWindowCap)
+	}
+
+	o.time = snapshot.Time
+	o.queueSeq = snapshot.QueueSeq
+	if len(snapshot.Status) != 0 {
+		o.status = snapshot.Status
+	}
+	o.lastPrice = snapshot.LastPrice
+	o.indicativePrice = snapshot.IndicativePrice
+	o.indicativeVolume = snapshot.IndicativeVolume
+	o.breakerUntil = snapshot.BreakerUntil
+
+	// orders restored from an older snapshot have no queue seq, they are queued by order id before others
+	for _, order := range snapshot.Orders {
+		o.depths[order.Side].add(order)
+		if order.TimeInForce == models.TimeInForceGTT {
+			o.expiryQueue.Put(&expireTimeOrderIdKey{order.ExpireTime, order.OrderId}, order.Side)
+		}
+		if len(order.Peg) != 0 {
+			o.pegOrders[order.OrderId] = order.Side
+		}
+	}
+
+	for i := range snapshot.StopOrders {
+		o.triggerBook.add(&snapshot.StopOrders[i])
+	}
+
+	for i := range snapshot.Groups {
+		o.groups.add(&snapshot.Groups[i])
+	}
+}
+
+// priceTick returns the minimum price movement of the product
+func (o *orderBook) priceTick() decimal.Decimal {
+	if o.product.QuoteIncrement > 0 {
+		return decimal.NewFromFloat(o.product.QuoteIncrement)
+	}
+	return decimal.New(1, -o.product.QuoteScale)
+}
+
+func (o *orderBook) nextLogSeq() int64 {
+	o.logSeq++
+	return o.logSeq
+}
+
+func (o *orderBook) nextTradeSeq() int64 {
+	o.tradeSeq++
+	return o.tradeSeq
+}
+
+func (o *orderBook) nextQueueSeq() int64 {
+	o.queueSeq++
+	return o.queueSeq
+}
+
+type depth struct {
+	// all orders
+	orders map[int64]*BookOrder
+
+	// price first, lit before hidden, time (queue seq) first order queue for order match
+	// priceOrderIdKey -> orderId
+	queue *treemap.Map
+
+	// the orders of the last snapshot, an order is saved into it before it is updated
+	frozen *frozenOrders
+}
+
+func (d *depth) add(order BookOrder) {
+	// the orders added after the snapshot are not in it
+	if d.frozen != nil {
+		order.frozenVersion = d.frozen.version
+	}
+	d.orders[order.OrderId] = &order
+	d.queue.Put(order.queueKey(), order.OrderId)
+}
+
+// levels returns the total size of each price in the queue order, hidden orders and the hidden reserve of iceberg
+// orders included
+func (d *depth) levels() (prices, sizes []decimal.Decimal) {
+	for itr := d.queue.Iterator(); itr.Next(); {
+		order := d.orders[itr.Value().(int64)]
+		if len(prices) > 0 && prices[len(prices)-1].Equal(order.Price) {
+			sizes[len(sizes)-1] = sizes[len(sizes)-1].Add(order.Size)
+			continue
+		}
+		prices = append(prices, order.Price)
+		sizes = append(sizes, order.Size)
+	}
+	return prices, sizes
+}
+
+// referencePrice returns the best price of the lit orders which are not pegged, or zero if there is none
+func (d *depth) referencePrice() decimal.Decimal {
+	for itr := d.queue.Iterator(); itr.Next(); {
+		order := d.orders[itr.Value().(int64)]
+		if !order.Hidden && len(order.Peg) == 0 {
+			return order.Price
+		}
+	}
+	return decimal.Zero
+}
+
+// bestOrder returns the order at the head of the queue, or nil if the depth is empty
+func (d *depth) bestOrder() *BookOrder {
+	if d.queue.Empty() {
+		return nil
+	}
+	_, orderId := d.queue.Min()
+	return d.orders[orderId.(int64)]
+}
+
+// requeue moves the order to the end of the queue of its price by giving it a new queue seq
+func (d *depth) requeue(order *BookOrder, queueSeq int64) {
+	d.beforeUpdate(order)
+	d.queue.Remove(order.queueKey())
+	order.QueueSeq = queueSeq
+	d.queue.Put(order.queueKey(), order.OrderId)
+}
+
+// reprice moves the order to the end of the queue of the new price
+func (d *depth) reprice(order *BookOrder, price decimal.Decimal, queueSeq int64) {
+	d.beforeUpdate(order)
+	d.queue.Remove(order.queueKey())
+	order.Price = price
+	order.QueueSeq = queueSeq
+	d.queue.Put(order.queueKey(), order.OrderId)
+}
+
+func (d *depth) decrSize(orderId int64, size decimal.Decimal) error {
+	order, found := d.orders[orderId]
+	if !found {
+		return errors.New(fmt.Sprintf("order %v not found on book", orderId))
+	}
+
+	if order.Size.LessThan(size) {
+		return errors.New(fmt.Sprintf("order %v Size %v less than %v", orderId, order.Size, size))
+	}
+
+	d.beforeUpdate(order)
+	order.Size = order.Size.Sub(size)
+	// the reserve of an iceberg order is decreased before its visible slice, the slice of a removed order is kept
+	// for its done log
+	if order.VisibleSize.GreaterThan(order.Size) && order.Size.GreaterThan(decimal.Zero) {
+		order.VisibleSize = order.Size
+	}
+	if order.Size.IsZero() {
+		delete(d.orders, orderId)
+		d.queue.Remove(order.queueKey())
+	}
+
+	return nil
+}
+
+// beforeUpdate must be called before an order on the book is updated in place
+func (d *depth) beforeUpdate(order *BookOrder) {
+	if d.frozen != nil {
+		d.frozen.save(order)
+	}
+}
+
+type BookOrder struct {
+	OrderId     int64
+	UserId      int64
+	Size        decimal.Decimal
+	Funds       decimal.Decimal
+	Price       decimal.Decimal
+	Side        models.Side
+	Type        models.OrderType
+	TimeInForce models.TimeInForce
+	ExpireTime  time.Time
+	QueueSeq    int64
+
+	// the size shown on the book of an iceberg order is at most DisplaySize, VisibleSize is the unfilled
+	// size of the current visible slice, and the rest of Size is the hidden reserve
+	DisplaySize decimal.Decimal
+	VisibleSize decimal.Decimal
+
+	// a hidden order is never shown on the book, it is matched after the lit orders of the same price
+	Hidden bool
+
+	// a taker order is cancelled if less than MinQty can be filled at once when it arrives
+	MinQty decimal.Decimal
+
+	// the price of a pegged order follows the top of book, see pegPrice
+	Peg       models.PegType
+	PegOffset decimal.Decimal
+	PegCap    decimal.Decimal
+
+	// version of the last snapshot the order is saved into, see frozenOrders
+	frozenVersion int64
+}
+
+func newBookOrder(order *models.Order) *BookOrder {
+	return &BookOrder{
+		OrderId:     order.Id,
+		UserId:      order.UserId,
+		Size:        order.Size,
+		Funds:       order.Funds,
+		Price:       order.Price,
+		Side:        order.Side,
+		Type:        order.Type,
+		TimeInForce: order.TimeInForce,
+		ExpireTime:  order.ExpireTime,
+		DisplaySize: order.DisplaySize,
+		Hidden:      order.Hidden,
+		MinQty:      order.MinQty,
+		Peg:         order.Peg,
+		PegOffset:   order.PegOffset,
+		PegCap:      order.PegCap,
+	}
+}
+
+// queueKey returns the key of the order in the depth queue
+func (o *BookOrder) queueKey() *priceOrderIdKey {
+	return &priceOrderIdKey{o.Price, o.Hidden, o.QueueSeq, o.OrderId}
+}
+
+// isMarketBuyByFunds checks whether the order is a market buy order expressed by funds instead of size
+func (o *BookOrder) isMarketBuyByFunds() bool {
+	return o.Type == models.OrderTypeMarket && o.Side == models.SideBuy && o.Size.IsZero()
+}
+
+func (o *BookOrder) isIceberg() bool {
+	return o.DisplaySize.GreaterThan(decimal.Zero)
+}
+
+// visibleSize returns the size of the order shown on the book
+func (o *BookOrder) visibleSize() decimal.Decimal {
+	if o.isIceberg() {
+		return o.VisibleSize
+	}
+	return o.Size
+}
+
+// isCrossed checks whether there is price crossing between the taker and the maker
+func isCrossed(takerOrder, makerOrder *BookOrder) bool {
+	if takerOrder.Side == models.SideBuy {
+		return takerOrder.Price.GreaterThanOrEqual(makerOrder.Price)
+	}
+	return takerOrder.Price.LessThanOrEqual(makerOrder.Price)
+}
+
+func priceOrderIdKeyAscComparator(a, b interface{}) int {
+	aAsserted := a.(*priceOrderIdKey)
+	bAsserted := b.(*priceOrderIdKey)
+
+	x := aAsserted.price.Cmp(bAsserted.price)
+	if x != 0 {
+		return x
+	}
+
+	// lit orders take priority over hidden orders of the same price
+	if aAsserted.hidden != bAsserted.hidden {
+		if aAsserted.hidden {
+			return 1
+		}
+		return -1
+	}
+
+	if aAsserted.seq != bAsserted.seq {
+		if aAsserted.seq > bAsserted.seq {
+			return 1
+		}
+		return -1
+	}
+
+	y := aAsserted.orderId - bAsserted.orderId
+	if y == 0 {
+		return 0
+	} else if y > 0 {
+		return 1
+	} else {
+		return -1
 	}
 }
 
@@ -341,6 +1779,41 @@ func priceOrderIdKeyDescComparator(a, b interface{}) int {
 		return -x
 	}
 
+	// lit orders take priority over hidden orders of the same price
+	if aAsserted.hidden != bAsserted.hidden {
+		if aAsserted.hidden {
+			return 1
+		}
+		return -1
+	}
+
+	if aAsserted.seq != bAsserted.seq {
+		if aAsserted.seq > bAsserted.seq {
+			return 1
+		}
+		return -1
+	}
+
+	y := aAsserted.orderId - bAsserted.orderId
+	if y == 0 {
+		return 0
+	} else if y > 0 {
+		return 1
+	} else {
+		return -1
+	}
+}
+
+func expireTimeOrderIdKeyComparator(a, b interface{}) int {
+	aAsserted := a.(*expireTimeOrderIdKey)
+	bAsserted := b.(*expireTimeOrderIdKey)
+
+	if aAsserted.expireTime.Before(bAsserted.expireTime) {
+		return -1
+	} else if aAsserted.expireTime.After(bAsserted.expireTime) {
+		return 1
+	}
+
 	y := aAsserted.orderId - bAsserted.orderId
 	if y == 0 {
 		return 0
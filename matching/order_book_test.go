@@ -0,0 +1,1220 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// orderBookCase applies the orders and the commands of before to a new order book, and then checks the logs of
+// the step. An order whose status is cancelling is cancelled, like the engine does.
+type orderBookCase struct {
+	name    string
+	product *models.Product
+	before  []interface{}
+	step    interface{}
+	want    []string
+}
+
+var testTime = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestProduct() *models.Product {
+	return &models.Product{Id: "BTC-USDT", BaseScale: 4, QuoteScale: 2, QuoteIncrement: 0.01}
+}
+
+func runOrderBookCases(t *testing.T, cases []orderBookCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			product := c.product
+			if product == nil {
+				product = newTestProduct()
+			}
+			o := NewOrderBook(product)
+			for _, input := range c.before {
+				applyInput(o, input)
+			}
+
+			var got []string
+			for _, log := range applyInput(o, c.step) {
+				got = append(got, describeLog(log))
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("logs mismatch\ngot:  %q\nwant: %q", got, c.want)
+			}
+		})
+	}
+}
+
+func applyInput(o *orderBook, input interface{}) []Log {
+	switch input := input.(type) {
+	case *Command:
+		return o.ApplyCommand(input)
+	case *models.Order:
+		if input.Status == models.OrderStatusCancelling {
+			return o.CancelOrder(input)
+		}
+		return o.ApplyOrder(input)
+	default:
+		panic(fmt.Sprintf("unknown input: %T", input))
+	}
+}
+
+// describeLog formats the fields of a log which matter to the tests in one line
+func describeLog(log Log) string {
+	switch l := log.(type) {
+	case *ReceivedLog:
+		return fmt.Sprintf("received %v", l.OrderId)
+	case *RejectLog:
+		return fmt.Sprintf("rejected %v %v", l.OrderId, l.Reason)
+	case *OpenLog:
+		if l.Hidden {
+			return fmt.Sprintf("open %v %v %v %v hidden", l.OrderId, l.Side, l.Price, l.RemainingSize)
+		}
+		return fmt.Sprintf("open %v %v %v %v", l.OrderId, l.Side, l.Price, l.RemainingSize)
+	case *DoneLog:
+		return fmt.Sprintf("done %v %v %v", l.OrderId, l.Reason, l.RemainingSize)
+	case *MatchLog:
+		return fmt.Sprintf("match %v %v %v %v", l.TakerOrderId, l.MakerOrderId, l.Price, l.Size)
+	case *ChangeLog:
+		return fmt.Sprintf("change %v %v->%v %v->%v", l.OrderId, l.OldPrice, l.Price, l.OldSize, l.NewSize)
+	case *ActivateLog:
+		return fmt.Sprintf("activate %v", l.OrderId)
+	case *StatusLog:
+		return fmt.Sprintf("status %v->%v", l.OldStatus, l.Status)
+	case *AuctionLog:
+		return fmt.Sprintf("auction %v %v", l.Price, l.Volume)
+	case *BreakerLog:
+		return fmt.Sprintf("breaker %v %v", l.Price, l.Status)
+	case *TrailLog:
+		return fmt.Sprintf("trail %v %v", l.OrderId, l.StopPrice)
+	default:
+		return fmt.Sprintf("%T", log)
+	}
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// limitOrder returns a limit order created id seconds after testTime, so that the clock of the order book moves
+// forward with the ids
+func limitOrder(id, userId int64, side models.Side, price, size string, options ...func(*models.Order)) *models.Order {
+	order := &models.Order{
+		Id:        id,
+		UserId:    userId,
+		CreatedAt: testTime.Add(time.Duration(id) * time.Second),
+		Type:      models.OrderTypeLimit,
+		Side:      side,
+		Price:     dec(price),
+		Size:      dec(size),
+		Status:    models.OrderStatusNew,
+	}
+	for _, option := range options {
+		option(order)
+	}
+	return order
+}
+
+// marketOrder returns a market order by size, or a market buy order by funds if the size is 0
+func marketOrder(id, userId int64, side models.Side, size, funds string, options ...func(*models.Order)) *models.Order {
+	order := &models.Order{
+		Id:        id,
+		UserId:    userId,
+		CreatedAt: testTime.Add(time.Duration(id) * time.Second),
+		Type:      models.OrderTypeMarket,
+		Side:      side,
+		Size:      dec(size),
+		Funds:     dec(funds),
+		Status:    models.OrderStatusNew,
+	}
+	for _, option := range options {
+		option(order)
+	}
+	return order
+}
+
+// cancelOrder returns the cancel request of the order, created at the given seconds after testTime
+func cancelOrder(order *models.Order, at int64) *models.Order {
+	cancel := *order
+	cancel.Status = models.OrderStatusCancelling
+	cancel.UpdatedAt = testTime.Add(time.Duration(at) * time.Second)
+	return &cancel
+}
+
+func clockCommand(at int64) *Command {
+	return &Command{
+		CommandType: CommandTypeClock,
+		Time:        testTime.Add(time.Duration(at) * time.Second),
+	}
+}
+
+func statusCommand(status models.ProductStatus, at int64) *Command {
+	return &Command{
+		CommandType: CommandTypeProductStatus,
+		Status:      status,
+		Time:        testTime.Add(time.Duration(at) * time.Second),
+	}
+}
+
+// amendCommand changes the remaining size and the price of the order, 0 for unchanged
+func amendCommand(order *models.Order, size, price string, at int64) *Command {
+	return &Command{
+		CommandType: CommandTypeAmend,
+		UserId:      order.UserId,
+		OrderId:     order.Id,
+		Side:        order.Side,
+		Size:        dec(size),
+		Price:       dec(price),
+		Time:        testTime.Add(time.Duration(at) * time.Second),
+	}
+}
+
+func timeInForce(timeInForce models.TimeInForce) func(*models.Order) {
+	return func(order *models.Order) {
+		order.TimeInForce = timeInForce
+	}
+}
+
+func TestTimeInForce(t *testing.T) {
+	expireAt := func(seconds int64) func(*models.Order) {
+		return func(order *models.Order) {
+			order.TimeInForce = models.TimeInForceGTT
+			order.ExpireTime = testTime.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "gtc rests the remaining size",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "3"),
+			want:   []string{"received 2", "match 2 1 100 1", "done 1 filled 0", "open 2 buy 101 2"},
+		},
+		{
+			name:   "ioc cancels the remaining size",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "3", timeInForce(models.TimeInForceIOC)),
+			want:   []string{"received 2", "match 2 1 100 1", "done 1 filled 0", "done 2 iocCancelled 2"},
+		},
+		{
+			name:   "ioc without a match is cancelled",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "99", "1", timeInForce(models.TimeInForceIOC)),
+			want:   []string{"received 2", "done 2 iocCancelled 1"},
+		},
+		{
+			name: "fok is filled completely",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1"),
+				limitOrder(2, 1, models.SideSell, "101", "2"),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "101", "3", timeInForce(models.TimeInForceFOK)),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 2",
+				"done 2 filled 0", "done 3 filled 0"},
+		},
+		{
+			name: "fok is rejected without matching if it cannot be filled completely",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1"),
+				limitOrder(2, 1, models.SideSell, "102", "2"),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "101", "3", timeInForce(models.TimeInForceFOK)),
+			want: []string{"received 3", "done 3 fokRejected 3"},
+		},
+		{
+			name:   "gtt expires when the clock reaches the expire time",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "1", expireAt(5))},
+			step:   limitOrder(10, 2, models.SideSell, "100", "1"),
+			want:   []string{"done 1 expired 1", "received 10", "open 10 sell 100 1"},
+		},
+		{
+			name:   "gtt matches before the expire time",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "1", expireAt(5))},
+			step:   limitOrder(4, 2, models.SideSell, "100", "1"),
+			want:   []string{"received 4", "match 4 1 100 1", "done 1 filled 0", "done 4 filled 0"},
+		},
+		{
+			name:   "gtt expires on a clock command without any order flow",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "1", expireAt(5))},
+			step:   clockCommand(6),
+			want:   []string{"done 1 expired 1"},
+		},
+		{
+			name:   "clock command before the expire time changes nothing",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "1", expireAt(5))},
+			step:   clockCommand(4),
+			want:   nil,
+		},
+		{
+			name: "cancel advances the clock with the time it is sent instead of the creation time of the order",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideBuy, "100", "1"),
+				limitOrder(2, 2, models.SideBuy, "99", "1", expireAt(5)),
+			},
+			step: cancelOrder(limitOrder(1, 1, models.SideBuy, "100", "1"), 6),
+			want: []string{"done 2 expired 1", "done 1 cancelled 1"},
+		},
+	})
+}
+
+func TestHiddenAndMinQty(t *testing.T) {
+	hidden := func(order *models.Order) {
+		order.Hidden = true
+	}
+	minQty := func(size string) func(*models.Order) {
+		return func(order *models.Order) {
+			order.MinQty = dec(size)
+		}
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "hidden order is matched after the lit orders at the same price",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1", hidden),
+				limitOrder(2, 1, models.SideSell, "100", "1"),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "100", "1"),
+			want: []string{"received 3", "match 3 2 100 1", "done 2 filled 0", "done 3 filled 0"},
+		},
+		{
+			name: "hidden order is opened as hidden",
+			step: limitOrder(1, 1, models.SideSell, "100", "1", hidden),
+			want: []string{"received 1", "open 1 sell 100 1 hidden"},
+		},
+		{
+			name:   "min qty order which doesn't cross rests",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "102", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "3", minQty("2")),
+			want:   []string{"received 2", "open 2 buy 101 3"},
+		},
+		{
+			name: "min qty order on an empty book rests",
+			step: limitOrder(1, 1, models.SideBuy, "101", "3", minQty("2")),
+			want: []string{"received 1", "open 1 buy 101 3"},
+		},
+		{
+			name:   "min qty order which crosses is rejected if less than min qty can be filled",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "3", minQty("2")),
+			want:   []string{"received 2", "done 2 minQtyRejected 3"},
+		},
+		{
+			name: "min qty order rests the remaining size after a fill of at least min qty",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1"),
+				limitOrder(2, 1, models.SideSell, "101", "1"),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "101", "3", minQty("2")),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 1", "done 2 filled 0",
+				"open 3 buy 101 1"},
+		},
+		{
+			name:   "min qty counts the hidden orders",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "2", hidden)},
+			step:   limitOrder(2, 2, models.SideBuy, "100", "2", minQty("2")),
+			want:   []string{"received 2", "match 2 1 100 2", "done 1 filled 0", "done 2 filled 0"},
+		},
+		{
+			name:   "min qty market order is rejected if less than min qty can be filled",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "1")},
+			step:   marketOrder(2, 2, models.SideSell, "3", "0", minQty("2")),
+			want:   []string{"received 2", "done 2 minQtyRejected 0"},
+		},
+	})
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	stp := func(stp models.SelfTradePrevention) func(*models.Order) {
+		return func(order *models.Order) {
+			order.SelfTradePrevention = stp
+		}
+	}
+	makers := []interface{}{
+		limitOrder(1, 1, models.SideSell, "100", "1"),
+		limitOrder(2, 2, models.SideSell, "101", "1"),
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "cancel oldest cancels the maker and goes on matching",
+			before: makers,
+			step:   limitOrder(3, 1, models.SideBuy, "101", "2", stp(models.SelfTradePreventionCancelOldest)),
+			want: []string{"received 3", "done 1 selfTradePrevented 1", "match 3 2 101 1", "done 2 filled 0",
+				"open 3 buy 101 1"},
+		},
+		{
+			name:   "cancel newest cancels the taker",
+			before: makers,
+			step:   limitOrder(3, 1, models.SideBuy, "101", "2", stp(models.SelfTradePreventionCancelNewest)),
+			want:   []string{"received 3", "done 3 selfTradePrevented 2"},
+		},
+		{
+			name:   "cancel both cancels the maker and the taker",
+			before: makers,
+			step:   limitOrder(3, 1, models.SideBuy, "101", "2", stp(models.SelfTradePreventionCancelBoth)),
+			want:   []string{"received 3", "done 1 selfTradePrevented 1", "done 3 selfTradePrevented 2"},
+		},
+		{
+			name:   "no prevention without a mode",
+			before: makers,
+			step:   limitOrder(3, 1, models.SideBuy, "100", "1"),
+			want:   []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "done 3 filled 0"},
+		},
+		{
+			name:   "decrement and cancel decrements the larger maker",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "3")},
+			step:   limitOrder(2, 1, models.SideBuy, "100", "1", stp(models.SelfTradePreventionDecrementAndCancel)),
+			want:   []string{"received 2", "change 1 100->100 3->2", "done 2 selfTradePrevented 1"},
+		},
+		{
+			name:   "decrement and cancel decrements the larger taker",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 1, models.SideBuy, "100", "3", stp(models.SelfTradePreventionDecrementAndCancel)),
+			want:   []string{"received 2", "done 1 selfTradePrevented 1", "open 2 buy 100 2"},
+		},
+		{
+			name:   "decrement and cancel cancels both of the same size",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "2")},
+			step:   limitOrder(2, 1, models.SideBuy, "100", "2", stp(models.SelfTradePreventionDecrementAndCancel)),
+			want:   []string{"received 2", "done 1 selfTradePrevented 2", "done 2 selfTradePrevented 2"},
+		},
+		{
+			name:   "decrement and cancel decrements the maker by the size of a market buy by funds",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "3")},
+			step:   marketOrder(2, 1, models.SideBuy, "0", "150", stp(models.SelfTradePreventionDecrementAndCancel)),
+			want:   []string{"received 2", "change 1 100->100 3->1.5", "done 2 selfTradePrevented 0"},
+		},
+		{
+			name:   "decrement and cancel leaves the maker if the funds buy nothing",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "3")},
+			step:   marketOrder(2, 1, models.SideBuy, "0", "0.001", stp(models.SelfTradePreventionDecrementAndCancel)),
+			want:   []string{"received 2", "done 2 selfTradePrevented 0"},
+		},
+		{
+			name: "fok doesn't count the makers of the same user as liquidity",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1"),
+				limitOrder(2, 2, models.SideSell, "100", "1"),
+			},
+			step: limitOrder(3, 1, models.SideBuy, "100", "2", timeInForce(models.TimeInForceFOK),
+				stp(models.SelfTradePreventionCancelOldest)),
+			want: []string{"received 3", "done 3 fokRejected 2"},
+		},
+		{
+			name: "fok with cancel oldest is filled by the other makers",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "1"),
+				limitOrder(2, 2, models.SideSell, "100", "2"),
+			},
+			step: limitOrder(3, 1, models.SideBuy, "100", "2", timeInForce(models.TimeInForceFOK),
+				stp(models.SelfTradePreventionCancelOldest)),
+			want: []string{"received 3", "done 1 selfTradePrevented 1", "match 3 2 100 2", "done 2 filled 0",
+				"done 3 filled 0"},
+		},
+		{
+			name: "fok with cancel newest is rejected at a maker of the same user",
+			before: []interface{}{
+				limitOrder(1, 2, models.SideSell, "100", "1"),
+				limitOrder(2, 1, models.SideSell, "100", "1"),
+				limitOrder(3, 2, models.SideSell, "100", "1"),
+			},
+			step: limitOrder(4, 1, models.SideBuy, "100", "2", timeInForce(models.TimeInForceFOK),
+				stp(models.SelfTradePreventionCancelNewest)),
+			want: []string{"received 4", "done 4 fokRejected 2"},
+		},
+	})
+}
+
+func TestProductStatus(t *testing.T) {
+	restingOrder := limitOrder(1, 1, models.SideBuy, "100", "2")
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "status change is written as a status log",
+			step: statusCommand(models.ProductStatusHalted, 1),
+			want: []string{"status open->halted"},
+		},
+		{
+			name:    "book starts open whatever the status in the database",
+			product: &models.Product{Id: "BTC-USDT", BaseScale: 4, QuoteScale: 2, Status: models.ProductStatusHalted},
+			step:    limitOrder(1, 1, models.SideBuy, "100", "1"),
+			want:    []string{"received 1", "open 1 buy 100 1"},
+		},
+		{
+			name:   "same status changes nothing",
+			before: []interface{}{statusCommand(models.ProductStatusHalted, 1)},
+			step:   statusCommand(models.ProductStatusHalted, 2),
+		},
+		{
+			name:   "halted product rejects new orders",
+			before: []interface{}{statusCommand(models.ProductStatusHalted, 1)},
+			step:   limitOrder(2, 1, models.SideBuy, "100", "1"),
+			want:   []string{"rejected 2 productHalted"},
+		},
+		{
+			name:   "halted product cancels orders",
+			before: []interface{}{restingOrder, statusCommand(models.ProductStatusHalted, 2)},
+			step:   cancelOrder(restingOrder, 3),
+			want:   []string{"done 1 cancelled 2"},
+		},
+		{
+			name:   "halted product amends orders",
+			before: []interface{}{restingOrder, statusCommand(models.ProductStatusHalted, 2)},
+			step:   amendCommand(restingOrder, "1", "0", 3),
+			want:   []string{"change 1 100->100 2->1"},
+		},
+		{
+			name:   "halted product mass cancels orders",
+			before: []interface{}{restingOrder, statusCommand(models.ProductStatusHalted, 2)},
+			step:   &Command{CommandType: CommandTypeMassCancel, UserId: 1, Time: testTime.Add(3 * time.Second)},
+			want:   []string{"done 1 cancelled 2"},
+		},
+		{
+			name:   "cancel only product refuses new orders",
+			before: []interface{}{statusCommand(models.ProductStatusCancelOnly, 1)},
+			step:   limitOrder(2, 1, models.SideBuy, "100", "1"),
+			want:   []string{"received 2", "done 2 statusRejected 1"},
+		},
+		{
+			name:   "post only product refuses market orders",
+			before: []interface{}{restingOrder, statusCommand(models.ProductStatusPostOnly, 2)},
+			step:   marketOrder(3, 2, models.SideSell, "1", "0"),
+			want:   []string{"received 3", "done 3 statusRejected 1"},
+		},
+		{
+			name:   "post only product rejects limit orders which would take liquidity",
+			before: []interface{}{restingOrder, statusCommand(models.ProductStatusPostOnly, 2)},
+			step:   limitOrder(3, 2, models.SideSell, "100", "1"),
+			want:   []string{"received 3", "done 3 postOnlyRejected 1"},
+		},
+	})
+}
+
+func TestPostOnly(t *testing.T) {
+	postOnly := func(slide bool) func(*models.Order) {
+		return func(order *models.Order) {
+			order.PostOnly = true
+			order.PostOnlySlide = slide
+		}
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "post only order which doesn't cross rests",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "99", "1", postOnly(false)),
+			want:   []string{"received 2", "open 2 buy 99 1"},
+		},
+		{
+			name:   "post only order which crosses is rejected",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "1", postOnly(false)),
+			want:   []string{"received 2", "done 2 postOnlyRejected 1"},
+		},
+		{
+			name:   "post only buy order slides one tick below the best ask",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "101", "1", postOnly(true)),
+			want:   []string{"received 2", "open 2 buy 99.99 1"},
+		},
+		{
+			name:   "post only sell order slides one tick above the best bid",
+			before: []interface{}{limitOrder(1, 1, models.SideBuy, "100", "2")},
+			step:   limitOrder(2, 2, models.SideSell, "99", "1", postOnly(true)),
+			want:   []string{"received 2", "open 2 sell 100.01 1"},
+		},
+		{
+			name:   "post only buy order is rejected if the slide price is not positive",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "0.01", "1")},
+			step:   limitOrder(2, 2, models.SideBuy, "1", "1", postOnly(true)),
+			want:   []string{"received 2", "done 2 postOnlyRejected 1"},
+		},
+	})
+}
+
+func stopAt(stop models.Stop, stopPrice string) func(*models.Order) {
+	return func(order *models.Order) {
+		order.Stop = stop
+		order.StopPrice = dec(stopPrice)
+	}
+}
+
+func TestStopOrders(t *testing.T) {
+	stopLoss := marketOrder(1, 1, models.SideSell, "1", "0", stopAt(models.StopLoss, "99"))
+	bids := []interface{}{
+		stopLoss,
+		limitOrder(2, 2, models.SideBuy, "99", "1"),
+		limitOrder(3, 2, models.SideBuy, "98", "1"),
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "stop order waits in the trigger book",
+			step: stopLoss,
+			want: []string{"received 1"},
+		},
+		{
+			name:   "stop loss order is activated by a trade at the stop price",
+			before: bids,
+			step:   limitOrder(4, 3, models.SideSell, "99", "1"),
+			want: []string{"received 4", "match 4 2 99 1", "done 2 filled 0", "done 4 filled 0", "activate 1",
+				"match 1 3 98 1", "done 3 filled 0", "done 1 filled 0"},
+		},
+		{
+			name: "stop loss order is not activated by a trade above the stop price",
+			before: []interface{}{
+				stopLoss,
+				limitOrder(2, 2, models.SideBuy, "100", "1"),
+			},
+			step: limitOrder(3, 3, models.SideSell, "100", "1"),
+			want: []string{"received 3", "match 3 2 100 1", "done 2 filled 0", "done 3 filled 0"},
+		},
+		{
+			name: "stop entry limit order is activated by a trade at or above the stop price and rests",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideBuy, "102", "1", stopAt(models.StopEntry, "101")),
+				limitOrder(2, 2, models.SideSell, "101", "1"),
+			},
+			step: limitOrder(3, 3, models.SideBuy, "101", "1"),
+			want: []string{"received 3", "match 3 2 101 1", "done 2 filled 0", "done 3 filled 0", "activate 1",
+				"open 1 buy 102 1"},
+		},
+		{
+			name:   "stop order is cancelled from the trigger book",
+			before: []interface{}{stopLoss},
+			step:   cancelOrder(stopLoss, 2),
+			want:   []string{"done 1 cancelled 1"},
+		},
+		{
+			name:   "stop orders are accepted in call auction",
+			before: []interface{}{statusCommand(models.ProductStatusAuction, 1)},
+			step:   limitOrder(2, 1, models.SideBuy, "102", "1", stopAt(models.StopEntry, "101")),
+			want:   []string{"received 2"},
+		},
+		{
+			name: "stop orders triggered by the same trade are activated in order of id",
+			before: []interface{}{
+				marketOrder(1, 1, models.SideSell, "1", "0", stopAt(models.StopLoss, "99")),
+				limitOrder(2, 1, models.SideSell, "99", "1", stopAt(models.StopLoss, "99.5")),
+				limitOrder(3, 2, models.SideBuy, "99", "1"),
+				limitOrder(4, 2, models.SideBuy, "90", "1"),
+			},
+			step: limitOrder(5, 3, models.SideSell, "98", "1"),
+			want: []string{"received 5", "match 5 3 99 1", "done 3 filled 0", "done 5 filled 0", "activate 1",
+				"match 1 4 90 1", "done 4 filled 0", "done 1 filled 0", "activate 2", "open 2 sell 99 1"},
+		},
+	})
+}
+
+func TestAmendOrder(t *testing.T) {
+	first := limitOrder(1, 1, models.SideSell, "100", "2")
+	second := limitOrder(2, 2, models.SideSell, "100", "1")
+	bid := limitOrder(3, 3, models.SideBuy, "99", "1")
+	otherUser := amendCommand(first, "1", "0", 3)
+	otherUser.UserId = 2
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "size decrease is written as a change log",
+			before: []interface{}{first},
+			step:   amendCommand(first, "1", "0", 2),
+			want:   []string{"change 1 100->100 2->1"},
+		},
+		{
+			name:   "size decrease keeps the queue priority",
+			before: []interface{}{first, second, amendCommand(first, "1", "0", 3)},
+			step:   limitOrder(4, 3, models.SideBuy, "100", "1"),
+			want:   []string{"received 4", "match 4 1 100 1", "done 1 filled 0", "done 4 filled 0"},
+		},
+		{
+			name: "price change loses the queue priority",
+			before: []interface{}{
+				first,
+				limitOrder(2, 2, models.SideSell, "101", "1"),
+				amendCommand(first, "0", "101", 3),
+			},
+			step: limitOrder(4, 3, models.SideBuy, "101", "1"),
+			want: []string{"received 4", "match 4 2 101 1", "done 2 filled 0", "done 4 filled 0"},
+		},
+		{
+			name:   "price and size are changed together",
+			before: []interface{}{first},
+			step:   amendCommand(first, "1.5", "100.5", 2),
+			want:   []string{"change 1 100->100.5 2->1.5"},
+		},
+		{
+			name:   "amend which crosses the opposite depth is ignored",
+			before: []interface{}{first, bid},
+			step:   amendCommand(bid, "0", "100", 4),
+		},
+		{
+			name:   "size increase is ignored",
+			before: []interface{}{first},
+			step:   amendCommand(first, "3", "0", 2),
+		},
+		{
+			name:   "same amend applied again changes nothing",
+			before: []interface{}{first, amendCommand(first, "1", "0", 2)},
+			step:   amendCommand(first, "1", "0", 3),
+		},
+		{
+			name:   "amend of another user's order is ignored",
+			before: []interface{}{first},
+			step:   otherUser,
+		},
+		{
+			name:   "amend may cross the opposite depth in call auction",
+			before: []interface{}{first, bid, statusCommand(models.ProductStatusAuction, 4)},
+			step:   amendCommand(bid, "0", "100", 5),
+			want:   []string{"change 3 99->100 1->1", "auction 100 1"},
+		},
+	})
+}
+
+func TestIcebergOrders(t *testing.T) {
+	display := func(size string) func(*models.Order) {
+		return func(order *models.Order) {
+			order.DisplaySize = dec(size)
+		}
+	}
+	iceberg := limitOrder(1, 1, models.SideSell, "100", "3", display("1"))
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "iceberg order only shows the display size",
+			step: iceberg,
+			want: []string{"received 1", "open 1 sell 100 1"},
+		},
+		{
+			name:   "refilled slice is queued behind the orders at the same price",
+			before: []interface{}{iceberg, limitOrder(2, 2, models.SideSell, "100", "1")},
+			step:   limitOrder(3, 3, models.SideBuy, "100", "2"),
+			want: []string{"received 3", "match 3 1 100 1", "open 1 sell 100 1", "match 3 2 100 1", "done 2 filled 0",
+				"done 3 filled 0"},
+		},
+		{
+			name:   "taker is matched slice by slice",
+			before: []interface{}{iceberg},
+			step:   limitOrder(2, 2, models.SideBuy, "100", "3"),
+			want: []string{"received 2", "match 2 1 100 1", "open 1 sell 100 1", "match 2 1 100 1", "open 1 sell 100 1",
+				"match 2 1 100 1", "done 1 filled 0", "done 2 filled 0"},
+		},
+		{
+			name:   "last slice shows the rest of the reserve",
+			before: []interface{}{limitOrder(1, 1, models.SideSell, "100", "1.5", display("1"))},
+			step:   limitOrder(2, 2, models.SideBuy, "100", "1"),
+			want:   []string{"received 2", "match 2 1 100 1", "open 1 sell 100 0.5", "done 2 filled 0"},
+		},
+		{
+			name: "visible slice is capped to the new size when the amend moves the order",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "10", display("2")),
+				amendCommand(limitOrder(1, 1, models.SideSell, "100", "10"), "1", "101", 2),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "101", "5"),
+			want: []string{"received 3", "match 3 1 101 1", "done 1 filled 0", "open 3 buy 101 4"},
+		},
+		{
+			name: "visible slice is capped to the new size when the amend decreases the size in place",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideSell, "100", "10", display("2")),
+				amendCommand(limitOrder(1, 1, models.SideSell, "100", "10"), "1", "0", 2),
+			},
+			step: limitOrder(3, 2, models.SideBuy, "100", "5"),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "open 3 buy 100 4"},
+		},
+	})
+}
+
+func TestMarketOrders(t *testing.T) {
+	protectAt := func(price string) func(*models.Order) {
+		return func(order *models.Order) {
+			order.ProtectionPrice = dec(price)
+		}
+	}
+	asks := []interface{}{
+		limitOrder(1, 1, models.SideSell, "100", "1"),
+		limitOrder(2, 1, models.SideSell, "101", "1"),
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "market buy by size is matched by its size and not by the funds held",
+			before: asks,
+			step:   marketOrder(3, 2, models.SideBuy, "1.5", "1000"),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 0.5",
+				"done 3 filled 0"},
+		},
+		{
+			name:   "market buy by funds is matched by its funds",
+			before: asks,
+			step:   marketOrder(3, 2, models.SideBuy, "0", "150.5"),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 0.5",
+				"done 3 filled 0"},
+		},
+		{
+			name:   "market buy which exhausts the book is cancelled",
+			before: asks,
+			step:   marketOrder(3, 2, models.SideBuy, "3", "1000"),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 1", "done 2 filled 0",
+				"done 3 cancelled 0"},
+		},
+		{
+			name:   "market buy stops matching at the protection price",
+			before: asks,
+			step:   marketOrder(3, 2, models.SideBuy, "2", "1000", protectAt("100")),
+			want:   []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "done 3 priceProtected 0"},
+		},
+		{
+			name: "market sell stops matching at the protection price",
+			before: []interface{}{
+				limitOrder(1, 1, models.SideBuy, "100", "1"),
+				limitOrder(2, 1, models.SideBuy, "99", "1"),
+			},
+			step: marketOrder(3, 2, models.SideSell, "2", "0", protectAt("99.5")),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "done 3 priceProtected 0"},
+		},
+		{
+			name:   "market buy within the protection price is filled",
+			before: asks,
+			step:   marketOrder(3, 2, models.SideBuy, "2", "1000", protectAt("101")),
+			want: []string{"received 3", "match 3 1 100 1", "done 1 filled 0", "match 3 2 101 1", "done 2 filled 0",
+				"done 3 filled 0"},
+		},
+	})
+}
+
+func TestCallAuction(t *testing.T) {
+	auction := []interface{}{
+		limitOrder(1, 1, models.SideSell, "100", "2"),
+		statusCommand(models.ProductStatusAuction, 2),
+	}
+	crossed := append(auction[:2:2], limitOrder(3, 2, models.SideBuy, "101", "1"))
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "crossing order rests and publishes the indicative price",
+			before: auction,
+			step:   limitOrder(3, 2, models.SideBuy, "101", "1"),
+			want:   []string{"received 3", "open 3 buy 101 1", "auction 101 1"},
+		},
+		{
+			name:   "order which doesn't change the indicative price publishes nothing",
+			before: crossed,
+			step:   limitOrder(4, 2, models.SideBuy, "90", "1"),
+			want:   []string{"received 4", "open 4 buy 90 1"},
+		},
+		{
+			name:   "market order is refused in call auction",
+			before: auction,
+			step:   marketOrder(3, 2, models.SideBuy, "1", "1000"),
+			want:   []string{"received 3", "done 3 statusRejected 1"},
+		},
+		{
+			name:   "ioc order is refused in call auction",
+			before: auction,
+			step:   limitOrder(3, 2, models.SideBuy, "101", "1", timeInForce(models.TimeInForceIOC)),
+			want:   []string{"received 3", "done 3 statusRejected 1"},
+		},
+		{
+			name:   "cancel updates the indicative price",
+			before: crossed,
+			step:   cancelOrder(crossed[2].(*models.Order), 4),
+			want:   []string{"done 3 cancelled 1", "auction 0 0"},
+		},
+		{
+			name:   "opening uncrosses the book at the equilibrium price",
+			before: crossed,
+			step:   statusCommand(models.ProductStatusOpen, 4),
+			want:   []string{"match 3 1 101 1", "done 3 filled 0", "status auction->open"},
+		},
+		{
+			name: "uncross price is the one with the largest volume",
+			before: append(auction[:2:2],
+				limitOrder(3, 2, models.SideSell, "101", "1"),
+				limitOrder(4, 3, models.SideBuy, "102", "1"),
+				limitOrder(5, 3, models.SideBuy, "100", "2"),
+			),
+			step: statusCommand(models.ProductStatusOpen, 6),
+			want: []string{"match 4 1 100 1", "done 4 filled 0", "match 5 1 100 1", "done 1 filled 0",
+				"status auction->open"},
+		},
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	bandProduct := func(auctionSeconds int64) *models.Product {
+		product := newTestProduct()
+		product.PriceBand = dec("0.05")
+		product.BandAuctionSeconds = auctionSeconds
+		return product
+	}
+	// the last trade price is 100, so the price band is 95 to 105
+	traded := []interface{}{
+		limitOrder(1, 1, models.SideSell, "100", "1"),
+		limitOrder(2, 2, models.SideBuy, "100", "1"),
+		limitOrder(3, 1, models.SideSell, "106", "1"),
+	}
+	tripped := append(traded[:3:3], limitOrder(4, 2, models.SideBuy, "106", "1"))
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:    "trade inside the price band is made",
+			product: bandProduct(10),
+			before:  traded,
+			step:    limitOrder(4, 2, models.SideBuy, "105", "1"),
+			want:    []string{"received 4", "open 4 buy 105 1"},
+		},
+		{
+			name:    "trade outside the price band moves the product into call auction",
+			product: bandProduct(10),
+			before:  traded,
+			step:    limitOrder(4, 2, models.SideBuy, "106", "1"),
+			want: []string{"received 4", "open 4 buy 106 1", "breaker 106 auction", "status open->auction",
+				"auction 106 1"},
+		},
+		{
+			name:    "trade outside the price band halts the product without an auction duration",
+			product: bandProduct(0),
+			before:  traded,
+			step:    limitOrder(4, 2, models.SideBuy, "106", "1"),
+			want:    []string{"received 4", "open 4 buy 106 1", "breaker 106 halted", "status open->halted"},
+		},
+		{
+			name:    "market order is cancelled by the circuit breaker",
+			product: bandProduct(10),
+			before:  traded,
+			step:    marketOrder(4, 2, models.SideBuy, "1", "1000"),
+			want:    []string{"received 4", "done 4 circuitBreaker 0", "breaker 106 auction", "status open->auction"},
+		},
+		{
+			name:    "continuous trading is reopened at the end of the auction",
+			product: bandProduct(10),
+			before:  tripped,
+			step:    limitOrder(20, 3, models.SideSell, "200", "1"),
+			want: []string{"match 4 3 106 1", "done 3 filled 0", "done 4 filled 0", "status auction->open",
+				"received 20", "open 20 sell 200 1"},
+		},
+		{
+			name:    "auction is not ended before its end time",
+			product: bandProduct(10),
+			before:  tripped,
+			step:    limitOrder(13, 3, models.SideSell, "200", "1"),
+			want:    []string{"received 13", "open 13 sell 200 1"},
+		},
+	})
+}
+
+// groupCommand places the orders as a group whose id is the id of the first order
+func groupCommand(groupType models.OrderGroupType, at int64, orders ...*models.Order) *Command {
+	for _, order := range orders {
+		order.GroupId = orders[0].Id
+		order.GroupType = groupType
+	}
+	return &Command{
+		CommandType: CommandTypePlaceGroup,
+		Orders:      orders,
+		Time:        testTime.Add(time.Duration(at) * time.Second),
+	}
+}
+
+func TestOrderGroups(t *testing.T) {
+	oco := func() (*Command, *models.Order) {
+		limit := limitOrder(1, 1, models.SideSell, "110", "1")
+		stop := marketOrder(2, 1, models.SideSell, "1", "0", stopAt(models.StopLoss, "95"))
+		return groupCommand(models.OrderGroupTypeOCO, 2, limit, stop), limit
+	}
+	bracket := func() (*Command, *models.Order) {
+		entry := limitOrder(1, 1, models.SideBuy, "100", "1")
+		profit := limitOrder(2, 1, models.SideSell, "110", "1")
+		loss := marketOrder(3, 1, models.SideSell, "1", "0", stopAt(models.StopLoss, "95"))
+		return groupCommand(models.OrderGroupTypeBracket, 3, entry, profit, loss), entry
+	}
+	ocoCommand, ocoLimit := oco()
+	bracketCommand, bracketEntry := bracket()
+	rejectedCommand, _ := oco()
+	rejectedCommand.Orders[1].Size = decimal.Zero
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "oco legs are put into the order book at once",
+			step: ocoCommand,
+			want: []string{"received 1", "received 2", "open 1 sell 110 1"},
+		},
+		{
+			name:   "fill of an oco leg cancels the other leg",
+			before: []interface{}{ocoCommand},
+			step:   limitOrder(3, 2, models.SideBuy, "110", "1"),
+			want: []string{"received 3", "match 3 1 110 1", "done 1 filled 0", "done 3 filled 0",
+				"done 2 groupCancelled 1"},
+		},
+		{
+			name:   "cancel of an oco leg cancels the other leg",
+			before: []interface{}{ocoCommand},
+			step:   cancelOrder(ocoLimit, 3),
+			want:   []string{"done 1 cancelled 1", "done 2 groupCancelled 1"},
+		},
+		{
+			name: "group is rejected as a whole if any order is refused",
+			step: rejectedCommand,
+			want: []string{"rejected 1 groupRejected", "rejected 2 invalidSize"},
+		},
+		{
+			name: "bracket exits wait for the entry",
+			step: bracketCommand,
+			want: []string{"received 1", "received 2", "received 3", "open 1 buy 100 1"},
+		},
+		{
+			name:   "bracket exits are released as an oco when the entry is filled",
+			before: []interface{}{bracketCommand},
+			step:   limitOrder(4, 2, models.SideSell, "100", "1"),
+			want: []string{"received 4", "match 4 1 100 1", "done 1 filled 0", "done 4 filled 0", "activate 2",
+				"activate 3", "open 2 sell 110 1"},
+		},
+		{
+			name:   "bracket exits are cancelled when the entry is cancelled",
+			before: []interface{}{bracketCommand},
+			step:   cancelOrder(bracketEntry, 4),
+			want:   []string{"done 1 cancelled 1", "done 2 groupCancelled 1", "done 3 groupCancelled 1"},
+		},
+	})
+}
+
+func TestTrailingStopOrders(t *testing.T) {
+	trailBy := func(amount, rate string) func(*models.Order) {
+		return func(order *models.Order) {
+			order.TrailingAmount = dec(amount)
+			order.TrailingRate = dec(rate)
+		}
+	}
+	// the last trade price is 100
+	traded := []interface{}{
+		limitOrder(1, 1, models.SideSell, "100", "1"),
+		limitOrder(2, 2, models.SideBuy, "100", "1"),
+	}
+	trailing := marketOrder(3, 3, models.SideSell, "1", "0", stopAt(models.StopLoss, "0"), trailBy("2", "0"))
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "stop price trails the last trade price when the order is accepted",
+			before: traded,
+			step:   trailing,
+			want:   []string{"received 3", "trail 3 98"},
+		},
+		{
+			name:   "stop price trails by the rate",
+			before: traded,
+			step:   marketOrder(3, 3, models.SideSell, "1", "0", stopAt(models.StopLoss, "0"), trailBy("0", "0.05")),
+			want:   []string{"received 3", "trail 3 95"},
+		},
+		{
+			name:   "stop price of a loss order rises with the trade price",
+			before: append(traded[:2:2], trailing, limitOrder(4, 1, models.SideSell, "102", "1")),
+			step:   limitOrder(5, 2, models.SideBuy, "102", "1"),
+			want:   []string{"received 5", "match 5 4 102 1", "done 4 filled 0", "done 5 filled 0", "trail 3 100"},
+		},
+		{
+			name: "stop price of a loss order doesn't fall and the order is triggered",
+			before: append(traded[:2:2], trailing,
+				limitOrder(4, 2, models.SideBuy, "98", "1"),
+				limitOrder(5, 2, models.SideBuy, "97", "1"),
+			),
+			step: limitOrder(6, 1, models.SideSell, "98", "1"),
+			want: []string{"received 6", "match 6 4 98 1", "done 4 filled 0", "done 6 filled 0", "activate 3",
+				"match 3 5 97 1", "done 5 filled 0", "done 3 filled 0"},
+		},
+		{
+			name:   "stop price of an entry order falls with the trade price",
+			before: traded,
+			step:   limitOrder(3, 3, models.SideBuy, "110", "1", stopAt(models.StopEntry, "105"), trailBy("2", "0")),
+			want:   []string{"received 3", "trail 3 102"},
+		},
+	})
+}
+
+func TestMassCancel(t *testing.T) {
+	massCancel := func(side models.Side, lowPrice, highPrice string) *Command {
+		return &Command{
+			CommandType: CommandTypeMassCancel,
+			UserId:      1,
+			Side:        side,
+			LowPrice:    dec(lowPrice),
+			HighPrice:   dec(highPrice),
+			Time:        testTime.Add(10 * time.Second),
+		}
+	}
+	orders := []interface{}{
+		limitOrder(1, 1, models.SideBuy, "98", "1"),
+		limitOrder(2, 1, models.SideBuy, "99", "1"),
+		limitOrder(3, 1, models.SideSell, "101", "1"),
+		limitOrder(4, 2, models.SideSell, "102", "1"),
+		marketOrder(5, 1, models.SideSell, "1", "0", stopAt(models.StopLoss, "90")),
+	}
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name:   "all orders of the user are cancelled in queue order",
+			before: orders,
+			step:   massCancel("", "0", "0"),
+			want: []string{"done 2 cancelled 1", "done 1 cancelled 1", "done 3 cancelled 1",
+				"done 5 cancelled 1"},
+		},
+		{
+			name:   "only the orders on the side are cancelled",
+			before: orders,
+			step:   massCancel(models.SideSell, "0", "0"),
+			want:   []string{"done 3 cancelled 1", "done 5 cancelled 1"},
+		},
+		{
+			name:   "only the orders in the price range are cancelled, stop orders are kept",
+			before: orders,
+			step:   massCancel("", "98.5", "101"),
+			want:   []string{"done 2 cancelled 1", "done 3 cancelled 1"},
+		},
+		{
+			name:   "nothing is cancelled for a user without orders",
+			before: orders[3:4],
+			step:   massCancel("", "0", "0"),
+		},
+	})
+}
+
+func TestReceivedLogs(t *testing.T) {
+	first := limitOrder(1, 1, models.SideBuy, "100", "1")
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "received log is written before the order is put into the order book",
+			step: first,
+			want: []string{"received 1", "open 1 buy 100 1"},
+		},
+		{
+			name:   "order submitted again is rejected as a duplicate",
+			before: []interface{}{first},
+			step:   limitOrder(1, 1, models.SideBuy, "100", "1"),
+			want:   []string{"rejected 1 duplicate"},
+		},
+		{
+			name:   "duplicate leaves the original order on the book",
+			before: []interface{}{first, limitOrder(1, 1, models.SideBuy, "100", "1")},
+			step:   limitOrder(2, 2, models.SideSell, "100", "1"),
+			want:   []string{"received 2", "match 2 1 100 1", "done 1 filled 0", "done 2 filled 0"},
+		},
+	})
+}
+
+func TestRejectLogs(t *testing.T) {
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "limit order without a price is rejected",
+			step: limitOrder(1, 1, models.SideBuy, "0", "1"),
+			want: []string{"rejected 1 invalidPrice"},
+		},
+		{
+			name: "order without a size is rejected",
+			step: limitOrder(1, 1, models.SideBuy, "100", "0"),
+			want: []string{"rejected 1 invalidSize"},
+		},
+		{
+			name: "market sell without a size is rejected",
+			step: marketOrder(1, 1, models.SideSell, "0", "1000"),
+			want: []string{"rejected 1 invalidSize"},
+		},
+		{
+			name: "market buy without a size or funds is rejected",
+			step: marketOrder(1, 1, models.SideBuy, "0", "0"),
+			want: []string{"rejected 1 invalidSize"},
+		},
+		{
+			name: "market buy by funds is accepted",
+			step: marketOrder(1, 1, models.SideBuy, "0", "1000"),
+			want: []string{"received 1", "done 1 cancelled 0"},
+		},
+		{
+			name:   "order older than the dedup window is rejected",
+			before: []interface{}{limitOrder(orderIdWindowCap+2, 1, models.SideBuy, "100", "1")},
+			step:   limitOrder(1, 1, models.SideBuy, "100", "1"),
+			want:   []string{"rejected 1 expiredWindow"},
+		},
+	})
+}
+
+func TestPeggedOrders(t *testing.T) {
+	pegTo := func(peg models.PegType, offset, cap string) func(*models.Order) {
+		return func(order *models.Order) {
+			order.Peg = peg
+			order.PegOffset = dec(offset)
+			order.PegCap = dec(cap)
+		}
+	}
+	bid := limitOrder(1, 2, models.SideBuy, "100", "1")
+	book := []interface{}{bid, limitOrder(2, 2, models.SideSell, "101", "1")}
+	pegged := limitOrder(3, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeBestBid, "0", "0"))
+
+	runOrderBookCases(t, []orderBookCase{
+		{
+			name: "pegged order without a reference price is cancelled",
+			step: limitOrder(1, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeBestBid, "0", "0")),
+			want: []string{"received 1", "done 1 pegUnavailable 1"},
+		},
+		{
+			name:   "pegged order rests at the reference price",
+			before: book,
+			step:   pegged,
+			want:   []string{"received 3", "open 3 buy 100 1"},
+		},
+		{
+			name:   "mid price plus the offset is rounded away from the opposite side",
+			before: book,
+			step:   limitOrder(3, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeMid, "0.003", "0")),
+			want:   []string{"received 3", "open 3 buy 100.5 1"},
+		},
+		{
+			name:   "pegged order is kept one tick behind the best opposite order",
+			before: book,
+			step:   limitOrder(3, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeBestAsk, "0", "0")),
+			want:   []string{"received 3", "open 3 buy 100.99 1"},
+		},
+		{
+			name:   "price of a pegged buy order is limited by the cap",
+			before: book,
+			step:   limitOrder(3, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeBestBid, "0.5", "100.2")),
+			want:   []string{"received 3", "open 3 buy 100.2 1"},
+		},
+		{
+			name:   "pegged order is repriced when the top of book moves",
+			before: append(book[:2:2], pegged),
+			step:   limitOrder(4, 2, models.SideBuy, "100.5", "1"),
+			want:   []string{"received 4", "open 4 buy 100.5 1", "change 3 100->100.5 1->1"},
+		},
+		{
+			name:   "pegged order keeps its price when the reference price is gone",
+			before: append(book[:2:2], pegged),
+			step:   cancelOrder(bid, 4),
+			want:   []string{"done 1 cancelled 1"},
+		},
+		{
+			name:   "pegged order is refused in call auction",
+			before: append(book[:2:2], statusCommand(models.ProductStatusAuction, 3)),
+			step:   limitOrder(4, 1, models.SideBuy, "0", "1", pegTo(models.PegTypeBestBid, "0", "0")),
+			want:   []string{"received 4", "done 4 statusRejected 1"},
+		},
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"sort"
+)
+
+// orderGroup links the orders of an OCO or a bracket group
+type orderGroup struct {
+	GroupId   int64
+	GroupType models.OrderGroupType
+
+	// the linked orders, they are the legs of an OCO, the entry of a bracket, or the exits of a bracket
+	// after they are released
+	OrderIds []int64
+
+	// the exits of a bracket waiting for its entry to be completely filled
+	PendingOrders []*models.Order
+}
+
+// groupBook holds the groups which still link more than one live order
+type groupBook struct {
+	// groupId -> group
+	groups map[int64]*orderGroup
+
+	// orderId -> groupId
+	orderGroupIds map[int64]int64
+}
+
+func newGroupBook() *groupBook {
+	return &groupBook{
+		groups:        map[int64]*orderGroup{},
+		orderGroupIds: map[int64]int64{},
+	}
+}
+
+func (b *groupBook) add(group *orderGroup) {
+	b.groups[group.GroupId] = group
+	for _, orderId := range group.OrderIds {
+		b.orderGroupIds[orderId] = group.GroupId
+	}
+}
+
+// remove unlinks all the orders of the group
+func (b *groupBook) remove(groupId int64) {
+	group, found := b.groups[groupId]
+	if !found {
+		return
+	}
+	delete(b.groups, groupId)
+	for _, orderId := range group.OrderIds {
+		delete(b.orderGroupIds, orderId)
+	}
+}
+
+// groupOf returns the group which links the order, it returns nil if the order is not linked
+func (b *groupBook) groupOf(orderId int64) *orderGroup {
+	groupId, found := b.orderGroupIds[orderId]
+	if !found {
+		return nil
+	}
+	return b.groups[groupId]
+}
+
+// snapshot returns all groups ordered by group id
+func (b *groupBook) snapshot() []orderGroup {
+	groups := make([]orderGroup, 0, len(b.groups))
+	for _, group := range b.groups {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].GroupId < groups[j].GroupId
+	})
+	return groups
+}
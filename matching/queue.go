@@ -0,0 +1,72 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/gitbitex/gitbitex-spot/conf"
+	"github.com/gitbitex/gitbitex-spot/matching/filelog"
+)
+
+const (
+	QueueDriverKafka = "kafka"
+	QueueDriverFile  = "file"
+)
+
+// 以下方法根据queue配置创建order topic和log topic的读写对象，queue.driver为file时不依赖kafka
+
+func NewOrderReader(productId string) OrderReader {
+	gbeConfig := conf.GetConfig()
+	if gbeConfig.Queue.Driver == QueueDriverFile {
+		return NewDiskOrderReader(productId, gbeConfig.Queue.Dir)
+	}
+	return NewKafkaOrderReader(productId, gbeConfig.Kafka.Brokers)
+}
+
+func NewOrderWriter(productId string) OrderWriter {
+	gbeConfig := conf.GetConfig()
+	if gbeConfig.Queue.Driver == QueueDriverFile {
+		writer, err := NewDiskOrderWriter(productId, gbeConfig.Queue.Dir, fileLogOptions())
+		if err != nil {
+			panic(err)
+		}
+		return writer
+	}
+	return NewKafkaOrderWriter(productId, gbeConfig.Kafka.Brokers)
+}
+
+func NewLogStore(productId string) LogStore {
+	gbeConfig := conf.GetConfig()
+	if gbeConfig.Queue.Driver == QueueDriverFile {
+		logStore, err := NewDiskLogStore(productId, gbeConfig.Queue.Dir, fileLogOptions())
+		if err != nil {
+			panic(err)
+		}
+		return logStore
+	}
+	return NewKafkaLogStore(productId, gbeConfig.Kafka.Brokers)
+}
+
+func NewLogReader(readerId, productId string) LogReader {
+	gbeConfig := conf.GetConfig()
+	if gbeConfig.Queue.Driver == QueueDriverFile {
+		return NewDiskLogReader(readerId, productId, gbeConfig.Queue.Dir)
+	}
+	return NewKafkaLogReader(readerId, productId, gbeConfig.Kafka.Brokers)
+}
+
+func fileLogOptions() filelog.Options {
+	queueConfig := conf.GetConfig().Queue
+	return filelog.Options{SegmentBytes: queueConfig.SegmentBytes, Fsync: queueConfig.Fsync}
+}
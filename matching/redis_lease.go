@@ -0,0 +1,112 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/conf"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+const (
+	leaderKeyPrefix  = "matching_leader_"
+	fencingKeyPrefix = "matching_fencing_"
+)
+
+// the lease is taken with a new fencing token only when it is free
+var acquireLeaseScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('SET', KEYS[1], ARGV[1] .. ':' .. token, 'PX', ARGV[2])
+	return token
+end
+return 0
+`)
+
+// the lease is only extended by its holder
+var renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RedisLease elects the leader of the engines of a product by a lease key in redis, and the fencing tokens are
+// issued by a counter which is increased every time the lease is taken
+type RedisLease struct {
+	productId   string
+	nodeId      string
+	ttl         time.Duration
+	redisClient *redis.Client
+}
+
+func NewRedisLease(productId, nodeId string, ttl time.Duration) Lease {
+	gbeConfig := conf.GetConfig()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     gbeConfig.Redis.Addr,
+		Password: gbeConfig.Redis.Password,
+		DB:       0,
+	})
+
+	return &RedisLease{
+		productId:   productId,
+		nodeId:      nodeId,
+		ttl:         ttl,
+		redisClient: redisClient,
+	}
+}
+
+func (l *RedisLease) Acquire() (int64, error) {
+	for {
+		token, err := acquireLeaseScript.Run(l.redisClient, l.keys(), l.nodeId, l.ttl.Nanoseconds()/1e6).Int64()
+		if err != nil {
+			return 0, err
+		}
+		if token > 0 {
+			return token, nil
+		}
+		time.Sleep(l.ttl / 3)
+	}
+}
+
+// Hold renews the lease every third of the ttl, it returns when the lease is taken by others, or it can not be
+// renewed before it expires
+func (l *RedisLease) Hold(token int64) error {
+	value := fmt.Sprintf("%v:%v", l.nodeId, token)
+	deadline := time.Now().Add(l.ttl)
+
+	for {
+		time.Sleep(l.ttl / 3)
+
+		renewed, err := renewLeaseScript.Run(l.redisClient, l.keys()[:1], value, l.ttl.Nanoseconds()/1e6).Int64()
+		if err != nil {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("lease expired: %v", err)
+			}
+			continue
+		}
+		if renewed == 0 {
+			return errors.New("lease lost")
+		}
+		deadline = time.Now().Add(l.ttl)
+	}
+}
+
+func (l *RedisLease) keys() []string {
+	return []string{leaderKeyPrefix + l.productId, fencingKeyPrefix + l.productId}
+}
@@ -15,7 +15,6 @@
 package matching
 
 import (
-	"encoding/json"
 	"github.com/gitbitex/gitbitex-spot/conf"
 	"github.com/go-redis/redis"
 	"time"
@@ -46,7 +45,7 @@ func NewRedisSnapshotStore(productId string) SnapshotStore {
 }
 
 func (s *RedisSnapshotStore) Store(snapshot *Snapshot) error {
-	buf, err := json.Marshal(snapshot)
+	buf, err := marshalSnapshot(snapshot)
 	if err != nil {
 		return err
 	}
@@ -63,7 +62,5 @@ func (s *RedisSnapshotStore) GetLatest() (*Snapshot, error) {
 		return nil, err
 	}
 
-	var snapshot Snapshot
-	err = json.Unmarshal(ret, &snapshot)
-	return &snapshot, err
+	return unmarshalSnapshot(ret)
 }
@@ -0,0 +1,306 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/shopspring/decimal"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// 快照的二进制编码，格式为
+//
+//	magic(4) version(1) gzip(header length(uvarint) header(json) orderCount(uvarint) orders)
+//
+// header是除订单簿上的订单外的快照，数据量很小，用json编码以便增加字段；订单簿上的订单占快照的绝大部分，逐个字段
+// 用二进制编码。订单的字段发生变化时需要增加版本号，并且保留旧版本的解码。旧的json快照以'{'开头，仍然可以读取。
+
+const snapshotCodecVersion = 1
+
+var snapshotMagic = []byte("GBSS")
+
+func marshalSnapshot(snapshot *Snapshot) ([]byte, error) {
+	header := *snapshot
+	header.OrderBookSnapshot.Orders = nil
+	headerBuf, err := json.Marshal(&header)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic)
+	buf.WriteByte(snapshotCodecVersion)
+
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	w := &snapshotWriter{w: bufio.NewWriter(zw)}
+	w.writeBytes(headerBuf)
+	w.writeUvarint(uint64(len(snapshot.OrderBookSnapshot.Orders)))
+	for i := range snapshot.OrderBookSnapshot.Orders {
+		w.writeBookOrder(&snapshot.OrderBookSnapshot.Orders[i])
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	err = w.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalSnapshot(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+
+	// 旧的json快照
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return &snapshot, nil
+	}
+
+	if len(data) < len(snapshotMagic)+1 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return nil, errors.New("unknown snapshot format")
+	}
+	version := data[len(snapshotMagic)]
+	if version != snapshotCodecVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %v", version)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data[len(snapshotMagic)+1:]))
+	if err != nil {
+		return nil, err
+	}
+	r := &snapshotReader{r: bufio.NewReader(zr)}
+	headerBuf := r.readBytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	err = json.Unmarshal(headerBuf, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	count := r.readUvarint()
+	orders := make([]BookOrder, 0, minUint64(count, uint64(len(data))))
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		orders = append(orders, r.readBookOrder())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	snapshot.OrderBookSnapshot.Orders = orders
+
+	// 读完gzip数据以校验crc
+	_, err = io.Copy(ioutil.Discard, r.r)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// snapshotWriter writes the fields of the snapshot, the first error is kept and the later writes are ignored
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *snapshotWriter) writeBookOrder(order *BookOrder) {
+	w.writeVarint(order.OrderId)
+	w.writeVarint(order.UserId)
+	w.writeDecimal(order.Size)
+	w.writeDecimal(order.Funds)
+	w.writeDecimal(order.Price)
+	w.writeString(string(order.Side))
+	w.writeString(string(order.Type))
+	w.writeString(string(order.TimeInForce))
+	w.writeTime(order.ExpireTime)
+	w.writeVarint(order.QueueSeq)
+	w.writeDecimal(order.DisplaySize)
+	w.writeDecimal(order.VisibleSize)
+	w.writeBool(order.Hidden)
+	w.writeDecimal(order.MinQty)
+	w.writeString(string(order.Peg))
+	w.writeDecimal(order.PegOffset)
+	w.writeDecimal(order.PegCap)
+}
+
+func (w *snapshotWriter) writeUvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+func (w *snapshotWriter) writeVarint(v int64) {
+	n := binary.PutVarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+func (w *snapshotWriter) writeBytes(b []byte) {
+	w.writeUvarint(uint64(len(b)))
+	w.write(b)
+}
+
+func (w *snapshotWriter) writeString(s string) {
+	w.writeUvarint(uint64(len(s)))
+	if w.err == nil {
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *snapshotWriter) writeBool(v bool) {
+	if v {
+		w.write([]byte{1})
+	} else {
+		w.write([]byte{0})
+	}
+}
+
+func (w *snapshotWriter) writeDecimal(d decimal.Decimal) {
+	b, err := d.MarshalBinary()
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.writeBytes(b)
+}
+
+func (w *snapshotWriter) writeTime(t time.Time) {
+	b, err := t.MarshalBinary()
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.writeBytes(b)
+}
+
+func (w *snapshotWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+// snapshotReader reads the fields written by snapshotWriter, the first error is kept and the later reads return
+// zero values
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *snapshotReader) readBookOrder() BookOrder {
+	return BookOrder{
+		OrderId:     r.readVarint(),
+		UserId:      r.readVarint(),
+		Size:        r.readDecimal(),
+		Funds:       r.readDecimal(),
+		Price:       r.readDecimal(),
+		Side:        models.Side(r.readString()),
+		Type:        models.OrderType(r.readString()),
+		TimeInForce: models.TimeInForce(r.readString()),
+		ExpireTime:  r.readTime(),
+		QueueSeq:    r.readVarint(),
+		DisplaySize: r.readDecimal(),
+		VisibleSize: r.readDecimal(),
+		Hidden:      r.readBool(),
+		MinQty:      r.readDecimal(),
+		Peg:         models.PegType(r.readString()),
+		PegOffset:   r.readDecimal(),
+		PegCap:      r.readDecimal(),
+	}
+}
+
+func (r *snapshotReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(r.r)
+	return v
+}
+
+func (r *snapshotReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	var v int64
+	v, r.err = binary.ReadVarint(r.r)
+	return v
+}
+
+func (r *snapshotReader) readBytes() []byte {
+	n := r.readUvarint()
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+func (r *snapshotReader) readString() string {
+	return string(r.readBytes())
+}
+
+func (r *snapshotReader) readBool() bool {
+	if r.err != nil {
+		return false
+	}
+	var b byte
+	b, r.err = r.r.ReadByte()
+	return b == 1
+}
+
+func (r *snapshotReader) readDecimal() decimal.Decimal {
+	var d decimal.Decimal
+	b := r.readBytes()
+	if r.err == nil && len(b) < 4 {
+		r.err = errors.New("invalid decimal")
+	}
+	if r.err == nil {
+		r.err = d.UnmarshalBinary(b)
+	}
+	return d
+}
+
+func (r *snapshotReader) readTime() time.Time {
+	var t time.Time
+	b := r.readBytes()
+	if r.err == nil {
+		r.err = t.UnmarshalBinary(b)
+	}
+	return t
+}
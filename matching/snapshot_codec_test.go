@@ -0,0 +1,203 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"encoding/json"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// codecTestInputs returns the orders and the commands which leave every kind of state in the order book: an
+// iceberg order, a hidden order, a gtt order, a pegged order, an order with a min qty, a stop order in the trigger
+// book and an oco group
+func codecTestInputs() []interface{} {
+	option := func(f func(order *models.Order)) func(*models.Order) {
+		return f
+	}
+	return []interface{}{
+		// the last trade price is 100
+		limitOrder(1, 1, models.SideSell, "100", "1"),
+		limitOrder(2, 2, models.SideBuy, "100", "1"),
+
+		limitOrder(3, 1, models.SideBuy, "99", "5", option(func(order *models.Order) {
+			order.DisplaySize = dec("1")
+		})),
+		limitOrder(4, 2, models.SideBuy, "98", "1", option(func(order *models.Order) {
+			order.Hidden = true
+		})),
+		limitOrder(5, 3, models.SideSell, "108", "1", option(func(order *models.Order) {
+			order.TimeInForce = models.TimeInForceGTT
+			order.ExpireTime = testTime.Add(100 * time.Second)
+		})),
+		limitOrder(6, 3, models.SideBuy, "0", "1", option(func(order *models.Order) {
+			order.Peg = models.PegTypeBestBid
+			order.PegOffset = dec("0")
+			order.PegCap = dec("0")
+		})),
+		marketOrder(7, 4, models.SideSell, "1", "0", stopAt(models.StopLoss, "90")),
+		groupCommand(models.OrderGroupTypeOCO, 9,
+			limitOrder(8, 5, models.SideSell, "110", "1"),
+			marketOrder(9, 5, models.SideSell, "1", "0", stopAt(models.StopLoss, "95"))),
+		limitOrder(10, 6, models.SideSell, "109", "2", option(func(order *models.Order) {
+			order.MinQty = dec("1")
+		})),
+	}
+}
+
+// newCodecTestBook returns an order book with the state of codecTestInputs, which is moved into call auction by
+// the circuit breaker if tripped is true
+func newCodecTestBook(tripped bool) (*orderBook, *models.Product) {
+	product := newTestProduct()
+	product.PriceBand = dec("0.05")
+	product.BandAuctionSeconds = 10
+
+	o := NewOrderBook(product)
+	for _, input := range codecTestInputs() {
+		applyInput(o, input)
+	}
+	if tripped {
+		applyInput(o, limitOrder(11, 7, models.SideSell, "106", "1"))
+		applyInput(o, limitOrder(12, 8, models.SideBuy, "106", "1"))
+	}
+	return o, product
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+// sortedOrders sorts the orders by id, the orders of a depth are kept in a map and collected in random order
+func sortedOrders(orders []BookOrder) []BookOrder {
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].OrderId < orders[j].OrderId
+	})
+	return orders
+}
+
+func TestSnapshotCodecRoundTrip(t *testing.T) {
+	o, product := newCodecTestBook(true)
+	snapshot := &Snapshot{OrderOffset: 12, OrderBookSnapshot: o.Snapshot()}
+
+	// make sure that the snapshot has every kind of state to be encoded
+	var iceberg, hidden, pegged, minQty, gtt bool
+	for _, order := range snapshot.OrderBookSnapshot.Orders {
+		iceberg = iceberg || order.isIceberg()
+		hidden = hidden || order.Hidden
+		pegged = pegged || len(order.Peg) != 0
+		minQty = minQty || order.MinQty.IsPositive()
+		gtt = gtt || order.TimeInForce == models.TimeInForceGTT
+	}
+	if !iceberg || !hidden || !pegged || !minQty || !gtt {
+		t.Fatalf("orders of the snapshot are incomplete: %v", mustJSON(t, snapshot.OrderBookSnapshot.Orders))
+	}
+	if len(snapshot.OrderBookSnapshot.StopOrders) != 2 || len(snapshot.OrderBookSnapshot.Groups) != 1 ||
+		snapshot.OrderBookSnapshot.Status != models.ProductStatusAuction ||
+		snapshot.OrderBookSnapshot.BreakerUntil.IsZero() {
+		t.Fatalf("state of the snapshot is incomplete: %v", mustJSON(t, snapshot.OrderBookSnapshot))
+	}
+
+	// after the snapshot the auction is ended, the sell orders and the oco group are filled, and the buy orders
+	// are filled in the auction of the next trip of the circuit breaker
+	after := []interface{}{
+		clockCommand(30),
+		limitOrder(31, 9, models.SideBuy, "110", "4"),
+		limitOrder(32, 9, models.SideSell, "97", "7"),
+		clockCommand(50),
+	}
+	var want []string
+	for _, input := range after {
+		for _, log := range applyInput(o, input) {
+			want = append(want, describeLog(log))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		marshal func(*Snapshot) ([]byte, error)
+	}{
+		{
+			name:    "binary",
+			marshal: marshalSnapshot,
+		},
+		{
+			name: "legacy json",
+			marshal: func(snapshot *Snapshot) ([]byte, error) {
+				return json.Marshal(snapshot)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf, err := test.marshal(snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := unmarshalSnapshot(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mustJSON(t, decoded), mustJSON(t, snapshot); got != want {
+				t.Fatalf("snapshot mismatch\ngot:  %v\nwant: %v", got, want)
+			}
+
+			// the restored order book goes on exactly like the original one
+			restored := NewOrderBook(product)
+			restored.Restore(&decoded.OrderBookSnapshot)
+			var got []string
+			for _, input := range after {
+				for _, log := range applyInput(restored, input) {
+					got = append(got, describeLog(log))
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("logs mismatch\ngot:  %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFrozenOrdersKeepSnapshotTime(t *testing.T) {
+	o, _ := newCodecTestBook(false)
+	want := mustJSON(t, sortedOrders(o.Snapshot().Orders))
+
+	_, frozen := o.freeze()
+	// fill, refill of the iceberg order, reprice of the pegged order, amend, cancel and new orders, while the
+	// frozen orders are not copied yet
+	mutations := []interface{}{
+		marketOrder(20, 9, models.SideSell, "2", "0"),
+		amendCommand(limitOrder(5, 3, models.SideSell, "108", "1"), "0.5", "0", 21),
+		cancelOrder(limitOrder(4, 2, models.SideBuy, "98", "1"), 22),
+		limitOrder(23, 9, models.SideBuy, "99.5", "1"),
+		limitOrder(24, 9, models.SideSell, "120", "1"),
+	}
+	for _, input := range mutations {
+		applyInput(o, input)
+	}
+	if mustJSON(t, sortedOrders(o.Snapshot().Orders)) == want {
+		t.Fatal("orders of the book are not changed by the mutations")
+	}
+
+	if got := mustJSON(t, sortedOrders(frozen.copyOrders())); got != want {
+		t.Errorf("frozen orders mismatch\ngot:  %v\nwant: %v", got, want)
+	}
+}
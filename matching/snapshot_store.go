@@ -0,0 +1,99 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/conf"
+	"path/filepath"
+	"time"
+)
+
+const (
+	SnapshotStoreRedis = "redis"
+	SnapshotStoreFile  = "file"
+	SnapshotStoreMysql = "mysql"
+
+	defaultSnapshotKeep = 3
+)
+
+var errNoValidSnapshot = errors.New("no valid snapshot, delete the corrupted snapshots to replay from the beginning")
+
+// 快照的元数据，和快照一起保存，恢复之前用于校验快照的完整性
+type SnapshotMeta struct {
+	OrderOffset int64
+	LogSeq      int64
+	CreatedAt   time.Time
+	// sha256 of the encoded snapshot
+	Checksum string
+}
+
+// 根据snapshot配置创建快照的存储
+func NewSnapshotStore(productId string) SnapshotStore {
+	snapshotConfig := conf.GetConfig().Snapshot
+	keep := snapshotConfig.Keep
+	if keep <= 0 {
+		keep = defaultSnapshotKeep
+	}
+
+	switch snapshotConfig.Store {
+	case SnapshotStoreFile:
+		return NewFileSnapshotStore(filepath.Join(snapshotConfig.Dir, productId), keep)
+	case SnapshotStoreMysql:
+		return NewMysqlSnapshotStore(productId, keep)
+	default:
+		return NewRedisSnapshotStore(productId)
+	}
+}
+
+func encodeSnapshot(snapshot *Snapshot) (*SnapshotMeta, []byte, error) {
+	data, err := marshalSnapshot(snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &SnapshotMeta{
+		OrderOffset: snapshot.OrderOffset,
+		LogSeq:      snapshot.OrderBookSnapshot.LogSeq,
+		CreatedAt:   time.Now(),
+		Checksum:    snapshotChecksum(data),
+	}
+	return meta, data, nil
+}
+
+// decodeSnapshot verifies the snapshot against its meta before decoding it
+func decodeSnapshot(meta *SnapshotMeta, data []byte) (*Snapshot, error) {
+	if snapshotChecksum(data) != meta.Checksum {
+		return nil, fmt.Errorf("snapshot checksum mismatch, orderOffset=%v", meta.OrderOffset)
+	}
+
+	snapshot, err := unmarshalSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.OrderOffset != meta.OrderOffset || snapshot.OrderBookSnapshot.LogSeq != meta.LogSeq {
+		return nil, fmt.Errorf("snapshot does not match its meta, orderOffset=%v logSeq=%v",
+			meta.OrderOffset, meta.LogSeq)
+	}
+	return snapshot, nil
+}
+
+func snapshotChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
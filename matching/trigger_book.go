@@ -0,0 +1,142 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matching
+
+import (
+	"github.com/emirpasic/gods/maps/treemap"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// triggerBook holds the stop orders which are waiting for a trade price to reach their stop price
+type triggerBook struct {
+	// all stop orders
+	orders map[int64]*models.Order
+
+	// stop orders triggered when the trade price falls to or below the stop price,
+	// highest stop price first
+	// priceOrderIdKey -> orderId
+	lossQueue *treemap.Map
+
+	// stop orders triggered when the trade price rises to or above the stop price,
+	// lowest stop price first
+	// priceOrderIdKey -> orderId
+	entryQueue *treemap.Map
+
+	// trailing stop orders whose stop price moves with the trade price
+	trailingOrders map[int64]*models.Order
+}
+
+func newTriggerBook() *triggerBook {
+	return &triggerBook{
+		orders:         map[int64]*models.Order{},
+		lossQueue:      treemap.NewWith(priceOrderIdKeyDescComparator),
+		entryQueue:     treemap.NewWith(priceOrderIdKeyAscComparator),
+		trailingOrders: map[int64]*models.Order{},
+	}
+}
+
+func (b *triggerBook) add(order *models.Order) {
+	b.orders[order.Id] = order
+	b.queueOf(order.Stop).Put(&priceOrderIdKey{price: order.StopPrice, orderId: order.Id}, order.Id)
+	if isTrailing(order) {
+		b.trailingOrders[order.Id] = order
+	}
+}
+
+// remove removes the stop order from the book, it returns nil if the order is not found
+func (b *triggerBook) remove(orderId int64) *models.Order {
+	order, found := b.orders[orderId]
+	if !found {
+		return nil
+	}
+	delete(b.orders, orderId)
+	delete(b.trailingOrders, orderId)
+	b.queueOf(order.Stop).Remove(&priceOrderIdKey{price: order.StopPrice, orderId: order.Id})
+	return order
+}
+
+// move changes the stop price of the stop order in the book
+func (b *triggerBook) move(order *models.Order, stopPrice decimal.Decimal) {
+	b.queueOf(order.Stop).Remove(&priceOrderIdKey{price: order.StopPrice, orderId: order.Id})
+	order.StopPrice = stopPrice
+	b.queueOf(order.Stop).Put(&priceOrderIdKey{price: order.StopPrice, orderId: order.Id}, order.Id)
+}
+
+// ordersOf returns all the stop orders of the user ordered by order id
+func (b *triggerBook) ordersOf(userId int64) []*models.Order {
+	var orders []*models.Order
+	for _, order := range b.orders {
+		if order.UserId == userId {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Id < orders[j].Id
+	})
+	return orders
+}
+
+// trailing returns all the trailing stop orders ordered by order id
+func (b *triggerBook) trailing() []*models.Order {
+	orders := make([]*models.Order, 0, len(b.trailingOrders))
+	for _, order := range b.trailingOrders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Id < orders[j].Id
+	})
+	return orders
+}
+
+// trigger removes and returns all the stop orders triggered by trades between the low and the high price,
+// ordered by order id so that the earlier order is activated first.
+func (b *triggerBook) trigger(low, high decimal.Decimal) []*models.Order {
+	var triggered []*models.Order
+
+	for itr := b.lossQueue.Iterator(); itr.Next(); {
+		if itr.Key().(*priceOrderIdKey).price.LessThan(low) {
+			break
+		}
+		triggered = append(triggered, b.orders[itr.Value().(int64)])
+	}
+	for itr := b.entryQueue.Iterator(); itr.Next(); {
+		if itr.Key().(*priceOrderIdKey).price.GreaterThan(high) {
+			break
+		}
+		triggered = append(triggered, b.orders[itr.Value().(int64)])
+	}
+
+	for _, order := range triggered {
+		b.remove(order.Id)
+	}
+
+	sort.Slice(triggered, func(i, j int) bool {
+		return triggered[i].Id < triggered[j].Id
+	})
+	return triggered
+}
+
+func (b *triggerBook) queueOf(stop models.Stop) *treemap.Map {
+	if stop == models.StopLoss {
+		return b.lossQueue
+	}
+	return b.entryQueue
+}
+
+func isTrailing(order *models.Order) bool {
+	return order.TrailingAmount.GreaterThan(decimal.Zero) || order.TrailingRate.GreaterThan(decimal.Zero)
+}
@@ -16,7 +16,6 @@ package matching
 
 import (
 	"errors"
-	"fmt"
 )
 
 var (
@@ -116,16 +115,31 @@ func newWindow(min, max int64) Window {
 	}
 }
 
-func (w Window) put(val int64) error {
+var (
+	errExpiredVal = errors.New("expired val")
+	errExistedVal = errors.New("existed val")
+)
+
+// put adds the val into the window, it returns errExpiredVal if the val is not greater than the window, or
+// errExistedVal if the val is already in the window
+func (w *Window) put(val int64) error {
 	if val <= w.Min {
-		return errors.New(fmt.Sprintf("expired val %v, current Window [%v-%v]", val, w.Min, w.Max))
+		return errExpiredVal
 	} else if val > w.Max {
+		// the slots of the vals moved out of the window are reused by the vals moved into it
 		delta := val - w.Max
+		if delta >= w.Cap {
+			w.Bitmap = New(w.Cap)
+		} else {
+			for i := w.Max + 1; i < val; i++ {
+				w.Bitmap.Set(i%w.Cap, false)
+			}
+		}
 		w.Min += delta
 		w.Max += delta
 		w.Bitmap.Set(val%w.Cap, true)
 	} else if w.Bitmap.Get(val % w.Cap) {
-		return errors.New(fmt.Sprintf("existed val %v", val))
+		return errExistedVal
 	} else {
 		w.Bitmap.Set(val%w.Cap, true)
 	}
@@ -52,6 +52,122 @@ func (t OrderType) String() string {
 	return string(t)
 }
 
+// 订单的有效方式
+type TimeInForce string
+
+func NewTimeInForceFromString(s string) (*TimeInForce, error) {
+	timeInForce := TimeInForce(s)
+	switch timeInForce {
+	case TimeInForceGTC:
+	case TimeInForceGTT:
+	case TimeInForceIOC:
+	case TimeInForceFOK:
+	default:
+		return nil, fmt.Errorf("invalid time in force: %v", s)
+	}
+	return &timeInForce, nil
+}
+
+func (t TimeInForce) String() string {
+	return string(t)
+}
+
+// 止损/止盈单的触发方向
+type Stop string
+
+func NewStopFromString(s string) (*Stop, error) {
+	stop := Stop(s)
+	switch stop {
+	case StopLoss:
+	case StopEntry:
+	default:
+		return nil, fmt.Errorf("invalid stop: %v", s)
+	}
+	return &stop, nil
+}
+
+func (s Stop) String() string {
+	return string(s)
+}
+
+// 自成交保护方式，同一个用户的taker和maker相遇时，由taker的方式决定如何处理
+type SelfTradePrevention string
+
+func NewSelfTradePreventionFromString(s string) (*SelfTradePrevention, error) {
+	stp := SelfTradePrevention(s)
+	switch stp {
+	case SelfTradePreventionDecrementAndCancel:
+	case SelfTradePreventionCancelOldest:
+	case SelfTradePreventionCancelNewest:
+	case SelfTradePreventionCancelBoth:
+	default:
+		return nil, fmt.Errorf("invalid self trade prevention: %v", s)
+	}
+	return &stp, nil
+}
+
+func (s SelfTradePrevention) String() string {
+	return string(s)
+}
+
+// 挂钩订单的参考价格，撮合引擎在盘口变化时按参考价格重新定价
+type PegType string
+
+func NewPegTypeFromString(s string) (*PegType, error) {
+	peg := PegType(s)
+	switch peg {
+	case PegTypeBestBid:
+	case PegTypeBestAsk:
+	case PegTypeMid:
+	default:
+		return nil, fmt.Errorf("invalid peg: %v", s)
+	}
+	return &peg, nil
+}
+
+func (p PegType) String() string {
+	return string(p)
+}
+
+// 订单组的类型，同一组的订单由撮合引擎联动撤销
+type OrderGroupType string
+
+func NewOrderGroupTypeFromString(s string) (*OrderGroupType, error) {
+	groupType := OrderGroupType(s)
+	switch groupType {
+	case OrderGroupTypeOCO:
+	case OrderGroupTypeBracket:
+	default:
+		return nil, fmt.Errorf("invalid order group type: %v", s)
+	}
+	return &groupType, nil
+}
+
+func (t OrderGroupType) String() string {
+	return string(t)
+}
+
+// 产品的交易状态，决定撮合引擎接受哪些订单
+type ProductStatus string
+
+func NewProductStatusFromString(s string) (*ProductStatus, error) {
+	status := ProductStatus(s)
+	switch status {
+	case ProductStatusOpen:
+	case ProductStatusHalted:
+	case ProductStatusCancelOnly:
+	case ProductStatusPostOnly:
+	case ProductStatusAuction:
+	default:
+		return nil, fmt.Errorf("invalid product status: %v", s)
+	}
+	return &status, nil
+}
+
+func (s ProductStatus) String() string {
+	return string(s)
+}
+
 // 用于表示订单状态
 type OrderStatus string
 
@@ -59,10 +175,13 @@ func NewOrderStatusFromString(s string) (*OrderStatus, error) {
 	status := OrderStatus(s)
 	switch status {
 	case OrderStatusNew:
+	case OrderStatusUntriggered:
+	case OrderStatusPending:
 	case OrderStatusOpen:
 	case OrderStatusCancelling:
 	case OrderStatusCancelled:
 	case OrderStatusFilled:
+	case OrderStatusRejected:
 	default:
 		return nil, fmt.Errorf("invalid status: %v", s)
 	}
@@ -81,6 +200,9 @@ type DoneReason string
 
 type TransactionStatus string
 
+// 用于表示触发批量撤单的原因
+type CancelTriggerType string
+
 const (
 	OrderTypeLimit  = OrderType("limit")
 	OrderTypeMarket = OrderType("market")
@@ -88,8 +210,58 @@ const (
 	SideBuy  = Side("buy")
 	SideSell = Side("sell")
 
+	// 一直有效直到被取消
+	TimeInForceGTC = TimeInForce("GTC")
+	// 有效期至指定的时间，到期后自动取消
+	TimeInForceGTT = TimeInForce("GTT")
+	// 立即成交，未成交的部分立即取消
+	TimeInForceIOC = TimeInForce("IOC")
+	// 全部成交，否则整个订单被拒绝
+	TimeInForceFOK = TimeInForce("FOK")
+
+	// 成交价格下跌到stop price或者更低时触发
+	StopLoss = Stop("loss")
+	// 成交价格上涨到stop price或者更高时触发
+	StopEntry = Stop("entry")
+
+	// 较小的订单被取消，较大的订单减去较小订单的数量，数量相等时两个订单都被取消
+	SelfTradePreventionDecrementAndCancel = SelfTradePrevention("dc")
+	// 取消maker（旧的订单）
+	SelfTradePreventionCancelOldest = SelfTradePrevention("co")
+	// 取消taker（新的订单）
+	SelfTradePreventionCancelNewest = SelfTradePrevention("cn")
+	// 同时取消taker和maker
+	SelfTradePreventionCancelBoth = SelfTradePrevention("cb")
+
+	// 挂钩买一价
+	PegTypeBestBid = PegType("bestBid")
+	// 挂钩卖一价
+	PegTypeBestAsk = PegType("bestAsk")
+	// 挂钩买一价和卖一价的中间价
+	PegTypeMid = PegType("mid")
+
+	// 一个止盈限价单和一个止损单，其中一个成交、被触发或者结束时，另一个被取消
+	OrderGroupTypeOCO = OrderGroupType("oco")
+	// 一个买入的入场单，完全成交后其止盈限价单和止损单作为OCO生效，入场单没有完全成交则两者都被取消
+	OrderGroupTypeBracket = OrderGroupType("bracket")
+
+	// 正常连续撮合
+	ProductStatusOpen = ProductStatus("open")
+	// 暂停交易，不接受下单，已有的订单仍然可以撤单和改单
+	ProductStatusHalted = ProductStatus("halted")
+	// 只接受撤单和改单
+	ProductStatusCancelOnly = ProductStatus("cancelOnly")
+	// 只接受不会立即成交的限价单，以及撤单和改单
+	ProductStatusPostOnly = ProductStatus("postOnly")
+	// 集合竞价，收集限价单但不撮合，切换为open时按照均衡价格一次性撮合
+	ProductStatusAuction = ProductStatus("auction")
+
 	// 初始状态
 	OrderStatusNew = OrderStatus("new")
+	// 止损/止盈单等待触发，触发后变为new
+	OrderStatusUntriggered = OrderStatus("untriggered")
+	// bracket的止盈/止损单等待入场单完全成交，生效后变为new或者untriggered
+	OrderStatusPending = OrderStatus("pending")
 	// 已经加入orderBook
 	OrderStatusOpen = OrderStatus("open")
 	// 中间状态，请求取消订单
@@ -98,11 +270,56 @@ const (
 	OrderStatusCancelled = OrderStatus("cancelled")
 	// 订单完全成交
 	OrderStatusFilled = OrderStatus("filled")
+	// 订单被撮合引擎拒绝，从未进入orderBook
+	OrderStatusRejected = OrderStatus("rejected")
 
 	BillTypeTrade = BillType("trade")
 
 	DoneReasonFilled    = DoneReason("filled")
 	DoneReasonCancelled = DoneReason("cancelled")
+	// GTT订单到期
+	DoneReasonExpired = DoneReason("expired")
+	// IOC订单未能立即成交的部分被取消
+	DoneReasonIOCCancelled = DoneReason("iocCancelled")
+	// FOK订单无法全部成交，整个订单被拒绝
+	DoneReasonFOKRejected = DoneReason("fokRejected")
+	// 订单到达时能够立即成交的数量小于最小成交数量，整个订单被取消
+	DoneReasonMinQtyRejected = DoneReason("minQtyRejected")
+	// post only订单会立即成交，被拒绝
+	DoneReasonPostOnlyRejected = DoneReason("postOnlyRejected")
+	// 自成交保护取消了订单
+	DoneReasonSelfTradePrevented = DoneReason("selfTradePrevented")
+	// 市价单的成交价格到达保护价格，剩余部分被取消
+	DoneReasonPriceProtected = DoneReason("priceProtected")
+	// 产品当前的交易状态不接受该订单
+	DoneReasonStatusRejected = DoneReason("statusRejected")
+	// 成交价格超出价格区间触发熔断，剩余部分被取消
+	DoneReasonCircuitBreaker = DoneReason("circuitBreaker")
+	// 同一订单组的其他订单成交、被触发或者结束，该订单被取消
+	DoneReasonGroupCancelled = DoneReason("groupCancelled")
+	// 挂钩订单到达时没有参考价格，订单被取消
+	DoneReasonPegUnavailable = DoneReason("pegUnavailable")
+
+	// 以下是撮合引擎拒绝订单的原因
+	// 订单重复提交，第一次提交的订单已经被处理
+	DoneReasonDuplicate = DoneReason("duplicate")
+	// 订单id落后于去重窗口，订单到达得太晚
+	DoneReasonExpiredWindow = DoneReason("expiredWindow")
+	// 产品暂停交易
+	DoneReasonProductHalted = DoneReason("productHalted")
+	// 限价单的价格无效
+	DoneReasonInvalidPrice = DoneReason("invalidPrice")
+	// 订单的数量或者金额无效
+	DoneReasonInvalidSize = DoneReason("invalidSize")
+	// 订单组中的其他订单被拒绝，整个订单组被拒绝
+	DoneReasonGroupRejected = DoneReason("groupRejected")
+
+	// websocket连接关闭
+	CancelTriggerDisconnect = CancelTriggerType("disconnect")
+	// websocket连接的心跳超时
+	CancelTriggerHeartbeatTimeout = CancelTriggerType("heartbeatTimeout")
+	// cancelAllAfter的倒计时到期
+	CancelTriggerCancelAllAfter = CancelTriggerType("cancelAllAfter")
 
 	TransactionStatusPending   = TransactionStatus("pending")
 	TransactionStatusCompleted = TransactionStatus("completed")
@@ -153,6 +370,13 @@ type Product struct {
 	BaseScale      int32
 	QuoteScale     int32
 	QuoteIncrement float64
+	// 按数量市价买入时，未指定保护价格则使用最新成交价上浮MaxSlippage作为保护价格
+	MaxSlippage decimal.Decimal `sql:"type:decimal(32,16);"`
+	Status      ProductStatus
+	// 成交价格偏离参考价格(最新成交价)超过PriceBand比例时触发熔断，为0时不熔断
+	PriceBand decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 熔断后进入集合竞价的秒数，为0时熔断后暂停交易
+	BandAuctionSeconds int64
 }
 
 type Order struct {
@@ -170,9 +394,35 @@ type Order struct {
 	FillFees      decimal.Decimal `sql:"type:decimal(32,16);"`
 	Type          OrderType
 	Side          Side
-	TimeInForce   string
-	Status        OrderStatus
-	Settled       bool
+	TimeInForce   TimeInForce
+	ExpireTime    time.Time
+	PostOnly      bool
+	PostOnlySlide bool
+	Stop          Stop
+	StopPrice     decimal.Decimal `sql:"type:decimal(32,16);"`
+	DisplaySize   decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 跟踪止损/止盈单与成交价格的固定价差或者比例(如0.05)，二者最多设置一个，StopPrice随成交价格移动
+	TrailingAmount decimal.Decimal `sql:"type:decimal(32,16);"`
+	TrailingRate   decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 隐藏订单不在orderBook中展示，同一价格上排在展示的订单之后成交
+	Hidden bool
+	// 订单作为taker到达时至少要立即成交的数量，为0表示不限制
+	MinQty decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 挂钩订单的参考价格，Price是撮合引擎按参考价格加上PegOffset计算的当前价格，
+	// PegCap是买单的最高价格、卖单的最低价格，为0表示不限制
+	Peg       PegType
+	PegOffset decimal.Decimal `sql:"type:decimal(32,16);"`
+	PegCap    decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 市价单的保护价格，买单不会高于、卖单不会低于该价格成交，为0表示不保护
+	ProtectionPrice decimal.Decimal `sql:"type:decimal(32,16);"`
+	// 自成交保护方式，为空表示不做自成交保护
+	SelfTradePrevention SelfTradePrevention
+	// 订单组的id，即组内第一个订单的id，为0表示不属于任何订单组
+	GroupId    int64
+	GroupType  OrderGroupType
+	Status     OrderStatus
+	DoneReason DoneReason
+	Settled    bool
 }
 
 type Fill struct {
@@ -192,8 +442,10 @@ type Fill struct {
 	Side       Side
 	Done       bool
 	DoneReason DoneReason
-	LogOffset  int64
-	LogSeq     int64
+	// 订单在orderBook中的数量或者价格被修改，Size为减少的数量，Price为新的价格
+	Changed   bool
+	LogOffset int64
+	LogSeq    int64
 }
 
 type Trade struct {
@@ -235,6 +487,54 @@ type Config struct {
 	Value     string
 }
 
+// 批量撤单的审计记录，如websocket连接断开时撤销用户在指定产品上的所有订单
+type CancelTrigger struct {
+	Id        int64 `gorm:"column:id;primary_key;AUTO_INCREMENT"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserId    int64
+	ProductId string
+	Type      CancelTriggerType
+	// 触发撤单的服务器和连接，多个服务器同时运行时用于区分
+	Source string
+}
+
+// 用户撤销所有订单的倒计时，到期前没有刷新则撤销用户的所有订单
+type CancelTimer struct {
+	Id        int64 `gorm:"column:id;primary_key;AUTO_INCREMENT"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserId    int64 `gorm:"unique_index:idx_uid"`
+	// 到期时间，unix毫秒
+	CancelTime int64
+	// 到期后被rest server认领，在该时间(unix毫秒)之前完成撤单，没有完成的倒计时可以被重新认领
+	ClaimedUntil int64
+}
+
+// 撮合引擎的快照，每个产品保留最近的若干个，用于引擎重启时恢复
+type EngineSnapshot struct {
+	Id          int64 `gorm:"column:id;primary_key;AUTO_INCREMENT"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ProductId   string `gorm:"index:idx_pid"`
+	OrderOffset int64
+	LogSeq      int64
+	// 快照数据的sha256，恢复前校验
+	Checksum string
+	Data     []byte `sql:"type:longblob"`
+}
+
+// 产品分配给的撮合节点，NodeId是应该运行该产品撮合引擎的节点，RunningNodeId是正在运行的节点。重新分配时先修改
+// NodeId，原节点保存快照并停止引擎后清空RunningNodeId，新节点再将RunningNodeId改为自己并启动引擎
+type ProductAssignment struct {
+	Id            int64 `gorm:"column:id;primary_key;AUTO_INCREMENT"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	ProductId     string `gorm:"unique_index:idx_pid"`
+	NodeId        string
+	RunningNodeId string
+}
+
 type Transaction struct {
 	Id          int64 `gorm:"column:id;primary_key;AUTO_INCREMENT"`
 	CreatedAt   time.Time
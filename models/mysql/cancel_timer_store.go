@@ -0,0 +1,60 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"time"
+)
+
+func (s *Store) SetCancelTimer(userId int64, cancelTime int64) error {
+	now := time.Now()
+	return s.db.Exec("INSERT INTO g_cancel_timer(created_at,updated_at,user_id,cancel_time,claimed_until) "+
+		"VALUES(?,?,?,?,0) ON DUPLICATE KEY UPDATE cancel_time=VALUES(cancel_time),claimed_until=0,"+
+		"updated_at=VALUES(updated_at)", now, now, userId, cancelTime).Error
+}
+
+func (s *Store) DeleteCancelTimer(userId int64) error {
+	return s.db.Exec("DELETE FROM g_cancel_timer WHERE user_id=?", userId).Error
+}
+
+func (s *Store) GetExpiredCancelTimers(now int64, limit int) ([]*models.CancelTimer, error) {
+	var timers []*models.CancelTimer
+	err := s.db.Where("cancel_time<=? AND claimed_until<=?", now, now).Order("cancel_time ASC").Limit(limit).
+		Find(&timers).Error
+	return timers, err
+}
+
+// ClaimCancelTimer claims the timer until claimedUntil if it is not refreshed and not claimed by others, only one
+// of the callers racing for the same timer gets true
+func (s *Store) ClaimCancelTimer(userId int64, cancelTime int64, now int64, claimedUntil int64) (bool, error) {
+	ret := s.db.Exec("UPDATE g_cancel_timer SET claimed_until=?,updated_at=? WHERE user_id=? AND cancel_time=? "+
+		"AND claimed_until<=?", claimedUntil, time.Now(), userId, cancelTime, now)
+	if ret.Error != nil {
+		return false, ret.Error
+	}
+	return ret.RowsAffected > 0, nil
+}
+
+// ReleaseCancelTimer gives up the claim of the timer, so that it is claimed again at once
+func (s *Store) ReleaseCancelTimer(userId int64, cancelTime int64) error {
+	return s.db.Exec("UPDATE g_cancel_timer SET claimed_until=0,updated_at=? WHERE user_id=? AND cancel_time=?",
+		time.Now(), userId, cancelTime).Error
+}
+
+// CompleteCancelTimer deletes the timer which has fired, unless it is refreshed in the meantime
+func (s *Store) CompleteCancelTimer(userId int64, cancelTime int64) error {
+	return s.db.Exec("DELETE FROM g_cancel_timer WHERE user_id=? AND cancel_time=?", userId, cancelTime).Error
+}
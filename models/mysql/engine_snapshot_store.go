@@ -0,0 +1,31 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import "github.com/gitbitex/gitbitex-spot/models"
+
+func (s *Store) AddEngineSnapshot(snapshot *models.EngineSnapshot) error {
+	return s.db.Create(snapshot).Error
+}
+
+func (s *Store) GetEngineSnapshotsByProductId(productId string, limit int) ([]*models.EngineSnapshot, error) {
+	var snapshots []*models.EngineSnapshot
+	err := s.db.Where("product_id =?", productId).Order("id DESC").Limit(limit).Find(&snapshots).Error
+	return snapshots, err
+}
+
+func (s *Store) DeleteEngineSnapshotsBeforeId(productId string, id int64) error {
+	return s.db.Where("product_id =?", productId).Where("id <?", id).Delete(&models.EngineSnapshot{}).Error
+}
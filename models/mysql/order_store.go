@@ -17,6 +17,7 @@ package mysql
 import (
 	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
 	"time"
 )
 
@@ -99,3 +100,22 @@ func (s *Store) UpdateOrderStatus(orderId int64, oldStatus, newStatus models.Ord
 	}
 	return ret.RowsAffected > 0, nil
 }
+
+func (s *Store) UpdateOrderStatusAndPrice(orderId int64, oldStatus, newStatus models.OrderStatus,
+	price decimal.Decimal) (bool, error) {
+	ret := s.db.Exec("UPDATE g_order SET `status`=?,price=?,updated_at=? WHERE id=? AND `status`=? ",
+		newStatus, price, time.Now(), orderId, oldStatus)
+	if ret.Error != nil {
+		return false, ret.Error
+	}
+	return ret.RowsAffected > 0, nil
+}
+
+func (s *Store) UpdateOrderStopPrice(orderId int64, status models.OrderStatus, stopPrice decimal.Decimal) (bool, error) {
+	ret := s.db.Exec("UPDATE g_order SET stop_price=?,updated_at=? WHERE id=? AND `status`=? ",
+		stopPrice, time.Now(), orderId, status)
+	if ret.Error != nil {
+		return false, ret.Error
+	}
+	return ret.RowsAffected > 0, nil
+}
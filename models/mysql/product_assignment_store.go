@@ -0,0 +1,55 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+func (s *Store) GetProductAssignments() ([]*models.ProductAssignment, error) {
+	var assignments []*models.ProductAssignment
+	err := s.db.Find(&assignments).Error
+	return assignments, err
+}
+
+func (s *Store) GetProductAssignmentByProductId(productId string) (*models.ProductAssignment, error) {
+	var assignment models.ProductAssignment
+	err := s.db.Raw("SELECT * FROM g_product_assignment WHERE product_id=?", productId).Scan(&assignment).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return &assignment, err
+}
+
+func (s *Store) SetProductAssignmentNode(productId string, nodeId string) error {
+	now := time.Now()
+	return s.db.Exec("INSERT INTO g_product_assignment(created_at,updated_at,product_id,node_id,running_node_id) "+
+		"VALUES(?,?,?,?,'') ON DUPLICATE KEY UPDATE node_id=VALUES(node_id),updated_at=VALUES(updated_at)",
+		now, now, productId, nodeId).Error
+}
+
+// UpdateProductAssignmentRunningNode changes the running node only if it is still the old one, only one of the
+// nodes racing for the same product gets true
+func (s *Store) UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId, runningNodeId string) (bool,
+	error) {
+	ret := s.db.Exec("UPDATE g_product_assignment SET running_node_id=?,updated_at=? WHERE product_id=? AND "+
+		"running_node_id=?", runningNodeId, time.Now(), productId, oldRunningNodeId)
+	if ret.Error != nil {
+		return false, ret.Error
+	}
+	return ret.RowsAffected > 0, nil
+}
@@ -17,6 +17,7 @@ package mysql
 import (
 	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/jinzhu/gorm"
+	"time"
 )
 
 func (s *Store) GetProductById(id string) (*models.Product, error) {
@@ -33,3 +34,7 @@ func (s *Store) GetProducts() ([]*models.Product, error) {
 	err := s.db.Find(&products).Error
 	return products, err
 }
+
+func (s *Store) UpdateProductStatus(productId string, status models.ProductStatus) error {
+	return s.db.Exec("UPDATE g_product SET status=?,updated_at=? WHERE id=?", status, time.Now(), productId).Error
+}
@@ -79,6 +79,10 @@ func initDb() error {
 			&models.Bill{},
 			&models.Tick{},
 			&models.Config{},
+			&models.CancelTrigger{},
+			&models.CancelTimer{},
+			&models.EngineSnapshot{},
+			&models.ProductAssignment{},
 		}
 		for _, table := range tables {
 			log.Infof("migrating database, table: %v", reflect.TypeOf(table))
@@ -14,6 +14,8 @@
 
 package models
 
+import "github.com/shopspring/decimal"
+
 type Store interface {
 	BeginTx() (Store, error)
 	Rollback() error
@@ -38,6 +40,7 @@ type Store interface {
 
 	GetProductById(id string) (*Product, error)
 	GetProducts() ([]*Product, error)
+	UpdateProductStatus(productId string, status ProductStatus) error
 
 	GetOrderById(orderId int64) (*Order, error)
 	GetOrderByClientOid(userId int64, clientOid string) (*Order, error)
@@ -47,6 +50,8 @@ type Store interface {
 	AddOrder(order *Order) error
 	UpdateOrder(order *Order) error
 	UpdateOrderStatus(orderId int64, oldStatus, newStatus OrderStatus) (bool, error)
+	UpdateOrderStatusAndPrice(orderId int64, oldStatus, newStatus OrderStatus, price decimal.Decimal) (bool, error)
+	UpdateOrderStopPrice(orderId int64, status OrderStatus, stopPrice decimal.Decimal) (bool, error)
 
 	GetLastFillByProductId(productId string) (*Fill, error)
 	GetUnsettledFillsByOrderId(orderId int64) ([]*Fill, error)
@@ -61,4 +66,22 @@ type Store interface {
 	GetTicksByProductId(productId string, granularity int64, limit int) ([]*Tick, error)
 	GetLastTickByProductId(productId string, granularity int64) (*Tick, error)
 	AddTicks(ticks []*Tick) error
+
+	AddCancelTrigger(trigger *CancelTrigger) error
+
+	SetCancelTimer(userId int64, cancelTime int64) error
+	DeleteCancelTimer(userId int64) error
+	GetExpiredCancelTimers(now int64, limit int) ([]*CancelTimer, error)
+	ClaimCancelTimer(userId int64, cancelTime int64, now int64, claimedUntil int64) (bool, error)
+	ReleaseCancelTimer(userId int64, cancelTime int64) error
+	CompleteCancelTimer(userId int64, cancelTime int64) error
+
+	AddEngineSnapshot(snapshot *EngineSnapshot) error
+	GetEngineSnapshotsByProductId(productId string, limit int) ([]*EngineSnapshot, error)
+	DeleteEngineSnapshotsBeforeId(productId string, id int64) error
+
+	GetProductAssignments() ([]*ProductAssignment, error)
+	GetProductAssignmentByProductId(productId string) (*ProductAssignment, error)
+	SetProductAssignmentNode(productId string, nodeId string) error
+	UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId, runningNodeId string) (bool, error)
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/gitbitex/gitbitex-spot/matching"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/siddontang/go-log/log"
+	"os"
 )
 
 func StartServer() {
@@ -33,12 +34,21 @@ func StartServer() {
 		panic(err)
 	}
 	for _, product := range products {
-		newTickerStream(product.Id, sub, matching.NewKafkaLogReader("tickerStream", product.Id, gbeConfig.Kafka.Brokers)).Start()
-		newMatchStream(product.Id, sub, matching.NewKafkaLogReader("matchStream", product.Id, gbeConfig.Kafka.Brokers)).Start()
-		newOrderBookStream(product.Id, sub, matching.NewKafkaLogReader("orderBookStream", product.Id, gbeConfig.Kafka.Brokers)).Start()
+		newTickerStream(product.Id, sub, matching.NewLogReader("tickerStream", product.Id)).Start()
+		newMatchStream(product.Id, sub, matching.NewLogReader("matchStream", product.Id)).Start()
+		newOrderBookStream(product.Id, sub, matching.NewLogReader("orderBookStream", product.Id)).Start()
+		newFullStream(product.Id, sub, matching.NewLogReader("fullStream", product.Id)).Start()
+		newStatusStream(product, sub, matching.NewLogReader("statusStream", product.Id)).Start()
 	}
 
-	go NewServer(gbeConfig.PushServer.Addr, gbeConfig.PushServer.Path, sub).Run()
+	// push servers running side by side are told apart by the host name and the listening address
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+	canceller := newOrderCanceller(hostname + gbeConfig.PushServer.Addr)
+
+	go NewServer(gbeConfig.PushServer.Addr, gbeConfig.PushServer.Path, sub, canceller).Run()
 
 	log.Info("websocket server ok")
 }
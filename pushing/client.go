@@ -17,9 +17,11 @@ package pushing
 import (
 	"context"
 	"encoding/json"
+	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/gorilla/websocket"
 	"github.com/siddontang/go-log/log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -43,16 +45,25 @@ type Client struct {
 	sub        *subscription
 	channels   map[string]struct{}
 	mu         sync.Mutex
+
+	// all the live orders of the user on these products are cancelled when the client is closed, including the
+	// orders placed from other connections or through the REST API
+	canceller          massCanceller
+	userId             int64
+	cancelOnDisconnect map[string]struct{}
+	closed             bool
 }
 
-func NewClient(conn *websocket.Conn, sub *subscription) *Client {
+func NewClient(conn *websocket.Conn, sub *subscription, canceller massCanceller) *Client {
 	return &Client{
-		id:         atomic.AddInt64(&id, 1),
-		conn:       conn,
-		writeCh:    make(chan interface{}, 256),
-		l2ChangeCh: make(chan *Level2Change, 512),
-		sub:        sub,
-		channels:   map[string]struct{}{},
+		id:                 atomic.AddInt64(&id, 1),
+		conn:               conn,
+		writeCh:            make(chan interface{}, 256),
+		l2ChangeCh:         make(chan *Level2Change, 512),
+		sub:                sub,
+		channels:           map[string]struct{}{},
+		canceller:          canceller,
+		cancelOnDisconnect: map[string]struct{}{},
 	}
 }
 
@@ -73,7 +84,12 @@ func (c *Client) runReader() {
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			c.close()
+			// no pong is received before the read deadline
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				c.close(models.CancelTriggerHeartbeatTimeout)
+			} else {
+				c.close(models.CancelTriggerDisconnect)
+			}
 			break
 		}
 
@@ -81,7 +97,7 @@ func (c *Client) runReader() {
 		err = json.Unmarshal(message, &req)
 		if err != nil {
 			log.Errorf("bad message : %v %v", string(message), err)
-			c.close()
+			c.close(models.CancelTriggerDisconnect)
 			break
 		}
 
@@ -113,7 +129,7 @@ func (c *Client) runWriter() {
 			err := c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err != nil {
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				c.close()
+				c.close(models.CancelTriggerDisconnect)
 				return
 			}
 
@@ -123,7 +139,7 @@ func (c *Client) runWriter() {
 			}
 			err = c.conn.WriteMessage(websocket.TextMessage, buf)
 			if err != nil {
-				c.close()
+				c.close(models.CancelTriggerDisconnect)
 				return
 			}
 
@@ -131,7 +147,7 @@ func (c *Client) runWriter() {
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			err := c.conn.WriteMessage(websocket.PingMessage, nil)
 			if err != nil {
-				c.close()
+				c.close(models.CancelTriggerDisconnect)
 				return
 			}
 		}
@@ -238,6 +254,8 @@ func (c *Client) onMessage(req *Request) {
 		c.onSub(req.CurrencyIds, req.ProductIds, req.Channels, req.Token)
 	case "unsubscribe":
 		c.onUnSub(req.CurrencyIds, req.ProductIds, req.Channels, req.Token)
+	case "cancelOnDisconnect":
+		c.onCancelOnDisconnect(req.ProductIds, req.Token)
 	default:
 	}
 }
@@ -275,6 +293,9 @@ func (c *Client) onSub(currencyIds []string, productIds []string, channels []str
 			case ChannelMatch:
 				c.subscribe(ChannelMatch.FormatWithProductId(productId))
 
+			case ChannelFull:
+				c.subscribe(ChannelFull.FormatWithProductId(productId))
+
 			case ChannelTicker:
 				if c.subscribe(ChannelTicker.FormatWithProductId(productId)) {
 					ticker := getLastTicker(productId)
@@ -286,6 +307,22 @@ func (c *Client) onSub(currencyIds []string, productIds []string, channels []str
 			case ChannelOrder:
 				c.subscribe(ChannelOrder.Format(productId, userId))
 
+			case ChannelStatus:
+				if c.subscribe(ChannelStatus.FormatWithProductId(productId)) {
+					status := getLastStatus(productId)
+					if status != nil {
+						c.writeCh <- status
+					}
+				}
+
+			case ChannelAuction:
+				if c.subscribe(ChannelAuction.FormatWithProductId(productId)) {
+					auction := getLastAuction(productId)
+					if auction != nil {
+						c.writeCh <- auction
+					}
+				}
+
 			default:
 				continue
 			}
@@ -322,12 +359,21 @@ func (c *Client) onUnSub(currencyIds []string, productIds []string, channels []s
 			case ChannelMatch:
 				c.unsubscribe(ChannelMatch.FormatWithProductId(productId))
 
+			case ChannelFull:
+				c.unsubscribe(ChannelFull.FormatWithProductId(productId))
+
 			case ChannelTicker:
 				c.unsubscribe(ChannelTicker.FormatWithProductId(productId))
 
 			case ChannelOrder:
 				c.unsubscribe(ChannelOrder.Format(productId, userId))
 
+			case ChannelStatus:
+				c.unsubscribe(ChannelStatus.FormatWithProductId(productId))
+
+			case ChannelAuction:
+				c.unsubscribe(ChannelAuction.FormatWithProductId(productId))
+
 			default:
 				continue
 			}
@@ -335,6 +381,43 @@ func (c *Client) onUnSub(currencyIds []string, productIds []string, channels []s
 	}
 }
 
+// onCancelOnDisconnect replaces the products whose orders are cancelled when the client is closed or its heartbeat
+// times out, an empty product list turns the cancel-on-disconnect off. It requires an authenticated user. The
+// cancel is per user: all the orders of the user on the products are cancelled, not only the orders placed while
+// the connection is open, since the orders don't record the connection they are placed from.
+func (c *Client) onCancelOnDisconnect(productIds []string, token string) {
+	user, err := service.CheckToken(token)
+	if err != nil {
+		log.Error(err)
+	}
+	if user == nil {
+		c.writeCh <- &CancelOnDisconnectMessage{
+			Type:       "cancelOnDisconnect",
+			ProductIds: []string{},
+			Message:    "authentication required",
+		}
+		return
+	}
+
+	c.mu.Lock()
+	c.userId = user.Id
+	c.cancelOnDisconnect = map[string]struct{}{}
+	for _, productId := range productIds {
+		c.cancelOnDisconnect[productId] = struct{}{}
+	}
+	c.mu.Unlock()
+
+	var message string
+	if len(productIds) != 0 {
+		message = "all the orders of the user on the products are cancelled when the connection is closed"
+	}
+	c.writeCh <- &CancelOnDisconnectMessage{
+		Type:       "cancelOnDisconnect",
+		ProductIds: productIds,
+		Message:    message,
+	}
+}
+
 func (c *Client) subscribe(channel string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -360,11 +443,21 @@ func (c *Client) unsubscribe(channel string) {
 	}
 }
 
-func (c *Client) close() {
+func (c *Client) close(triggerType models.CancelTriggerType) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// the reader and the writer may both close the client
+	if c.closed {
+		return
+	}
+	c.closed = true
+
 	for channel := range c.channels {
 		c.sub.unsubscribe(channel, c)
 	}
+
+	for productId := range c.cancelOnDisconnect {
+		go c.canceller.cancelOrders(c.userId, productId, triggerType, c.id)
+	}
 }
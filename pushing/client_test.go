@@ -0,0 +1,88 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeCanceller struct {
+	mu      sync.Mutex
+	cancels []string
+	done    chan struct{}
+}
+
+func (c *fakeCanceller) cancelOrders(userId int64, productId string, triggerType models.CancelTriggerType,
+	clientId int64) {
+	c.mu.Lock()
+	c.cancels = append(c.cancels, fmt.Sprintf("%v %v %v", userId, productId, triggerType))
+	c.mu.Unlock()
+	c.done <- struct{}{}
+}
+
+func TestClientCancelOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		productIds []string
+		want       []string
+	}{
+		{
+			name:       "all the orders of the user are cancelled once per product",
+			productIds: []string{"BTC-USDT", "ETH-USDT"},
+			want:       []string{"7 BTC-USDT disconnect", "7 ETH-USDT disconnect"},
+		},
+		{
+			name: "nothing is cancelled without cancel-on-disconnect",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canceller := &fakeCanceller{done: make(chan struct{}, 16)}
+			client := NewClient(nil, newSubscription(), canceller)
+			client.userId = 7
+			for _, productId := range test.productIds {
+				client.cancelOnDisconnect[productId] = struct{}{}
+			}
+
+			// the reader and the writer both close the client
+			client.close(models.CancelTriggerDisconnect)
+			client.close(models.CancelTriggerHeartbeatTimeout)
+
+			for range test.want {
+				select {
+				case <-canceller.done:
+				case <-time.After(time.Second):
+					t.Fatal("orders are not cancelled")
+				}
+			}
+			select {
+			case <-canceller.done:
+				t.Fatal("orders are cancelled more than once")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			sort.Strings(canceller.cancels)
+			if fmt.Sprint(canceller.cancels) != fmt.Sprint(test.want) {
+				t.Errorf("cancels %q, want %q", canceller.cancels, test.want)
+			}
+		})
+	}
+}
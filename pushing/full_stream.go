@@ -0,0 +1,204 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/utils"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// FullStream publishes every state transition of the orders on the full channel, from which a client can
+// rebuild the order book order by order. Only the sizes shown on the book are published, the reserve of an
+// iceberg order is never exposed, and nothing but the trades of a hidden order is published.
+type FullStream struct {
+	productId string
+	sub       *subscription
+	logReader matching.LogReader
+}
+
+func newFullStream(productId string, sub *subscription, logReader matching.LogReader) *FullStream {
+	s := &FullStream{
+		productId: productId,
+		sub:       sub,
+		logReader: logReader,
+	}
+
+	s.logReader.RegisterObserver(s)
+	return s
+}
+
+func (s *FullStream) Start() {
+	// -1 : read from end
+	go s.logReader.Run(0, -1)
+}
+
+func (s *FullStream) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	if log.Hidden {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:      "received",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		OrderId:   utils.I64ToA(log.OrderId),
+		OrderType: log.OrderType.String(),
+		Side:      log.Side.String(),
+		Price:     log.Price.String(),
+		Size:      shownSize(log.Size, log.DisplaySize).String(),
+		Funds:     log.Funds.String(),
+	})
+}
+
+func (s *FullStream) OnRejectLog(log *matching.RejectLog, offset int64) {
+	if log.Hidden {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:      "rejected",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		OrderId:   utils.I64ToA(log.OrderId),
+		OrderType: log.OrderType.String(),
+		Side:      log.Side.String(),
+		Price:     log.Price.String(),
+		Size:      shownSize(log.Size, log.DisplaySize).String(),
+		Funds:     log.Funds.String(),
+		Reason:    string(log.Reason),
+	})
+}
+
+func (s *FullStream) OnOpenLog(log *matching.OpenLog, offset int64) {
+	// hidden orders are not shown on the book, the trades with them are still published
+	if log.Hidden {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:          "open",
+		Sequence:      log.Sequence,
+		Time:          log.Time.Format(time.RFC3339),
+		ProductId:     log.ProductId,
+		OrderId:       utils.I64ToA(log.OrderId),
+		Side:          log.Side.String(),
+		Price:         log.Price.String(),
+		RemainingSize: log.RemainingSize.String(),
+	})
+}
+
+func (s *FullStream) OnMatchLog(log *matching.MatchLog, offset int64) {
+	s.publish(&FullMessage{
+		Type:         "match",
+		Sequence:     log.Sequence,
+		Time:         log.Time.Format(time.RFC3339),
+		ProductId:    log.ProductId,
+		TradeId:      log.TradeId,
+		MakerOrderId: utils.I64ToA(log.MakerOrderId),
+		TakerOrderId: utils.I64ToA(log.TakerOrderId),
+		Side:         log.Side.String(),
+		Price:        log.Price.String(),
+		Size:         log.Size.String(),
+	})
+}
+
+func (s *FullStream) OnDoneLog(log *matching.DoneLog, offset int64) {
+	if log.Hidden {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:          "done",
+		Sequence:      log.Sequence,
+		Time:          log.Time.Format(time.RFC3339),
+		ProductId:     log.ProductId,
+		OrderId:       utils.I64ToA(log.OrderId),
+		Side:          log.Side.String(),
+		Price:         log.Price.String(),
+		RemainingSize: log.VisibleSize.String(),
+		Reason:        string(log.Reason),
+	})
+}
+
+func (s *FullStream) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	if log.Hidden {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:      "activate",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		OrderId:   utils.I64ToA(log.OrderId),
+		OrderType: log.OrderType.String(),
+		Side:      log.Side.String(),
+		Price:     log.Price.String(),
+		Size:      shownSize(log.Size, log.DisplaySize).String(),
+		Funds:     log.Funds.String(),
+		Stop:      log.Stop.String(),
+		StopPrice: log.StopPrice.String(),
+	})
+}
+
+func (s *FullStream) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	if log.Hidden {
+		return
+	}
+	// the change of the reserve of an iceberg order is not shown
+	if log.VisibleSize.Equal(log.OldVisibleSize) && log.Price.Equal(log.OldPrice) {
+		return
+	}
+	s.publish(&FullMessage{
+		Type:      "change",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		OrderId:   utils.I64ToA(log.OrderId),
+		Side:      log.Side.String(),
+		Price:     log.Price.String(),
+		OldPrice:  log.OldPrice.String(),
+		NewSize:   log.VisibleSize.String(),
+		OldSize:   log.OldVisibleSize.String(),
+	})
+}
+
+func (s *FullStream) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (s *FullStream) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (s *FullStream) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (s *FullStream) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
+func (s *FullStream) publish(message *FullMessage) {
+	s.sub.publish(ChannelFull.FormatWithProductId(s.productId), message)
+}
+
+// shownSize returns the part of the size of an order shown on the book, an iceberg order only shows its display
+// size
+func shownSize(size, displaySize decimal.Decimal) decimal.Decimal {
+	if displaySize.GreaterThan(decimal.Zero) {
+		return decimal.Min(size, displaySize)
+	}
+	return size
+}
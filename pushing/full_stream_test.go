@@ -0,0 +1,223 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var fullTestTime = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fullTestOrder(id, userId int64, side models.Side, price, size, displaySize string) *models.Order {
+	return &models.Order{
+		Id:          id,
+		CreatedAt:   fullTestTime.Add(time.Duration(id) * time.Second),
+		ProductId:   "BTC-USDT",
+		UserId:      userId,
+		Type:        models.OrderTypeLimit,
+		Side:        side,
+		Price:       decimal.RequireFromString(price),
+		Size:        decimal.RequireFromString(size),
+		DisplaySize: decimal.RequireFromString(displaySize),
+		TimeInForce: models.TimeInForceGTC,
+	}
+}
+
+// fullTestBook is the order book of the matching engine, whose type is not exported
+type fullTestBook interface {
+	ApplyOrder(order *models.Order) []matching.Log
+	CancelOrder(order *models.Order) []matching.Log
+	ApplyCommand(command *matching.Command) []matching.Log
+}
+
+// publishLogs applies the input to the order book and passes the logs to the stream like the log reader
+func publishLogs(book fullTestBook, s *FullStream, input interface{}) {
+	var logs []matching.Log
+	switch input := input.(type) {
+	case *matching.Command:
+		logs = book.ApplyCommand(input)
+	case *models.Order:
+		if input.Status == models.OrderStatusCancelling {
+			logs = book.CancelOrder(input)
+		} else {
+			logs = book.ApplyOrder(input)
+		}
+	}
+
+	for _, log := range logs {
+		switch log := log.(type) {
+		case *matching.ReceivedLog:
+			s.OnReceivedLog(log, 0)
+		case *matching.RejectLog:
+			s.OnRejectLog(log, 0)
+		case *matching.OpenLog:
+			s.OnOpenLog(log, 0)
+		case *matching.MatchLog:
+			s.OnMatchLog(log, 0)
+		case *matching.DoneLog:
+			s.OnDoneLog(log, 0)
+		case *matching.ChangeLog:
+			s.OnChangeLog(log, 0)
+		case *matching.ActivateLog:
+			s.OnActivateLog(log, 0)
+		}
+	}
+}
+
+type fullStreamCase struct {
+	name   string
+	before []interface{}
+	step   interface{}
+	want   []string
+}
+
+func runFullStreamCases(t *testing.T, tests []fullStreamCase) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub := newSubscription()
+			client := NewClient(nil, sub, nil)
+			sub.subscribe(ChannelFull.FormatWithProductId("BTC-USDT"), client)
+			s := &FullStream{productId: "BTC-USDT", sub: sub}
+
+			book := matching.NewOrderBook(&models.Product{Id: "BTC-USDT", BaseScale: 4, QuoteScale: 2})
+			for _, input := range test.before {
+				publishLogs(book, s, input)
+			}
+			for len(client.writeCh) > 0 {
+				<-client.writeCh
+			}
+
+			publishLogs(book, s, test.step)
+			var got []string
+			for len(client.writeCh) > 0 {
+				got = append(got, describeFullMessage((<-client.writeCh).(*FullMessage)))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("messages mismatch\ngot:  %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+// describeFullMessage formats the sizes of a message of the full channel in one line
+func describeFullMessage(message *FullMessage) string {
+	switch message.Type {
+	case "received", "rejected":
+		return fmt.Sprintf("%v %v %v", message.Type, message.OrderId, message.Size)
+	case "match":
+		return fmt.Sprintf("%v %v %v %v", message.Type, message.TakerOrderId, message.MakerOrderId, message.Size)
+	case "open", "done":
+		return fmt.Sprintf("%v %v %v", message.Type, message.OrderId, message.RemainingSize)
+	case "change":
+		return fmt.Sprintf("%v %v %v->%v", message.Type, message.OrderId, message.OldSize, message.NewSize)
+	default:
+		return fmt.Sprintf("%v %v", message.Type, message.OrderId)
+	}
+}
+
+func TestFullStreamOnlyShowsTheVisibleSize(t *testing.T) {
+	iceberg := fullTestOrder(1, 1, models.SideSell, "100", "10", "2")
+	amend := func(size string) *matching.Command {
+		return &matching.Command{
+			CommandType: matching.CommandTypeAmend,
+			UserId:      1,
+			OrderId:     1,
+			Side:        models.SideSell,
+			Size:        decimal.RequireFromString(size),
+			Time:        fullTestTime.Add(10 * time.Second),
+		}
+	}
+	cancel := *iceberg
+	cancel.Status = models.OrderStatusCancelling
+
+	tests := []fullStreamCase{
+		{
+			name: "received and open messages of an iceberg order show the display size",
+			step: iceberg,
+			want: []string{"received 1 2", "open 1 2"},
+		},
+		{
+			name:   "decrease of the reserve of an iceberg order is not published",
+			before: []interface{}{iceberg},
+			step:   amend("5"),
+		},
+		{
+			name:   "decrease of the visible slice publishes the visible sizes",
+			before: []interface{}{iceberg},
+			step:   amend("1"),
+			want:   []string{"change 1 2->1"},
+		},
+		{
+			name:   "done message of a cancelled iceberg order shows the rest of its slice",
+			before: []interface{}{iceberg, fullTestOrder(2, 2, models.SideBuy, "100", "1", "0")},
+			step:   &cancel,
+			want:   []string{"done 1 1"},
+		},
+		{
+			name: "done message of an iceberg taker shows at most the display size",
+			step: func() *models.Order {
+				order := fullTestOrder(3, 2, models.SideBuy, "100", "10", "2")
+				order.TimeInForce = models.TimeInForceIOC
+				return order
+			}(),
+			want: []string{"received 3 2", "done 3 2"},
+		},
+	}
+
+	runFullStreamCases(t, tests)
+}
+
+func TestFullStreamHidesHiddenOrders(t *testing.T) {
+	hidden := fullTestOrder(1, 1, models.SideSell, "100", "5", "0")
+	hidden.Hidden = true
+	cancel := *hidden
+	cancel.Status = models.OrderStatusCancelling
+
+	runFullStreamCases(t, []fullStreamCase{
+		{
+			name: "hidden order is not published when it is received and put on the book",
+			step: hidden,
+		},
+		{
+			name:   "amend of a hidden order is not published",
+			before: []interface{}{hidden},
+			step: &matching.Command{
+				CommandType: matching.CommandTypeAmend,
+				UserId:      1,
+				OrderId:     1,
+				Side:        models.SideSell,
+				Size:        decimal.New(2, 0),
+				Time:        fullTestTime.Add(10 * time.Second),
+			},
+		},
+		{
+			name:   "trade with a hidden order is published",
+			before: []interface{}{hidden},
+			step:   fullTestOrder(2, 2, models.SideBuy, "100", "2", "0"),
+			want:   []string{"received 2 2", "match 2 1 2", "done 2 0"},
+		},
+		{
+			name:   "cancel of a hidden order is not published",
+			before: []interface{}{hidden},
+			step:   &cancel,
+		},
+	})
+}
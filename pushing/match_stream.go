@@ -48,6 +48,14 @@ func (s *MatchStream) Start() {
 	go s.logReader.Run(0, -1)
 }
 
+func (s *MatchStream) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
 func (s *MatchStream) OnOpenLog(log *matching.OpenLog, offset int64) {
 	// do nothing
 }
@@ -56,6 +64,30 @@ func (s *MatchStream) OnDoneLog(log *matching.DoneLog, offset int64) {
 	// do nothing
 }
 
+func (s *MatchStream) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (s *MatchStream) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
 func (s *MatchStream) OnMatchLog(log *matching.MatchLog, offset int64) {
 	// push match
 	s.sub.publish(ChannelMatch.FormatWithProductId(log.ProductId), &MatchMessage{
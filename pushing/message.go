@@ -35,11 +35,14 @@ const (
 	Level2TypeSnapshot = Level2Type("snapshot")
 	Level2TypeUpdate   = Level2Type("l2update")
 
-	ChannelTicker = Channel("ticker")
-	ChannelMatch  = Channel("match")
-	ChannelLevel2 = Channel("level2")
-	ChannelFunds  = Channel("funds")
-	ChannelOrder  = Channel("order")
+	ChannelTicker  = Channel("ticker")
+	ChannelMatch   = Channel("match")
+	ChannelLevel2  = Channel("level2")
+	ChannelFunds   = Channel("funds")
+	ChannelOrder   = Channel("order")
+	ChannelStatus  = Channel("status")
+	ChannelAuction = Channel("auction")
+	ChannelFull    = Channel("full")
 )
 
 type Request struct {
@@ -91,6 +94,30 @@ type MatchMessage struct {
 	Side         string `json:"side"`
 }
 
+// FullMessage is a message of the full channel, only the fields of its type are set
+type FullMessage struct {
+	Type          string `json:"type"`
+	Sequence      int64  `json:"sequence"`
+	Time          string `json:"time"`
+	ProductId     string `json:"productId"`
+	OrderId       string `json:"orderId,omitempty"`
+	OrderType     string `json:"orderType,omitempty"`
+	Side          string `json:"side"`
+	Price         string `json:"price,omitempty"`
+	Size          string `json:"size,omitempty"`
+	Funds         string `json:"funds,omitempty"`
+	RemainingSize string `json:"remainingSize,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	TradeId       int64  `json:"tradeId,omitempty"`
+	MakerOrderId  string `json:"makerOrderId,omitempty"`
+	TakerOrderId  string `json:"takerOrderId,omitempty"`
+	OldPrice      string `json:"oldPrice,omitempty"`
+	NewSize       string `json:"newSize,omitempty"`
+	OldSize       string `json:"oldSize,omitempty"`
+	Stop          string `json:"stop,omitempty"`
+	StopPrice     string `json:"stopPrice,omitempty"`
+}
+
 type TickerMessage struct {
 	Type      string `json:"type"`
 	TradeId   int64  `json:"tradeId"`
@@ -108,6 +135,46 @@ type TickerMessage struct {
 	Open24h   string `json:"open24h"`
 }
 
+type StatusMessage struct {
+	Type      string `json:"type"`
+	Sequence  int64  `json:"sequence"`
+	Time      string `json:"time"`
+	ProductId string `json:"productId"`
+	OldStatus string `json:"oldStatus,omitempty"`
+	Status    string `json:"status"`
+}
+
+// CancelOnDisconnectMessage confirms the products whose orders are cancelled when the connection is closed, all the
+// orders of the user on them are cancelled, wherever they are placed from
+type CancelOnDisconnectMessage struct {
+	Type       string   `json:"type"`
+	ProductIds []string `json:"productIds"`
+	Message    string   `json:"message,omitempty"`
+}
+
+type BreakerMessage struct {
+	Type           string `json:"type"`
+	Sequence       int64  `json:"sequence"`
+	Time           string `json:"time"`
+	ProductId      string `json:"productId"`
+	ReferencePrice string `json:"referencePrice"`
+	LowPrice       string `json:"lowPrice"`
+	HighPrice      string `json:"highPrice"`
+	Price          string `json:"price"`
+	Status         string `json:"status"`
+	Until          string `json:"until,omitempty"`
+}
+
+type AuctionMessage struct {
+	Type      string `json:"type"`
+	Sequence  int64  `json:"sequence"`
+	Time      string `json:"time"`
+	ProductId string `json:"productId"`
+	Price     string `json:"price"`
+	Volume    string `json:"volume"`
+	Imbalance string `json:"imbalance"`
+}
+
 type FundsMessage struct {
 	Type      string `json:"type"`
 	Sequence  int64  `json:"sequence"`
@@ -128,10 +195,13 @@ type OrderMessage struct {
 	ProductId     string `json:"productId"`
 	Side          string `json:"side"`
 	OrderType     string `json:"orderType"`
+	Stop          string `json:"stop,omitempty"`
+	StopPrice     string `json:"stopPrice,omitempty"`
 	CreatedAt     string `json:"createdAt"`
 	FillFees      string `json:"fillFees"`
 	FilledSize    string `json:"filledSize"`
 	ExecutedValue string `json:"executedValue"`
 	Status        string `json:"status"`
+	DoneReason    string `json:"doneReason"`
 	Settled       bool   `json:"settled"`
 }
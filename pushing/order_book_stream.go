@@ -17,6 +17,7 @@ package pushing
 import (
 	"fmt"
 	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/shopspring/decimal"
 	logger "github.com/siddontang/go-log/log"
 	"sync"
 	"time"
@@ -70,6 +71,14 @@ func (s *OrderBookStream) Start() {
 	go s.runSnapshots()
 }
 
+func (s *OrderBookStream) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (s *OrderBookStream) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
 func (s *OrderBookStream) OnOpenLog(log *matching.OpenLog, offset int64) {
 	s.logCh <- &logOffset{log, offset}
 }
@@ -82,6 +91,30 @@ func (s *OrderBookStream) OnDoneLog(log *matching.DoneLog, offset int64) {
 	s.logCh <- &logOffset{log, offset}
 }
 
+func (s *OrderBookStream) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (s *OrderBookStream) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	s.logCh <- &logOffset{log, offset}
+}
+
+func (s *OrderBookStream) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (s *OrderBookStream) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (s *OrderBookStream) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (s *OrderBookStream) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
 func (s *OrderBookStream) runApplier() {
 	var lastLevel2Snapshot *OrderBookLevel2Snapshot
 	var lastFullSnapshot *OrderBookFullSnapshot
@@ -94,28 +127,61 @@ func (s *OrderBookStream) runApplier() {
 			switch logOffset.log.(type) {
 			case *matching.DoneLog:
 				log := logOffset.log.(*matching.DoneLog)
-				order, found := s.orderBook.orders[log.OrderId]
-				if !found {
+				if _, found := s.orderBook.orders[log.OrderId]; !found {
 					continue
 				}
-				newSize := order.Size.Sub(log.RemainingSize)
-				l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.OrderId, newSize, log.Price,
-					log.Side)
+				// the order is removed from the book, RemainingSize of an iceberg order includes its hidden reserve
+				l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.OrderId, decimal.Zero,
+					log.Price, log.Side)
 
 			case *matching.OpenLog:
 				log := logOffset.log.(*matching.OpenLog)
+				// hidden orders are never shown on the book
+				if log.Hidden {
+					continue
+				}
 				l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.OrderId, log.RemainingSize,
 					log.Price, log.Side)
 
+			case *matching.ChangeLog:
+				log := logOffset.log.(*matching.ChangeLog)
+				if log.Hidden {
+					continue
+				}
+				if !log.OldPrice.Equal(log.Price) {
+					// the order is moved to another price level, remove it from the old level first
+					oldL2Change := s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.OrderId, decimal.Zero,
+						log.OldPrice, log.Side)
+					if oldL2Change != nil {
+						s.sub.publish(ChannelLevel2.FormatWithProductId(s.productId), oldL2Change)
+					}
+				}
+				l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.OrderId, log.VisibleSize,
+					log.Price, log.Side)
+
 			case *matching.MatchLog:
 				log := logOffset.log.(*matching.MatchLog)
-				order, found := s.orderBook.orders[log.MakerOrderId]
-				if !found {
-					panic(fmt.Sprintf("should not happen : %+v", log))
+				if !log.MakerHidden {
+					order, found := s.orderBook.orders[log.MakerOrderId]
+					if !found {
+						panic(fmt.Sprintf("should not happen : %+v", log))
+					}
+					// the trade price differs from the order price when the call auction is uncrossed
+					newSize := order.Size.Sub(log.Size)
+					l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.MakerOrderId, newSize,
+						order.Price, order.Side)
+				}
+
+				// the taker is also on the book when the call auction is uncrossed
+				takerOrder, found := s.orderBook.orders[log.TakerOrderId]
+				if found {
+					if l2Change != nil {
+						s.sub.publish(ChannelLevel2.FormatWithProductId(s.productId), l2Change)
+					}
+					newSize := takerOrder.Size.Sub(log.Size)
+					l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.TakerOrderId, newSize,
+						takerOrder.Price, takerOrder.Side)
 				}
-				newSize := order.Size.Sub(log.Size)
-				l2Change = s.orderBook.saveOrder(logOffset.offset, log.Sequence, log.MakerOrderId, newSize,
-					log.Price, log.Side)
 			}
 
 			if lastLevel2Snapshot == nil || s.orderBook.seq-lastLevel2Snapshot.Seq > 10 {
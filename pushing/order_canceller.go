@@ -0,0 +1,89 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/service"
+	"github.com/siddontang/go-log/log"
+	"sync"
+	"time"
+)
+
+// massCanceller cancels all the orders of a user on a product
+type massCanceller interface {
+	cancelOrders(userId int64, productId string, triggerType models.CancelTriggerType, clientId int64)
+}
+
+// orderCanceller sends mass cancels of the orders of a user to the order topic, so that the cancels are applied
+// by the matching engine no matter which push server sends them.
+type orderCanceller struct {
+	// identifies the push server in the audit trail when several push servers run side by side
+	serverId string
+	writers  sync.Map
+}
+
+func newOrderCanceller(serverId string) *orderCanceller {
+	return &orderCanceller{
+		serverId: serverId,
+	}
+}
+
+// cancelOrders cancels all the orders of the user on the product, and records the trigger in the audit trail. The
+// orders are cancelled by the user, not by the connection, so the orders placed from other connections or through
+// the REST API are cancelled as well.
+func (c *orderCanceller) cancelOrders(userId int64, productId string, triggerType models.CancelTriggerType,
+	clientId int64) {
+	buf, err := json.Marshal(&matching.Command{
+		CommandType: matching.CommandTypeMassCancel,
+		ProductId:   productId,
+		UserId:      userId,
+		Time:        time.Now(),
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	err = c.getWriter(productId).Write(buf)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	log.Infof("%v cancelled the orders of user %v on %v", triggerType, userId, productId)
+
+	err = service.AddCancelTrigger(&models.CancelTrigger{
+		UserId:    userId,
+		ProductId: productId,
+		Type:      triggerType,
+		Source:    fmt.Sprintf("%v#%v", c.serverId, clientId),
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (c *orderCanceller) getWriter(productId string) matching.OrderWriter {
+	writer, found := c.writers.Load(productId)
+	if found {
+		return writer.(matching.OrderWriter)
+	}
+
+	writer, _ = c.writers.LoadOrStore(productId, matching.NewOrderWriter(productId))
+	return writer.(matching.OrderWriter)
+}
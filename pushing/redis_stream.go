@@ -68,6 +68,11 @@ func (s *redisStream) Start() {
 						continue
 					}
 
+					var stopPrice string
+					if len(order.Stop) != 0 {
+						stopPrice = order.StopPrice.String()
+					}
+
 					s.sub.publish(ChannelOrder.Format(order.ProductId, order.UserId), OrderMessage{
 						UserId:        order.UserId,
 						Type:          "order",
@@ -79,11 +84,14 @@ func (s *redisStream) Start() {
 						ProductId:     order.ProductId,
 						Side:          order.Side.String(),
 						OrderType:     order.Type.String(),
+						Stop:          order.Stop.String(),
+						StopPrice:     stopPrice,
 						CreatedAt:     order.CreatedAt.Format(time.RFC3339),
 						FillFees:      order.FillFees.String(),
 						FilledSize:    order.FilledSize.String(),
 						ExecutedValue: order.ExecutedValue.String(),
 						Status:        order.Status.String(),
+						DoneReason:    string(order.DoneReason),
 						Settled:       order.Settled,
 					})
 				}
@@ -23,16 +23,18 @@ import (
 )
 
 type Server struct {
-	addr string
-	path string
-	sub  *subscription
+	addr      string
+	path      string
+	sub       *subscription
+	canceller *orderCanceller
 }
 
-func NewServer(addr, path string, sub *subscription) *Server {
+func NewServer(addr, path string, sub *subscription, canceller *orderCanceller) *Server {
 	return &Server{
-		addr: addr,
-		path: path,
-		sub:  sub,
+		addr:      addr,
+		path:      path,
+		sub:       sub,
+		canceller: canceller,
 	}
 }
 
@@ -49,7 +51,7 @@ func (s *Server) ws(c *gin.Context) {
 		return
 	}
 
-	NewClient(conn, s.sub).startServe()
+	NewClient(conn, s.sub, s.canceller).startServe()
 }
 
 func (s *Server) Run() {
@@ -0,0 +1,182 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/service"
+	logger "github.com/siddontang/go-log/log"
+	"sync"
+	"time"
+)
+
+// StatusStream broadcasts the trading status transitions of a product, and the indicative price and volume of
+// its call auction
+type StatusStream struct {
+	productId string
+	sub       *subscription
+	logReader matching.LogReader
+
+	// saves the status of the product into the database, the transitions made by the engine itself, e.g. by the
+	// circuit breaker, are only known from the logs
+	updateStatus func(productId string, status models.ProductStatus) error
+}
+
+func newStatusStream(product *models.Product, sub *subscription, logReader matching.LogReader) *StatusStream {
+	s := &StatusStream{
+		productId:    product.Id,
+		sub:          sub,
+		logReader:    logReader,
+		updateStatus: service.UpdateProductStatus,
+	}
+
+	// the status of the product is sent to new subscribers before any transition is read
+	status := product.Status
+	if len(status) == 0 {
+		status = models.ProductStatusOpen
+	}
+	lastStatuses.Store(product.Id, &StatusMessage{
+		Type:      "status",
+		Time:      product.UpdatedAt.Format(time.RFC3339),
+		ProductId: product.Id,
+		Status:    status.String(),
+	})
+
+	s.logReader.RegisterObserver(s)
+	return s
+}
+
+func (s *StatusStream) Start() {
+	// -1 : read from end
+	go s.logReader.Run(0, -1)
+}
+
+func (s *StatusStream) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnOpenLog(log *matching.OpenLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnMatchLog(log *matching.MatchLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnDoneLog(log *matching.DoneLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// do nothing
+}
+
+func (s *StatusStream) OnStatusLog(log *matching.StatusLog, offset int64) {
+	status := &StatusMessage{
+		Type:      "status",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		OldStatus: log.OldStatus.String(),
+		Status:    log.Status.String(),
+	}
+	lastStatuses.Store(log.ProductId, status)
+	s.sub.publish(ChannelStatus.FormatWithProductId(log.ProductId), status)
+
+	// the orders are checked against the status in the database before they are submitted, and the API reads
+	// it, so it must follow the engine. Every push server writes the same status, which is harmless.
+	for {
+		err := s.updateStatus(log.ProductId, log.Status)
+		if err != nil {
+			logger.Error(err)
+			time.Sleep(time.Second)
+			continue
+		}
+		break
+	}
+
+	// the indicative price is meaningless once the call auction is over
+	if log.OldStatus == models.ProductStatusAuction {
+		lastAuctions.Delete(log.ProductId)
+	}
+}
+
+func (s *StatusStream) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	auction := &AuctionMessage{
+		Type:      "auction",
+		Sequence:  log.Sequence,
+		Time:      log.Time.Format(time.RFC3339),
+		ProductId: log.ProductId,
+		Price:     log.Price.String(),
+		Volume:    log.Volume.String(),
+		Imbalance: log.Imbalance.String(),
+	}
+	lastAuctions.Store(log.ProductId, auction)
+	s.sub.publish(ChannelAuction.FormatWithProductId(log.ProductId), auction)
+}
+
+// OnBreakerLog publishes the trip of the circuit breaker on the status channel, it is followed by the status
+// transition caused by it
+func (s *StatusStream) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	var until string
+	if !log.Until.IsZero() {
+		until = log.Until.Format(time.RFC3339)
+	}
+	s.sub.publish(ChannelStatus.FormatWithProductId(log.ProductId), &BreakerMessage{
+		Type:           "breaker",
+		Sequence:       log.Sequence,
+		Time:           log.Time.Format(time.RFC3339),
+		ProductId:      log.ProductId,
+		ReferencePrice: log.ReferencePrice.String(),
+		LowPrice:       log.LowPrice.String(),
+		HighPrice:      log.HighPrice.String(),
+		Price:          log.Price.String(),
+		Status:         log.Status.String(),
+		Until:          until,
+	})
+}
+
+func (s *StatusStream) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
+var lastStatuses = sync.Map{}
+
+func getLastStatus(productId string) *StatusMessage {
+	status, found := lastStatuses.Load(productId)
+	if !found {
+		return nil
+	}
+	return status.(*StatusMessage)
+}
+
+var lastAuctions = sync.Map{}
+
+func getLastAuction(productId string) *AuctionMessage {
+	auction, found := lastAuctions.Load(productId)
+	if !found {
+		return nil
+	}
+	return auction.(*AuctionMessage)
+}
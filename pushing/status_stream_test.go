@@ -0,0 +1,67 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushing
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"testing"
+	"time"
+)
+
+func TestStatusStreamUpdatesProductStatus(t *testing.T) {
+	var updated []models.ProductStatus
+	sub := newSubscription()
+	s := &StatusStream{
+		productId: "BTC-USDT",
+		sub:       sub,
+		updateStatus: func(productId string, status models.ProductStatus) error {
+			if productId != "BTC-USDT" {
+				t.Errorf("status of %v updated", productId)
+			}
+			updated = append(updated, status)
+			return nil
+		},
+	}
+	client := NewClient(nil, sub, nil)
+	sub.subscribe(ChannelStatus.FormatWithProductId("BTC-USDT"), client)
+
+	// the circuit breaker moves the product into auction, and the end of the auction reopens it
+	logTime := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.OnStatusLog(&matching.StatusLog{
+		Base:      matching.Base{Type: matching.LogTypeStatus, Sequence: 1, ProductId: "BTC-USDT", Time: logTime},
+		OldStatus: models.ProductStatusOpen,
+		Status:    models.ProductStatusAuction,
+	}, 0)
+	s.OnStatusLog(&matching.StatusLog{
+		Base:      matching.Base{Type: matching.LogTypeStatus, Sequence: 2, ProductId: "BTC-USDT", Time: logTime},
+		OldStatus: models.ProductStatusAuction,
+		Status:    models.ProductStatusOpen,
+	}, 1)
+
+	want := []models.ProductStatus{models.ProductStatusAuction, models.ProductStatusOpen}
+	if len(updated) != len(want) || updated[0] != want[0] || updated[1] != want[1] {
+		t.Errorf("updated statuses %v, want %v", updated, want)
+	}
+	for _, status := range want {
+		message := (<-client.writeCh).(*StatusMessage)
+		if message.Status != status.String() {
+			t.Errorf("published status %v, want %v", message.Status, status)
+		}
+	}
+	if last := getLastStatus("BTC-USDT"); last == nil || last.Status != models.ProductStatusOpen.String() {
+		t.Errorf("last status %+v, want open", last)
+	}
+}
@@ -51,6 +51,14 @@ func (s *TickerStream) Start() {
 	go s.logReader.Run(0, -1)
 }
 
+func (s *TickerStream) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
 func (s *TickerStream) OnOpenLog(log *matching.OpenLog, offset int64) {
 	// do nothing
 }
@@ -59,6 +67,30 @@ func (s *TickerStream) OnDoneLog(log *matching.DoneLog, offset int64) {
 	// do nothing
 }
 
+func (s *TickerStream) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (s *TickerStream) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
 func (s *TickerStream) OnMatchLog(log *matching.MatchLog, offset int64) {
 	if time.Now().Unix()-s.lastTickerTime > intervalSec {
 		ticker, err := s.newTickerMessage(log)
@@ -17,6 +17,7 @@ package rest
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/gitbitex/gitbitex-spot/conf"
 	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"net/http"
@@ -51,6 +52,20 @@ func checkToken() gin.HandlerFunc {
 	}
 }
 
+// checkAdmin must be used after checkToken, it only allows the admins in the config
+func checkAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		for _, email := range conf.GetConfig().RestServer.Admins {
+			if user != nil && user.Email == email {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, newMessageVo(errors.New("admin only")))
+	}
+}
+
 func GetCurrentUser(ctx *gin.Context) *models.User {
 	val, found := ctx.Get(keyCurrentUser)
 	if !found {
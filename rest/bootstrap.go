@@ -17,6 +17,7 @@ package rest
 import (
 	"github.com/gitbitex/gitbitex-spot/conf"
 	"github.com/siddontang/go-log/log"
+	"os"
 )
 
 func StartServer() {
@@ -25,5 +26,13 @@ func StartServer() {
 	httpServer := NewHttpServer(gbeConfig.RestServer.Addr)
 	go httpServer.Start()
 
+	// rest servers running side by side are told apart by the host name and the listening address
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+	newCancelTimerRunner(hostname + gbeConfig.RestServer.Addr).Start()
+	newClockRunner().Start()
+
 	log.Info("rest server ok")
 }
@@ -0,0 +1,156 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/service"
+	"github.com/shopspring/decimal"
+	"github.com/siddontang/go-log/log"
+	"time"
+)
+
+// claimed timers which are not completed in time, e.g. the rest server crashes, are claimed again by others
+const cancelTimerClaimTimeout = 30 * time.Second
+
+// cancelTimerRunner cancels all the orders of the users whose cancelAllAfter timers expire. The timers are kept in
+// the database, so that they survive a restart, and every rest server runs the timers. A timer is claimed before
+// it fires, so only one rest server fires it at a time, and it is deleted only after the mass cancel commands are
+// written, a timer failing to fire is released and fired again.
+type cancelTimerRunner struct {
+	// identifies the rest server in the audit trail
+	serverId string
+
+	// the timers and the audit trail, they are kept in the database
+	store cancelTimerStore
+
+	// sends the mass cancels of all the orders of the user
+	cancelOrders func(userId int64) error
+
+	// the current unix time in milliseconds
+	now func() int64
+}
+
+// cancelTimerStore keeps the cancelAllAfter timers and the audit trail of the triggered cancels
+type cancelTimerStore interface {
+	GetExpiredCancelTimers(now int64, limit int) ([]*models.CancelTimer, error)
+	ClaimCancelTimer(userId int64, cancelTime int64, now int64, claimedUntil int64) (bool, error)
+	ReleaseCancelTimer(userId int64, cancelTime int64) error
+	CompleteCancelTimer(userId int64, cancelTime int64) error
+	AddCancelTrigger(trigger *models.CancelTrigger) error
+}
+
+func newCancelTimerRunner(serverId string) *cancelTimerRunner {
+	return &cancelTimerRunner{
+		serverId: serverId,
+		store:    serviceCancelTimerStore{},
+		cancelOrders: func(userId int64) error {
+			return cancelOrders(userId, "", "", decimal.Zero, decimal.Zero)
+		},
+		now: nowMillis,
+	}
+}
+
+func (r *cancelTimerRunner) Start() {
+	go r.runTimers()
+}
+
+func (r *cancelTimerRunner) runTimers() {
+	for {
+		time.Sleep(time.Second)
+		r.fireExpired()
+	}
+}
+
+func (r *cancelTimerRunner) fireExpired() {
+	timers, err := r.store.GetExpiredCancelTimers(r.now(), 100)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, timer := range timers {
+		r.fire(timer)
+	}
+}
+
+func (r *cancelTimerRunner) fire(timer *models.CancelTimer) {
+	// the timer is refreshed, turned off, or claimed by another rest server
+	now := r.now()
+	claimed, err := r.store.ClaimCancelTimer(timer.UserId, timer.CancelTime, now,
+		now+int64(cancelTimerClaimTimeout/time.Millisecond))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	err = r.cancelOrders(timer.UserId)
+	if err != nil {
+		log.Errorf("cancelAllAfter failed to cancel the orders of user %v, retry: %v", timer.UserId, err)
+		err = r.store.ReleaseCancelTimer(timer.UserId, timer.CancelTime)
+		if err != nil {
+			// the claim expires and the timer is claimed again
+			log.Error(err)
+		}
+		return
+	}
+	log.Infof("cancelAllAfter cancelled the orders of user %v", timer.UserId)
+
+	err = r.store.AddCancelTrigger(&models.CancelTrigger{
+		UserId: timer.UserId,
+		Type:   models.CancelTriggerCancelAllAfter,
+		Source: r.serverId,
+	})
+	if err != nil {
+		log.Error(err)
+	}
+
+	err = r.store.CompleteCancelTimer(timer.UserId, timer.CancelTime)
+	if err != nil {
+		// the mass cancel is sent again after the claim expires, which cancels nothing more
+		log.Error(err)
+	}
+}
+
+// serviceCancelTimerStore keeps the timers in the database through the service layer
+type serviceCancelTimerStore struct{}
+
+func (serviceCancelTimerStore) GetExpiredCancelTimers(now int64, limit int) ([]*models.CancelTimer, error) {
+	return service.GetExpiredCancelTimers(now, limit)
+}
+
+func (serviceCancelTimerStore) ClaimCancelTimer(userId int64, cancelTime int64, now int64,
+	claimedUntil int64) (bool, error) {
+	return service.ClaimCancelTimer(userId, cancelTime, now, claimedUntil)
+}
+
+func (serviceCancelTimerStore) ReleaseCancelTimer(userId int64, cancelTime int64) error {
+	return service.ReleaseCancelTimer(userId, cancelTime)
+}
+
+func (serviceCancelTimerStore) CompleteCancelTimer(userId int64, cancelTime int64) error {
+	return service.CompleteCancelTimer(userId, cancelTime)
+}
+
+func (serviceCancelTimerStore) AddCancelTrigger(trigger *models.CancelTrigger) error {
+	return service.AddCancelTrigger(trigger)
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
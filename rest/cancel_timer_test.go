@@ -0,0 +1,218 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"errors"
+	"github.com/gitbitex/gitbitex-spot/models"
+	"testing"
+	"time"
+)
+
+// fakeCancelTimerStore keeps the timers in memory with the same conditions as the mysql store
+type fakeCancelTimerStore struct {
+	timers      map[int64]*models.CancelTimer
+	triggers    []*models.CancelTrigger
+	completeErr error
+}
+
+func newFakeCancelTimerStore() *fakeCancelTimerStore {
+	return &fakeCancelTimerStore{timers: map[int64]*models.CancelTimer{}}
+}
+
+func (s *fakeCancelTimerStore) setTimer(userId, cancelTime int64) {
+	s.timers[userId] = &models.CancelTimer{UserId: userId, CancelTime: cancelTime}
+}
+
+func (s *fakeCancelTimerStore) GetExpiredCancelTimers(now int64, limit int) ([]*models.CancelTimer, error) {
+	var timers []*models.CancelTimer
+	for _, timer := range s.timers {
+		if timer.CancelTime <= now && timer.ClaimedUntil <= now && len(timers) < limit {
+			copied := *timer
+			timers = append(timers, &copied)
+		}
+	}
+	return timers, nil
+}
+
+func (s *fakeCancelTimerStore) ClaimCancelTimer(userId int64, cancelTime int64, now int64,
+	claimedUntil int64) (bool, error) {
+	timer, found := s.timers[userId]
+	if !found || timer.CancelTime != cancelTime || timer.ClaimedUntil > now {
+		return false, nil
+	}
+	timer.ClaimedUntil = claimedUntil
+	return true, nil
+}
+
+func (s *fakeCancelTimerStore) ReleaseCancelTimer(userId int64, cancelTime int64) error {
+	if timer, found := s.timers[userId]; found && timer.CancelTime == cancelTime {
+		timer.ClaimedUntil = 0
+	}
+	return nil
+}
+
+func (s *fakeCancelTimerStore) CompleteCancelTimer(userId int64, cancelTime int64) error {
+	if s.completeErr != nil {
+		return s.completeErr
+	}
+	if timer, found := s.timers[userId]; found && timer.CancelTime == cancelTime {
+		delete(s.timers, userId)
+	}
+	return nil
+}
+
+func (s *fakeCancelTimerStore) AddCancelTrigger(trigger *models.CancelTrigger) error {
+	s.triggers = append(s.triggers, trigger)
+	return nil
+}
+
+type cancelTimerTest struct {
+	store   *fakeCancelTimerStore
+	runner  *cancelTimerRunner
+	now     int64
+	cancels []int64
+	// the mass cancel fails with it if not nil
+	cancelErr error
+}
+
+func newCancelTimerTest() *cancelTimerTest {
+	test := &cancelTimerTest{store: newFakeCancelTimerStore(), now: 1000}
+	test.runner = &cancelTimerRunner{
+		serverId: "test",
+		store:    test.store,
+		cancelOrders: func(userId int64) error {
+			if test.cancelErr != nil {
+				return test.cancelErr
+			}
+			test.cancels = append(test.cancels, userId)
+			return nil
+		},
+		now: func() int64 { return test.now },
+	}
+	return test
+}
+
+func TestCancelTimerFires(t *testing.T) {
+	test := newCancelTimerTest()
+	test.store.setTimer(1, 2000)
+	test.store.setTimer(2, 5000)
+
+	test.runner.fireExpired()
+	if len(test.cancels) != 0 {
+		t.Fatalf("timers fired before they expire: %v", test.cancels)
+	}
+
+	test.now = 2000
+	test.runner.fireExpired()
+	if len(test.cancels) != 1 || test.cancels[0] != 1 {
+		t.Fatalf("cancelled users %v, want [1]", test.cancels)
+	}
+	if _, found := test.store.timers[1]; found {
+		t.Error("fired timer is not deleted")
+	}
+	if _, found := test.store.timers[2]; !found {
+		t.Error("timer which has not expired is deleted")
+	}
+	if len(test.store.triggers) != 1 || test.store.triggers[0].Type != models.CancelTriggerCancelAllAfter {
+		t.Errorf("cancel triggers %+v, want one cancelAllAfter", test.store.triggers)
+	}
+
+	// the timer is gone, it doesn't fire again
+	test.now = 3000
+	test.runner.fireExpired()
+	if len(test.cancels) != 1 {
+		t.Errorf("cancelled users %v, want [1]", test.cancels)
+	}
+}
+
+func TestCancelTimerRearmed(t *testing.T) {
+	test := newCancelTimerTest()
+	test.store.setTimer(1, 2000)
+	test.now = 2000
+	timers, _ := test.store.GetExpiredCancelTimers(test.now, 100)
+
+	// the user refreshes the timer after it is read as expired, the old timer is not fired
+	test.store.setTimer(1, 4000)
+	for _, timer := range timers {
+		test.runner.fire(timer)
+	}
+	if len(test.cancels) != 0 {
+		t.Fatalf("refreshed timer fired: %v", test.cancels)
+	}
+
+	test.now = 4000
+	test.runner.fireExpired()
+	if len(test.cancels) != 1 {
+		t.Fatalf("cancelled users %v, want [1]", test.cancels)
+	}
+	if _, found := test.store.timers[1]; found {
+		t.Error("fired timer is not deleted")
+	}
+}
+
+func TestCancelTimerRetried(t *testing.T) {
+	test := newCancelTimerTest()
+	test.store.setTimer(1, 2000)
+	test.now = 2000
+
+	// the timer is released when the mass cancel fails, and fired again by the next run
+	test.cancelErr = errors.New("queue unavailable")
+	test.runner.fireExpired()
+	if timer := test.store.timers[1]; timer == nil || timer.ClaimedUntil != 0 {
+		t.Fatalf("failed timer is not released: %+v", timer)
+	}
+
+	test.cancelErr = nil
+	test.now = 3000
+	test.runner.fireExpired()
+	if len(test.cancels) != 1 {
+		t.Fatalf("cancelled users %v, want [1]", test.cancels)
+	}
+	if _, found := test.store.timers[1]; found {
+		t.Error("fired timer is not deleted")
+	}
+}
+
+func TestCancelTimerFiredAgainAfterClaimExpires(t *testing.T) {
+	test := newCancelTimerTest()
+	test.store.setTimer(1, 2000)
+	test.now = 2000
+
+	// the mass cancel is written but the timer is not deleted, e.g. the database fails
+	test.store.completeErr = errors.New("database unavailable")
+	test.runner.fireExpired()
+	test.store.completeErr = nil
+	if len(test.cancels) != 1 {
+		t.Fatalf("cancelled users %v, want [1]", test.cancels)
+	}
+
+	// the timer stays claimed, no other rest server fires it
+	test.now += 1000
+	test.runner.fireExpired()
+	if len(test.cancels) != 1 {
+		t.Fatalf("claimed timer fired again: %v", test.cancels)
+	}
+
+	// the mass cancel is sent again once the claim expires, cancelling the same orders twice is harmless
+	test.now = 2000 + int64(cancelTimerClaimTimeout/time.Millisecond)
+	test.runner.fireExpired()
+	if len(test.cancels) != 2 || test.cancels[1] != 1 {
+		t.Fatalf("cancelled users %v, want [1 1]", test.cancels)
+	}
+	if _, found := test.store.timers[1]; found {
+		t.Error("fired timer is not deleted")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/service"
+	"github.com/siddontang/go-log/log"
+	"time"
+)
+
+const clockInterval = time.Second
+
+// clockRunner sends clock commands to the matching engines at a fixed interval. The clock of an order book only
+// moves with the time of the orders and the commands it applies, so that it is the same in a replay, the clock
+// commands expire the GTT orders and end the breaker auctions of the products without any order flow.
+type clockRunner struct {
+	interval time.Duration
+}
+
+func newClockRunner() *clockRunner {
+	return &clockRunner{
+		interval: clockInterval,
+	}
+}
+
+func (r *clockRunner) Start() {
+	go r.runClock()
+}
+
+func (r *clockRunner) runClock() {
+	for {
+		time.Sleep(r.interval)
+
+		products, err := service.GetProducts()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		for _, product := range products {
+			// the order topics in the file queue are only written by the node running the engine
+			if matching.CheckProductNode(product.Id) != nil {
+				continue
+			}
+
+			_ = submitCommand(&matching.Command{
+				CommandType: matching.CommandTypeClock,
+				ProductId:   product.Id,
+				Time:        time.Now(),
+			})
+		}
+	}
+}
@@ -15,18 +15,15 @@
 package rest
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/gitbitex/gitbitex-spot/conf"
 	"github.com/gitbitex/gitbitex-spot/matching"
 	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/gitbitex/gitbitex-spot/utils"
 	"github.com/google/uuid"
-	"github.com/segmentio/kafka-go"
 	"github.com/shopspring/decimal"
 	"github.com/siddontang/go-log/log"
 	"net/http"
@@ -38,22 +35,14 @@ import (
 
 var productId2Writer sync.Map
 
-func getWriter(productId string) *kafka.Writer {
+func getWriter(productId string) matching.OrderWriter {
 	writer, found := productId2Writer.Load(productId)
 	if found {
-		return writer.(*kafka.Writer)
+		return writer.(matching.OrderWriter)
 	}
 
-	gbeConfig := conf.GetConfig()
-
-	newWriter := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      gbeConfig.Kafka.Brokers,
-		Topic:        matching.TopicOrderPrefix + productId,
-		Balancer:     &kafka.LeastBytes{},
-		BatchTimeout: 5 * time.Millisecond,
-	})
-	productId2Writer.Store(productId, newWriter)
-	return newWriter
+	writer, _ = productId2Writer.LoadOrStore(productId, matching.NewOrderWriter(productId))
+	return writer.(matching.OrderWriter)
 }
 
 func submitOrder(order *models.Order) {
@@ -63,10 +52,24 @@ func submitOrder(order *models.Order) {
 		return
 	}
 
-	err = getWriter(order.ProductId).WriteMessages(context.Background(), kafka.Message{Value: buf})
+	err = getWriter(order.ProductId).Write(buf)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func submitCommand(command *matching.Command) error {
+	buf, err := json.Marshal(command)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	err = getWriter(command.ProductId).Write(buf)
 	if err != nil {
 		log.Error(err)
 	}
+	return err
 }
 
 // POST /orders
@@ -78,6 +81,78 @@ func PlaceOrder(ctx *gin.Context) {
 		return
 	}
 
+	// 订单提交给产品所在的撮合节点
+	err = matching.CheckProductNode(req.ProductId)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, newMessageVo(err))
+		return
+	}
+
+	if len(req.Group) > 0 {
+		placeOrderGroup(ctx, &req)
+		return
+	}
+
+	order, err := newOrderFromRequest(ctx, &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	order, err = service.PlaceOrder(order)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+
+	submitOrder(order)
+
+	ctx.JSON(http.StatusOK, order)
+}
+
+// 下一组互相关联的订单，oco的订单一起提交给撮合，bracket的第一个订单是入场单
+func placeOrderGroup(ctx *gin.Context, req *placeOrderRequest) {
+	groupType, err := models.NewOrderGroupTypeFromString(req.Group)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	var orders []*models.Order
+	for _, r := range append([]placeOrderRequest{*req}, req.Orders...) {
+		if len(r.ProductId) == 0 {
+			r.ProductId = req.ProductId
+		}
+		if len(r.Group) > 0 || len(r.Orders) > 0 {
+			ctx.JSON(http.StatusBadRequest, newMessageVo(errors.New("nested order group is not supported")))
+			return
+		}
+		order, err := newOrderFromRequest(ctx, &r)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+			return
+		}
+		orders = append(orders, order)
+	}
+
+	orders, err = service.PlaceOrderGroup(*groupType, orders)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+
+	submitCommand(&matching.Command{
+		CommandType: matching.CommandTypePlaceGroup,
+		ProductId:   orders[0].ProductId,
+		UserId:      orders[0].UserId,
+		Orders:      orders,
+		Time:        orders[0].CreatedAt,
+	})
+
+	ctx.JSON(http.StatusOK, orders)
+}
+
+func newOrderFromRequest(ctx *gin.Context, req *placeOrderRequest) (*models.Order, error) {
 	side := models.Side(req.Side)
 	if len(side) == 0 {
 		side = models.SideBuy
@@ -89,11 +164,51 @@ func PlaceOrder(ctx *gin.Context) {
 	}
 
 	if len(req.ClientOid) > 0 {
-		_, err = uuid.Parse(req.ClientOid)
+		_, err := uuid.Parse(req.ClientOid)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, newMessageVo(fmt.Errorf("invalid client_oid: %v", err)))
-			return
+			return nil, fmt.Errorf("invalid client_oid: %v", err)
+		}
+	}
+
+	var timeInForce models.TimeInForce
+	if len(req.TimeInForce) > 0 {
+		t, err := models.NewTimeInForceFromString(req.TimeInForce)
+		if err != nil {
+			return nil, err
 		}
+		timeInForce = *t
+	}
+
+	var stop models.Stop
+	if len(req.Stop) > 0 {
+		s, err := models.NewStopFromString(req.Stop)
+		if err != nil {
+			return nil, err
+		}
+		stop = *s
+	}
+
+	var peg models.PegType
+	if len(req.Peg) > 0 {
+		p, err := models.NewPegTypeFromString(req.Peg)
+		if err != nil {
+			return nil, err
+		}
+		peg = *p
+	}
+
+	var stp models.SelfTradePrevention
+	if len(req.Stp) > 0 {
+		s, err := models.NewSelfTradePreventionFromString(req.Stp)
+		if err != nil {
+			return nil, err
+		}
+		stp = *s
+	}
+
+	var expireTime time.Time
+	if req.ExpireTime > 0 {
+		expireTime = time.Unix(req.ExpireTime, 0)
 	}
 
 	//todo
@@ -102,17 +217,50 @@ func PlaceOrder(ctx *gin.Context) {
 	size := decimal.NewFromFloat(req.Size)
 	price := decimal.NewFromFloat(req.Price)
 	funds := decimal.NewFromFloat(req.Funds)
+	stopPrice := decimal.NewFromFloat(req.StopPrice)
+	displaySize := decimal.NewFromFloat(req.DisplaySize)
+	protectionPrice := decimal.NewFromFloat(req.ProtectionPrice)
 
-	order, err := service.PlaceOrder(GetCurrentUser(ctx).Id, req.ClientOid, req.ProductId, orderType,
-		side, size, price, funds)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
-		return
+	if orderType == models.OrderTypeMarket && protectionPrice.IsZero() && req.MaxSlippage > 0 {
+		product, err := service.GetProductById(req.ProductId)
+		if err != nil {
+			return nil, err
+		}
+		if product == nil {
+			return nil, errors.New("product not found")
+		}
+		protectionPrice, err = service.GetProtectionPrice(product, side, decimal.NewFromFloat(req.MaxSlippage))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	submitOrder(order)
-
-	ctx.JSON(http.StatusOK, order)
+	return &models.Order{
+		UserId:              GetCurrentUser(ctx).Id,
+		ClientOid:           req.ClientOid,
+		ProductId:           req.ProductId,
+		Type:                orderType,
+		Side:                side,
+		Size:                size,
+		Price:               price,
+		Funds:               funds,
+		TimeInForce:         timeInForce,
+		ExpireTime:          expireTime,
+		PostOnly:            req.PostOnly,
+		PostOnlySlide:       req.PostOnlySlide,
+		Stop:                stop,
+		StopPrice:           stopPrice,
+		TrailingAmount:      decimal.NewFromFloat(req.TrailingAmount),
+		TrailingRate:        decimal.NewFromFloat(req.TrailingRate),
+		DisplaySize:         displaySize,
+		Hidden:              req.Hidden,
+		MinQty:              decimal.NewFromFloat(req.MinQty),
+		Peg:                 peg,
+		PegOffset:           decimal.NewFromFloat(req.PegOffset),
+		PegCap:              decimal.NewFromFloat(req.PegCap),
+		ProtectionPrice:     protectionPrice,
+		SelfTradePrevention: stp,
+	}, nil
 }
 
 // 撤销指定id的订单
@@ -141,41 +289,190 @@ func CancelOrder(ctx *gin.Context) {
 		return
 	}
 
+	// bracket的出场单在入场单完全成交前不在撮合中，需要撤销入场单
+	if order.Status == models.OrderStatusPending {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(errors.New("pending order cannot be cancelled, cancel the entry order instead")))
+		return
+	}
+
+	err = matching.CheckProductNode(order.ProductId)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, newMessageVo(err))
+		return
+	}
+
+	// the cancel time advances the clock of the order book, which expires the GTT orders
 	order.Status = models.OrderStatusCancelling
+	order.UpdatedAt = time.Now()
 	submitOrder(order)
 
 	ctx.JSON(http.StatusOK, nil)
 }
 
-// 批量撤单
-// DELETE /orders/?productId=BTC-USDT&side=[buy,sell]
+// 修改指定id订单的价格或者数量，数量只能减少
+// PATCH /orders/1
+func AmendOrder(ctx *gin.Context) {
+	var req amendOrderRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	orderId, _ := utils.AToInt64(ctx.Param("orderId"))
+	order, err := service.GetOrderById(orderId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+	if order == nil || order.UserId != GetCurrentUser(ctx).Id {
+		ctx.JSON(http.StatusNotFound, newMessageVo(errors.New("order not found")))
+		return
+	}
+
+	err = matching.CheckProductNode(order.ProductId)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, newMessageVo(err))
+		return
+	}
+
+	size, price, err := service.AmendOrder(order.Id, decimal.NewFromFloat(req.Size), decimal.NewFromFloat(req.Price))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	submitCommand(&matching.Command{
+		CommandType: matching.CommandTypeAmend,
+		ProductId:   order.ProductId,
+		UserId:      order.UserId,
+		OrderId:     order.Id,
+		Side:        order.Side,
+		Size:        size,
+		Price:       price,
+		Time:        time.Now(),
+	})
+
+	ctx.JSON(http.StatusOK, nil)
+}
+
+// 批量撤单，由撮合引擎在同一个sequence中撤销用户的所有订单，价格区间只撤销orderBook中价格在区间内的订单
+// DELETE /orders/?productId=BTC-USDT&side=[buy,sell]&lowPrice=100&highPrice=200
 func CancelOrders(ctx *gin.Context) {
 	productId := ctx.Query("productId")
 
-	var side *models.Side
-	var err error
+	var side models.Side
 	rawSide := ctx.Query("side")
 	if len(rawSide) > 0 {
-		side, err = models.NewSideFromString(rawSide)
+		s, err := models.NewSideFromString(rawSide)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+			return
+		}
+		side = *s
+	}
+
+	var lowPrice, highPrice decimal.Decimal
+	var err error
+	if rawLowPrice := ctx.Query("lowPrice"); len(rawLowPrice) > 0 {
+		lowPrice, err = decimal.NewFromString(rawLowPrice)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, newMessageVo(err))
 			return
 		}
 	}
+	if rawHighPrice := ctx.Query("highPrice"); len(rawHighPrice) > 0 {
+		highPrice, err = decimal.NewFromString(rawHighPrice)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+			return
+		}
+	}
+	if (lowPrice.GreaterThan(decimal.Zero) || highPrice.GreaterThan(decimal.Zero)) && len(productId) == 0 {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(errors.New("price range requires productId")))
+		return
+	}
 
-	orders, err := service.GetOrdersByUserId(GetCurrentUser(ctx).Id,
-		[]models.OrderStatus{models.OrderStatusOpen, models.OrderStatusNew}, side, productId, 0, 0, 10000)
+	err = cancelOrders(GetCurrentUser(ctx).Id, productId, side, lowPrice, highPrice)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
 		return
 	}
 
-	for _, order := range orders {
-		order.Status = models.OrderStatusCancelling
-		submitOrder(order)
+	ctx.JSON(http.StatusOK, nil)
+}
+
+// 向产品的撮合引擎发送批量撤单指令，productId为空时发送给所有产品。部分产品发送失败时返回错误，
+// 批量撤单可以重复执行，调用者重试时重新发送给所有产品
+func cancelOrders(userId int64, productId string, side models.Side, lowPrice, highPrice decimal.Decimal) error {
+	productIds := []string{productId}
+	if len(productId) == 0 {
+		products, err := service.GetProducts()
+		if err != nil {
+			return err
+		}
+		productIds = nil
+		for _, product := range products {
+			productIds = append(productIds, product.Id)
+		}
 	}
 
-	ctx.JSON(http.StatusOK, nil)
+	var lastErr error
+	for _, productId := range productIds {
+		err := submitCommand(&matching.Command{
+			CommandType: matching.CommandTypeMassCancel,
+			ProductId:   productId,
+			UserId:      userId,
+			Side:        side,
+			LowPrice:    lowPrice,
+			HighPrice:   highPrice,
+			Time:        time.Now(),
+		})
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// 设置撤销所有订单的倒计时，timeout毫秒内没有再次调用则撤销用户的所有订单，timeout为0时取消倒计时
+// POST /orders/cancelAllAfter
+func CancelAllAfter(ctx *gin.Context) {
+	var req cancelAllAfterRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+	if req.Timeout < 0 {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(fmt.Errorf("timeout %v less than 0", req.Timeout)))
+		return
+	}
+
+	now := time.Now()
+	userId := GetCurrentUser(ctx).Id
+
+	if req.Timeout == 0 {
+		err = service.DeleteCancelTimer(userId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+			return
+		}
+		ctx.JSON(http.StatusOK, &cancelAllAfterVo{Now: now.Format(time.RFC3339Nano)})
+		return
+	}
+
+	cancelTime := now.Add(time.Duration(req.Timeout) * time.Millisecond)
+	err = service.SetCancelTimer(userId, cancelTime.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &cancelAllAfterVo{
+		Now:        now.Format(time.RFC3339Nano),
+		CancelTime: cancelTime.Format(time.RFC3339Nano),
+	})
 }
 
 // GET /orders
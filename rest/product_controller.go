@@ -15,10 +15,14 @@
 package rest
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/gitbitex/gitbitex-spot/matching"
+	"github.com/gitbitex/gitbitex-spot/models"
 	"github.com/gitbitex/gitbitex-spot/service"
 	"github.com/gitbitex/gitbitex-spot/utils"
 	"net/http"
+	"time"
 )
 
 // GET /products
@@ -37,6 +41,87 @@ func GetProducts(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, productVos)
 }
 
+// 修改产品的交易状态，新的状态通过order topic送达撮合引擎，和订单严格有序
+// PUT /admin/products/<product-id>/status
+func UpdateProductStatus(ctx *gin.Context) {
+	var req updateProductStatusRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	status, err := models.NewProductStatusFromString(req.Status)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+
+	product, err := service.GetProductById(ctx.Param("productId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+	if product == nil {
+		ctx.JSON(http.StatusNotFound, newMessageVo(errors.New("product not found")))
+		return
+	}
+
+	err = matching.CheckProductNode(product.Id)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, newMessageVo(err))
+		return
+	}
+
+	err = service.UpdateProductStatus(product.Id, *status)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+
+	submitCommand(&matching.Command{
+		CommandType: matching.CommandTypeProductStatus,
+		ProductId:   product.Id,
+		Status:      *status,
+		Time:        time.Now(),
+	})
+
+	ctx.JSON(http.StatusOK, nil)
+}
+
+// 将产品分配给一个撮合节点，正在运行的节点保存快照并停止引擎后，由新的节点接管，期间的订单在order topic中等待
+// PUT /admin/products/<product-id>/node
+func UpdateProductNode(ctx *gin.Context) {
+	var req updateProductNodeRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(err))
+		return
+	}
+	if len(req.NodeId) == 0 {
+		ctx.JSON(http.StatusBadRequest, newMessageVo(errors.New("nodeId is required")))
+		return
+	}
+
+	product, err := service.GetProductById(ctx.Param("productId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+	if product == nil {
+		ctx.JSON(http.StatusNotFound, newMessageVo(errors.New("product not found")))
+		return
+	}
+
+	err = service.SetProductAssignmentNode(product.Id, req.NodeId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, newMessageVo(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, nil)
+}
+
 // GET /products/<product-id>/book?level=[1,2,3]
 func GetProductOrderBook(ctx *gin.Context) {
 	//todo
@@ -50,7 +50,9 @@ func (server *HttpServer) Start() {
 		private.GET("/api/orders", GetOrders)
 		private.POST("/api/orders", PlaceOrder)
 		private.DELETE("/api/orders/:orderId", CancelOrder)
+		private.PATCH("/api/orders/:orderId", AmendOrder)
 		private.DELETE("/api/orders", CancelOrders)
+		private.POST("/api/orders/cancelAllAfter", CancelAllAfter)
 		private.GET("/api/accounts", GetAccounts)
 		private.GET("/api/users/self", GetUsersSelf)
 		private.POST("/api/users/password", ChangePassword)
@@ -60,6 +62,12 @@ func (server *HttpServer) Start() {
 		private.POST("/api/wallets/:currency/withdrawal", Withdrawal)
 	}
 
+	admin := r.Group("/", checkToken(), checkAdmin())
+	{
+		admin.PUT("/api/admin/products/:productId/status", UpdateProductStatus)
+		admin.PUT("/api/admin/products/:productId/node", UpdateProductNode)
+	}
+
 	err := r.Run(server.addr)
 	if err != nil {
 		panic(err)
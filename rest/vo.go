@@ -41,30 +41,88 @@ type AccountVo struct {
 }
 
 type placeOrderRequest struct {
-	ClientOid   string  `json:"client_oid"`
-	ProductId   string  `json:"productId"`
-	Size        float64 `json:"size"`
-	Funds       float64 `json:"funds"`
-	Price       float64 `json:"price"`
-	Side        string  `json:"side"`
-	Type        string  `json:"type"`        // [optional] limit or market (default is limit)
-	TimeInForce string  `json:"timeInForce"` // [optional] GTC, GTT, IOC, or FOK (default is GTC)
+	ClientOid     string  `json:"client_oid"`
+	ProductId     string  `json:"productId"`
+	Size          float64 `json:"size"`
+	Funds         float64 `json:"funds"`
+	Price         float64 `json:"price"`
+	Side          string  `json:"side"`
+	Type          string  `json:"type"`          // [optional] limit or market (default is limit)
+	TimeInForce   string  `json:"timeInForce"`   // [optional] GTC, GTT, IOC, or FOK (default is GTC)
+	ExpireTime    int64   `json:"expireTime"`    // [optional] unix timestamp in seconds, required by GTT
+	PostOnly      bool    `json:"postOnly"`      // [optional] reject the order if it would take liquidity
+	PostOnlySlide bool    `json:"postOnlySlide"` // [optional] re-price a crossing post only order instead of rejecting it
+	Stop          string  `json:"stop"`          // [optional] loss or entry, requires stopPrice
+	StopPrice     float64 `json:"stopPrice"`     // [optional] the order is triggered when the trade price reaches it
+	Stp           string  `json:"stp"`           // [optional] self trade prevention: dc, co, cn or cb
+	DisplaySize   float64 `json:"displaySize"`   // [optional] only this size of a limit order is shown on the book
+	Hidden        bool    `json:"hidden"`        // [optional] the limit order is not shown on the book at all
+	MinQty        float64 `json:"minQty"`        // [optional] cancel the order if less than this size fills at once
+	// [optional] bestBid, bestAsk or mid, the price of the limit order follows the top of book plus pegOffset,
+	// and never goes beyond pegCap, which is required by a buy order. The price of the request is ignored.
+	Peg       string  `json:"peg"`
+	PegOffset float64 `json:"pegOffset"`
+	PegCap    float64 `json:"pegCap"`
+	// [optional] a market order stops matching at this price, the unfilled size is cancelled
+	ProtectionPrice float64 `json:"protectionPrice"`
+	// [optional] the protection price is the last trade price moved by this rate, e.g. 0.05
+	MaxSlippage float64 `json:"maxSlippage"`
+	// [optional] the stop price of a stop order trails the trade price by this amount or rate, e.g. 0.05.
+	// The stop price is calculated from the last trade price if it is not given.
+	TrailingAmount float64 `json:"trailingAmount"`
+	TrailingRate   float64 `json:"trailingRate"`
+	// [optional] oco or bracket, the order is linked with the orders below. An oco links a take profit limit
+	// order and a stop loss order, a bracket links a buy limit entry order with them.
+	Group  string              `json:"group"`
+	Orders []placeOrderRequest `json:"orders"`
+}
+
+type amendOrderRequest struct {
+	Size  float64 `json:"size"`  // [optional] new remaining size, it can only be decreased
+	Price float64 `json:"price"` // [optional] new price, the order loses its queue priority
+}
+
+type cancelAllAfterRequest struct {
+	Timeout int64 `json:"timeout"` // milliseconds until all orders are cancelled, 0 turns the timer off
+}
+
+type cancelAllAfterVo struct {
+	Now        string `json:"now"`
+	CancelTime string `json:"cancelTime,omitempty"`
 }
 
 type orderVo struct {
-	Id            string `json:"id"`
-	Price         string `json:"price"`
-	Size          string `json:"size"`
-	Funds         string `json:"funds"`
-	ProductId     string `json:"productId"`
-	Side          string `json:"side"`
-	Type          string `json:"type"`
-	CreatedAt     string `json:"createdAt"`
-	FillFees      string `json:"fillFees"`
-	FilledSize    string `json:"filledSize"`
-	ExecutedValue string `json:"executedValue"`
-	Status        string `json:"status"`
-	Settled       bool   `json:"settled"`
+	Id             string `json:"id"`
+	Price          string `json:"price"`
+	Size           string `json:"size"`
+	Funds          string `json:"funds"`
+	ProductId      string `json:"productId"`
+	Side           string `json:"side"`
+	Type           string `json:"type"`
+	TimeInForce    string `json:"timeInForce"`
+	ExpireTime     string `json:"expireTime,omitempty"`
+	PostOnly       bool   `json:"postOnly"`
+	Stop           string `json:"stop,omitempty"`
+	StopPrice      string `json:"stopPrice,omitempty"`
+	TrailingAmount string `json:"trailingAmount,omitempty"`
+	TrailingRate   string `json:"trailingRate,omitempty"`
+	DisplaySize    string `json:"displaySize,omitempty"`
+	Hidden         bool   `json:"hidden,omitempty"`
+	MinQty         string `json:"minQty,omitempty"`
+	Peg            string `json:"peg,omitempty"` // price is the current price of a pegged order
+	PegOffset      string `json:"pegOffset,omitempty"`
+	PegCap         string `json:"pegCap,omitempty"`
+	Protection     string `json:"protectionPrice,omitempty"`
+	Stp            string `json:"stp,omitempty"`
+	GroupId        string `json:"groupId,omitempty"`
+	GroupType      string `json:"groupType,omitempty"`
+	CreatedAt      string `json:"createdAt"`
+	FillFees       string `json:"fillFees"`
+	FilledSize     string `json:"filledSize"`
+	ExecutedValue  string `json:"executedValue"`
+	Status         string `json:"status"`
+	DoneReason     string `json:"doneReason"`
+	Settled        bool   `json:"settled"`
 }
 
 const (
@@ -82,6 +140,15 @@ type ProductVo struct {
 	QuoteIncrement string `json:"quoteIncrement"`
 	BaseScale      int32  `json:"baseScale"`
 	QuoteScale     int32  `json:"quoteScale"`
+	Status         string `json:"status"`
+}
+
+type updateProductStatusRequest struct {
+	Status string `json:"status"` // open, halted, cancelOnly, postOnly or auction
+}
+
+type updateProductNodeRequest struct {
+	NodeId string `json:"nodeId"`
 }
 
 type tradeVo struct {
@@ -195,24 +262,87 @@ func newProductVo(product *models.Product) *ProductVo {
 		QuoteIncrement: utils.F64ToA(product.QuoteIncrement),
 		BaseScale:      product.BaseScale,
 		QuoteScale:     product.QuoteScale,
+		Status:         product.Status.String(),
 	}
 }
 
 func newOrderVo(order *models.Order) *orderVo {
+	var expireTime string
+	if order.TimeInForce == models.TimeInForceGTT {
+		expireTime = order.ExpireTime.Format(time.RFC3339)
+	}
+
+	var stopPrice string
+	if len(order.Stop) != 0 {
+		stopPrice = order.StopPrice.String()
+	}
+
+	var trailingAmount, trailingRate string
+	if !order.TrailingAmount.IsZero() {
+		trailingAmount = order.TrailingAmount.String()
+	}
+	if !order.TrailingRate.IsZero() {
+		trailingRate = order.TrailingRate.String()
+	}
+
+	var protectionPrice string
+	if !order.ProtectionPrice.IsZero() {
+		protectionPrice = order.ProtectionPrice.String()
+	}
+
+	var displaySize string
+	if !order.DisplaySize.IsZero() {
+		displaySize = order.DisplaySize.String()
+	}
+
+	var minQty string
+	if !order.MinQty.IsZero() {
+		minQty = order.MinQty.String()
+	}
+
+	var pegOffset, pegCap string
+	if len(order.Peg) != 0 {
+		pegOffset = order.PegOffset.String()
+		pegCap = order.PegCap.String()
+	}
+
+	var groupId string
+	if order.GroupId != 0 {
+		groupId = utils.I64ToA(order.GroupId)
+	}
+
 	return &orderVo{
-		Id:            utils.I64ToA(order.Id),
-		Price:         order.Price.String(),
-		Size:          order.Size.String(),
-		Funds:         order.ExecutedValue.String(),
-		ProductId:     order.ProductId,
-		Side:          order.Side.String(),
-		Type:          order.Type.String(),
-		CreatedAt:     order.CreatedAt.Format(time.RFC3339),
-		FillFees:      order.FillFees.String(),
-		FilledSize:    order.FilledSize.String(),
-		ExecutedValue: order.ExecutedValue.String(),
-		Status:        order.Status.String(),
-		Settled:       order.Settled,
+		Id:             utils.I64ToA(order.Id),
+		Price:          order.Price.String(),
+		Size:           order.Size.String(),
+		Funds:          order.ExecutedValue.String(),
+		ProductId:      order.ProductId,
+		Side:           order.Side.String(),
+		Type:           order.Type.String(),
+		TimeInForce:    order.TimeInForce.String(),
+		ExpireTime:     expireTime,
+		PostOnly:       order.PostOnly,
+		Stop:           order.Stop.String(),
+		StopPrice:      stopPrice,
+		TrailingAmount: trailingAmount,
+		TrailingRate:   trailingRate,
+		DisplaySize:    displaySize,
+		Hidden:         order.Hidden,
+		MinQty:         minQty,
+		Peg:            order.Peg.String(),
+		PegOffset:      pegOffset,
+		PegCap:         pegCap,
+		Protection:     protectionPrice,
+		Stp:            order.SelfTradePrevention.String(),
+		GroupId:        groupId,
+		GroupType:      order.GroupType.String(),
+		CreatedAt:      order.CreatedAt.Format(time.RFC3339),
+		FillFees:       order.FillFees.String(),
+		FilledSize:     order.FilledSize.String(),
+		ExecutedValue:  order.ExecutedValue.String(),
+		Status:         order.Status.String(),
+		DoneReason:     string(order.DoneReason),
+		Settled:        order.Settled,
 	}
 }
 
@@ -22,92 +22,555 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/shopspring/decimal"
 	"log"
+	"time"
 )
 
-func PlaceOrder(userId int64, clientOid string, productId string, orderType models.OrderType, side models.Side,
-	size, price, funds decimal.Decimal) (*models.Order, error) {
-	product, err := GetProductById(productId)
+func PlaceOrder(order *models.Order) (*models.Order, error) {
+	product, err := GetProductById(order.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil {
+		return nil, errors.New(fmt.Sprintf("product not found: %v", order.ProductId))
+	}
+
+	holdCurrency, holdSize, err := prepareOrder(product, order)
+	if err != nil {
+		return nil, err
+	}
+
+	// tx
+	db, err := mysql.SharedStore().BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Rollback() }()
+
+	err = HoldBalance(db, order.UserId, holdCurrency, holdSize, models.BillTypeTrade)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AddOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, db.CommitTx()
+}
+
+// PlaceOrderGroup下一组联动的订单，第一个订单的id作为订单组的id。
+// OCO是同方向、同数量的一个止盈限价单和一个止损单，两者共用一份冻结资金，按较大的一份冻结。
+// bracket的第一个订单是买入的限价入场单，之后是卖出同样数量的止盈限价单和止损单，入场单完全成交后
+// 买入的base冻结给止盈/止损单，在此之前止盈/止损单不冻结资金。
+func PlaceOrderGroup(groupType models.OrderGroupType, orders []*models.Order) ([]*models.Order, error) {
+	if len(orders) == 0 {
+		return nil, errors.New("no order in the group")
+	}
+
+	product, err := GetProductById(orders[0].ProductId)
 	if err != nil {
 		return nil, err
 	}
 	if product == nil {
-		return nil, errors.New(fmt.Sprintf("product not found: %v", productId))
+		return nil, errors.New(fmt.Sprintf("product not found: %v", orders[0].ProductId))
+	}
+
+	holdCurrencies := make([]string, len(orders))
+	holdSizes := make([]decimal.Decimal, len(orders))
+	for i, order := range orders {
+		// 挂钩订单的价格随盘口变化，无法和订单组共用冻结资金
+		if len(order.Peg) != 0 {
+			return nil, errors.New("pegged order is not allowed in a group")
+		}
+		order.ProductId = product.Id
+		order.UserId = orders[0].UserId
+		holdCurrencies[i], holdSizes[i], err = prepareOrder(product, order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var exits []*models.Order
+	switch groupType {
+	case models.OrderGroupTypeOCO:
+		if len(orders) != 2 {
+			return nil, errors.New("an oco group must have 2 orders")
+		}
+		exits = orders
+	case models.OrderGroupTypeBracket:
+		if len(orders) != 3 {
+			return nil, errors.New("a bracket group must have an entry order and 2 exit orders")
+		}
+		entry := orders[0]
+		if entry.Side != models.SideBuy || entry.Type != models.OrderTypeLimit || len(entry.Stop) != 0 {
+			return nil, errors.New("the entry of a bracket must be a buy limit order")
+		}
+		exits = orders[1:]
+		for _, exit := range exits {
+			if exit.Side != models.SideSell || !exit.Size.Equal(entry.Size) {
+				return nil, errors.New("the exits of a bracket must sell the size of the entry")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid order group type: %v", groupType)
+	}
+
+	// 止盈单是会留在orderBook中的限价单，止损单是止损/止盈单
+	var takeProfit, stopLoss *models.Order
+	for _, exit := range exits {
+		if len(exit.Stop) != 0 {
+			stopLoss = exit
+		} else {
+			takeProfit = exit
+		}
+	}
+	if takeProfit == nil || stopLoss == nil {
+		return nil, errors.New("a take profit limit order and a stop loss order are required")
+	}
+	if takeProfit.Type != models.OrderTypeLimit || takeProfit.TimeInForce == models.TimeInForceIOC ||
+		takeProfit.TimeInForce == models.TimeInForceFOK {
+		return nil, errors.New("the take profit order must be a GTC or GTT limit order")
+	}
+	if takeProfit.Side != stopLoss.Side || !takeProfit.Size.Equal(stopLoss.Size) {
+		return nil, errors.New("the take profit order and the stop loss order must have the same side and size")
 	}
 
-	if orderType == models.OrderTypeLimit {
+	holdCurrency, holdSize := holdCurrencies[0], holdSizes[0]
+	if groupType == models.OrderGroupTypeOCO {
+		// 两个订单共用较大的一份冻结资金，订单结束时按照各自的funds解冻剩余的部分
+		holdSize = decimal.Max(holdSizes[0], holdSizes[1])
+		if takeProfit.Side == models.SideBuy {
+			takeProfit.Funds = holdSize
+			stopLoss.Funds = holdSize
+		}
+	} else {
+		takeProfit.Status = models.OrderStatusPending
+		stopLoss.Status = models.OrderStatusPending
+	}
+
+	// tx
+	db, err := mysql.SharedStore().BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Rollback() }()
+
+	err = HoldBalance(db, orders[0].UserId, holdCurrency, holdSize, models.BillTypeTrade)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		order.GroupType = groupType
+		order.GroupId = orders[0].Id
+		err = db.AddOrder(order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 第一个订单插入后才有id
+	orders[0].GroupId = orders[0].Id
+	err = db.UpdateOrder(orders[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, db.CommitTx()
+}
+
+// prepareOrder校验并规整订单，返回订单需要冻结的币种和数量
+func prepareOrder(product *models.Product, order *models.Order) (string, decimal.Decimal, error) {
+	var err error
+
+	// 撮合引擎会按照自己的交易状态处理订单，这里只是提前拒绝一定会被拒绝的订单
+	switch product.Status {
+	case models.ProductStatusHalted, models.ProductStatusCancelOnly:
+		return "", decimal.Zero, fmt.Errorf("product %v is %v, new orders are not accepted", product.Id, product.Status)
+	case models.ProductStatusAuction:
+		// 集合竞价期间只收集限价单，止损/止盈单在竞价结束后才会被触发
+		if len(order.Stop) == 0 && (order.Type == models.OrderTypeMarket ||
+			order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK) {
+			return "", decimal.Zero, fmt.Errorf("product %v is %v, only limit orders are accepted", product.Id,
+				product.Status)
+		}
+	case models.ProductStatusPostOnly:
+		if order.Type == models.OrderTypeMarket || order.TimeInForce == models.TimeInForceIOC ||
+			order.TimeInForce == models.TimeInForceFOK {
+			return "", decimal.Zero, fmt.Errorf("product %v is %v, only post only limit orders are accepted", product.Id,
+				product.Status)
+		}
+	}
+
+	size, price, funds := order.Size, order.Price, order.Funds
+
+	if order.Type == models.OrderTypeLimit {
 		size = size.Round(product.BaseScale)
 		if size.LessThan(product.BaseMinSize) {
-			return nil, fmt.Errorf("size %v less than base min size %v", size, product.BaseMinSize)
+			return "", decimal.Zero, fmt.Errorf("size %v less than base min size %v", size, product.BaseMinSize)
 		}
 		price = price.Round(product.QuoteScale)
 		if price.LessThan(decimal.Zero) {
-			return nil, fmt.Errorf("price %v less than 0", price)
+			return "", decimal.Zero, fmt.Errorf("price %v less than 0", price)
 		}
 		funds = size.Mul(price)
-	} else if orderType == models.OrderTypeMarket {
-		if side == models.SideBuy {
+
+		if len(order.TimeInForce) == 0 {
+			order.TimeInForce = models.TimeInForceGTC
+		}
+		if order.TimeInForce == models.TimeInForceGTT {
+			if !order.ExpireTime.After(time.Now()) {
+				return "", decimal.Zero, fmt.Errorf("expire time %v is not in the future", order.ExpireTime)
+			}
+		} else {
+			order.ExpireTime = time.Time{}
+		}
+
+		if order.PostOnly && (order.TimeInForce == models.TimeInForceIOC ||
+			order.TimeInForce == models.TimeInForceFOK) {
+			return "", decimal.Zero, fmt.Errorf("post only is not allowed for time in force %v", order.TimeInForce)
+		}
+
+		// 冰山单只在orderBook中展示DisplaySize的数量，展示数量不小于订单数量时就是普通订单
+		order.DisplaySize = order.DisplaySize.Round(product.BaseScale)
+		if order.DisplaySize.GreaterThanOrEqual(size) {
+			order.DisplaySize = decimal.Zero
+		}
+		if order.DisplaySize.GreaterThan(decimal.Zero) {
+			if order.DisplaySize.LessThan(product.BaseMinSize) {
+				return "", decimal.Zero, fmt.Errorf("display size %v less than base min size %v", order.DisplaySize,
+					product.BaseMinSize)
+			}
+			if order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK {
+				return "", decimal.Zero, fmt.Errorf("display size is not allowed for time in force %v", order.TimeInForce)
+			}
+		} else if order.DisplaySize.LessThan(decimal.Zero) {
+			return "", decimal.Zero, fmt.Errorf("display size %v less than 0", order.DisplaySize)
+		}
+
+		// 挂钩订单的价格由撮合引擎按照盘口计算，按数量和最高价格冻结买单的funds
+		if len(order.Peg) != 0 {
+			if _, err := models.NewPegTypeFromString(order.Peg.String()); err != nil {
+				return "", decimal.Zero, err
+			}
+			if order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK {
+				return "", decimal.Zero, fmt.Errorf("peg is not allowed for time in force %v", order.TimeInForce)
+			}
+			if len(order.Stop) != 0 {
+				return "", decimal.Zero, errors.New("peg is not allowed for stop order")
+			}
+			order.PegOffset = order.PegOffset.Round(product.QuoteScale)
+			order.PegCap = order.PegCap.Round(product.QuoteScale)
+			if order.PegCap.LessThan(decimal.Zero) {
+				return "", decimal.Zero, fmt.Errorf("peg cap %v less than 0", order.PegCap)
+			}
+			if order.Side == models.SideBuy {
+				if order.PegCap.IsZero() {
+					return "", decimal.Zero, errors.New("peg cap is required for pegged buy order")
+				}
+				funds = size.Mul(order.PegCap)
+			}
+			price = decimal.Zero
+		} else {
+			order.PegOffset = decimal.Zero
+			order.PegCap = decimal.Zero
+		}
+
+		// 隐藏订单完全不展示，不能同时是冰山单，不会进入orderBook的订单也没有必要隐藏
+		if order.Hidden {
+			if order.DisplaySize.GreaterThan(decimal.Zero) {
+				return "", decimal.Zero, errors.New("display size is not allowed for hidden order")
+			}
+			if order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK {
+				return "", decimal.Zero, fmt.Errorf("hidden is not allowed for time in force %v", order.TimeInForce)
+			}
+		}
+	} else if order.Type == models.OrderTypeMarket {
+		order.ProtectionPrice = order.ProtectionPrice.Round(product.QuoteScale)
+		if order.ProtectionPrice.LessThan(decimal.Zero) {
+			return "", decimal.Zero, fmt.Errorf("protection price %v less than 0", order.ProtectionPrice)
+		}
+
+		if order.Side == models.SideBuy && size.GreaterThan(decimal.Zero) {
+			// 按数量市价买入，按保护价格冻结funds，成交价格不会超过保护价格
+			size = size.Round(product.BaseScale)
+			if size.LessThan(product.BaseMinSize) {
+				return "", decimal.Zero, fmt.Errorf("size %v less than base min size %v", size, product.BaseMinSize)
+			}
+			if order.ProtectionPrice.IsZero() {
+				order.ProtectionPrice, err = GetProtectionPrice(product, order.Side, product.MaxSlippage)
+				if err != nil {
+					return "", decimal.Zero, err
+				}
+			}
+			price = decimal.Zero
+			funds = size.Mul(order.ProtectionPrice)
+		} else if order.Side == models.SideBuy {
 			size = decimal.Zero
 			price = decimal.Zero
 			funds = funds.Round(product.QuoteScale)
 			if funds.LessThan(product.QuoteMinSize) {
-				return nil, fmt.Errorf("funds %v less than quote min size %v", funds, product.QuoteMinSize)
+				return "", decimal.Zero, fmt.Errorf("funds %v less than quote min size %v", funds, product.QuoteMinSize)
 			}
 		} else {
 			size = size.Round(product.BaseScale)
 			if size.LessThan(product.BaseMinSize) {
-				return nil, fmt.Errorf("size %v less than base min size %v", size, product.BaseMinSize)
+				return "", decimal.Zero, fmt.Errorf("size %v less than base min size %v", size, product.BaseMinSize)
 			}
 			price = decimal.Zero
 			funds = decimal.Zero
 		}
+
+		// 市价单总是立即成交，未成交的部分会被取消，time in force对市价单没有意义
+		order.TimeInForce = ""
+		order.ExpireTime = time.Time{}
+
+		if order.PostOnly {
+			return "", decimal.Zero, errors.New("post only is not allowed for market order")
+		}
+		if order.Hidden {
+			return "", decimal.Zero, errors.New("hidden is not allowed for market order")
+		}
+		if len(order.Peg) != 0 {
+			return "", decimal.Zero, errors.New("peg is not allowed for market order")
+		}
+		order.DisplaySize = decimal.Zero
 	} else {
-		return nil, errors.New("unknown order type")
+		return "", decimal.Zero, errors.New("unknown order type")
+	}
+	if order.Type != models.OrderTypeMarket {
+		order.ProtectionPrice = decimal.Zero
+	}
+
+	// 最小成交数量只约束订单到达时立即成交的部分，不能成交这么多时整个订单被取消
+	order.MinQty = order.MinQty.Round(product.BaseScale)
+	if order.MinQty.LessThan(decimal.Zero) {
+		return "", decimal.Zero, fmt.Errorf("min qty %v less than 0", order.MinQty)
+	}
+	if order.MinQty.GreaterThan(decimal.Zero) {
+		if order.PostOnly {
+			return "", decimal.Zero, errors.New("min qty is not allowed for post only order")
+		}
+		if size.IsZero() {
+			return "", decimal.Zero, errors.New("min qty is not allowed for market buy order by funds")
+		}
+		if order.MinQty.GreaterThan(size) {
+			return "", decimal.Zero, fmt.Errorf("min qty %v greater than size %v", order.MinQty, size)
+		}
+	}
+
+	status := models.OrderStatusNew
+	if len(order.Stop) != 0 {
+		if _, err := models.NewStopFromString(order.Stop.String()); err != nil {
+			return "", decimal.Zero, err
+		}
+		order.StopPrice = order.StopPrice.Round(product.QuoteScale)
+
+		// 跟踪止损/止盈单的触发价格由撮合引擎随成交价格移动，没有指定时按最新成交价格计算初始的触发价格
+		order.TrailingAmount = order.TrailingAmount.Round(product.QuoteScale)
+		if order.TrailingAmount.LessThan(decimal.Zero) {
+			return "", decimal.Zero, fmt.Errorf("trailing amount %v less than 0", order.TrailingAmount)
+		}
+		if order.TrailingRate.LessThan(decimal.Zero) || order.TrailingRate.GreaterThanOrEqual(decimal.New(1, 0)) {
+			return "", decimal.Zero, fmt.Errorf("trailing rate %v out of range [0, 1)", order.TrailingRate)
+		}
+		if order.TrailingAmount.GreaterThan(decimal.Zero) && order.TrailingRate.GreaterThan(decimal.Zero) {
+			return "", decimal.Zero, errors.New("only one of trailing amount and trailing rate can be set")
+		}
+		if order.StopPrice.IsZero() && (order.TrailingAmount.GreaterThan(decimal.Zero) ||
+			order.TrailingRate.GreaterThan(decimal.Zero)) {
+			order.StopPrice, err = getTrailingStopPrice(product, order)
+			if err != nil {
+				return "", decimal.Zero, err
+			}
+		}
+
+		if order.StopPrice.LessThanOrEqual(decimal.Zero) {
+			return "", decimal.Zero, fmt.Errorf("stop price %v less than or equal to 0", order.StopPrice)
+		}
+		// 止损/止盈单进入撮合引擎的trigger book等待触发
+		status = models.OrderStatusUntriggered
+	} else {
+		order.StopPrice = decimal.Zero
+		order.TrailingAmount = decimal.Zero
+		order.TrailingRate = decimal.Zero
 	}
 
 	var holdCurrency string
 	var holdSize decimal.Decimal
-	if side == models.SideBuy {
+	if order.Side == models.SideBuy {
 		holdCurrency, holdSize = product.QuoteCurrency, funds
 	} else {
 		holdCurrency, holdSize = product.BaseCurrency, size
 	}
 
-	order := &models.Order{
-		ClientOid: clientOid,
-		UserId:    userId,
-		ProductId: product.Id,
-		Side:      side,
-		Size:      size,
-		Funds:     funds,
-		Price:     price,
-		Status:    models.OrderStatusNew,
-		Type:      orderType,
+	order.ProductId = product.Id
+	order.Size = size
+	order.Funds = funds
+	order.Price = price
+	order.Status = status
+
+	return holdCurrency, holdSize, nil
+}
+
+// GetProtectionPrice returns the protection price of a market order, it is the price of the last trade moved
+// by the max slippage against the order.
+func GetProtectionPrice(product *models.Product, side models.Side, maxSlippage decimal.Decimal) (decimal.Decimal,
+	error) {
+	trade, err := GetLastTradeByProductId(product.Id)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if trade == nil {
+		return decimal.Zero, fmt.Errorf("no trade of %v to calculate the protection price", product.Id)
+	}
+
+	if side == models.SideBuy {
+		return trade.Price.Mul(decimal.New(1, 0).Add(maxSlippage)).Round(product.QuoteScale), nil
 	}
+	return trade.Price.Mul(decimal.New(1, 0).Sub(maxSlippage)).Round(product.QuoteScale), nil
+}
 
+// getTrailingStopPrice按最新成交价格计算跟踪止损/止盈单的初始触发价格
+func getTrailingStopPrice(product *models.Product, order *models.Order) (decimal.Decimal, error) {
+	trade, err := GetLastTradeByProductId(product.Id)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if trade == nil {
+		return decimal.Zero, fmt.Errorf("no trade of %v to calculate the trailing stop price", product.Id)
+	}
+
+	offset := order.TrailingAmount
+	if order.TrailingRate.GreaterThan(decimal.Zero) {
+		offset = trade.Price.Mul(order.TrailingRate).Round(product.QuoteScale)
+	}
+	if order.Stop == models.StopLoss {
+		return trade.Price.Sub(offset), nil
+	}
+	return trade.Price.Add(offset), nil
+}
+
+// AmendOrder检查订单是否可以修改，并返回规整后的新数量和新价格，为0表示不修改。size是订单新的剩余数量，
+// 只能减少。买单提高价格时需要额外冻结funds，多冻结的部分在订单结束时解冻。
+func AmendOrder(orderId int64, size, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
 	// tx
 	db, err := mysql.SharedStore().BeginTx()
 	if err != nil {
-		return nil, err
+		return size, price, err
 	}
 	defer func() { _ = db.Rollback() }()
 
-	err = HoldBalance(db, userId, holdCurrency, holdSize, models.BillTypeTrade)
+	order, err := db.GetOrderByIdForUpdate(orderId)
 	if err != nil {
-		return nil, err
+		return size, price, err
+	}
+	if order == nil {
+		return size, price, fmt.Errorf("order not found: %v", orderId)
+	}
+	if order.Type != models.OrderTypeLimit {
+		return size, price, errors.New("only limit order can be amended")
+	}
+	if order.GroupId != 0 {
+		// 订单组共用冻结资金，修改会破坏冻结的数量
+		return size, price, errors.New("order of a group can not be amended")
+	}
+	if order.Status != models.OrderStatusNew && order.Status != models.OrderStatusOpen {
+		return size, price, fmt.Errorf("order status invalid: %v", order.Status)
 	}
 
-	err = db.AddOrder(order)
+	product, err := GetProductById(order.ProductId)
 	if err != nil {
-		return nil, err
+		return size, price, err
+	}
+	if product == nil {
+		return size, price, fmt.Errorf("product not found: %v", order.ProductId)
+	}
+	size = size.Round(product.BaseScale)
+	price = price.Round(product.QuoteScale)
+	if size.LessThan(decimal.Zero) || price.LessThan(decimal.Zero) {
+		return size, price, errors.New("size and price must not be negative")
+	}
+	if size.IsZero() && price.IsZero() {
+		return size, price, errors.New("nothing to amend")
+	}
+	if size.GreaterThanOrEqual(order.Size.Sub(order.FilledSize)) {
+		return size, price, fmt.Errorf("size %v must be less than the remaining size", size)
+	}
+	if len(order.Peg) != 0 && price.GreaterThan(decimal.Zero) {
+		// 挂钩订单的价格由撮合引擎随盘口调整
+		return size, price, errors.New("the price of a pegged order can not be amended")
 	}
 
-	return order, db.CommitTx()
+	// 买单提高价格，按照修改后的价格冻结全部数量所需的funds
+	if order.Side == models.SideBuy && price.GreaterThan(order.Price) {
+		funds := order.Size.Mul(price)
+		if funds.GreaterThan(order.Funds) {
+			err = HoldBalance(db, order.UserId, product.QuoteCurrency, funds.Sub(order.Funds), models.BillTypeTrade)
+			if err != nil {
+				return size, price, err
+			}
+
+			order.Funds = funds
+			err = db.UpdateOrder(order)
+			if err != nil {
+				return size, price, err
+			}
+		}
+	}
+
+	return size, price, db.CommitTx()
 }
 
 func UpdateOrderStatus(orderId int64, oldStatus, newStatus models.OrderStatus) (bool, error) {
 	return mysql.SharedStore().UpdateOrderStatus(orderId, oldStatus, newStatus)
 }
 
+func UpdateOrderStatusAndPrice(orderId int64, oldStatus, newStatus models.OrderStatus,
+	price decimal.Decimal) (bool, error) {
+	return mysql.SharedStore().UpdateOrderStatusAndPrice(orderId, oldStatus, newStatus, price)
+}
+
+// AddCancelTrigger记录一次批量撤单的触发
+func AddCancelTrigger(trigger *models.CancelTrigger) error {
+	return mysql.SharedStore().AddCancelTrigger(trigger)
+}
+
+// SetCancelTimer设置或者刷新用户撤销所有订单的倒计时，cancelTime为unix毫秒
+func SetCancelTimer(userId int64, cancelTime int64) error {
+	return mysql.SharedStore().SetCancelTimer(userId, cancelTime)
+}
+
+func DeleteCancelTimer(userId int64) error {
+	return mysql.SharedStore().DeleteCancelTimer(userId)
+}
+
+func GetExpiredCancelTimers(now int64, limit int) ([]*models.CancelTimer, error) {
+	return mysql.SharedStore().GetExpiredCancelTimers(now, limit)
+}
+
+// ClaimCancelTimer认领到期且没有被刷新的倒计时直到claimedUntil，多个实例同时处理同一个倒计时时只有一个返回true，
+// 认领超时仍未完成的倒计时可以被重新认领
+func ClaimCancelTimer(userId int64, cancelTime int64, now int64, claimedUntil int64) (bool, error) {
+	return mysql.SharedStore().ClaimCancelTimer(userId, cancelTime, now, claimedUntil)
+}
+
+// ReleaseCancelTimer放弃认领，撤单失败的倒计时立即可以被重新认领
+func ReleaseCancelTimer(userId int64, cancelTime int64) error {
+	return mysql.SharedStore().ReleaseCancelTimer(userId, cancelTime)
+}
+
+// CompleteCancelTimer删除已经完成撤单的倒计时，期间被刷新的倒计时不会被删除
+func CompleteCancelTimer(userId int64, cancelTime int64) error {
+	return mysql.SharedStore().CompleteCancelTimer(userId, cancelTime)
+}
+
+// UpdateOrderStopPrice更新跟踪止损/止盈单的触发价格，订单被触发后不再更新
+func UpdateOrderStopPrice(orderId int64, status models.OrderStatus, stopPrice decimal.Decimal) (bool, error) {
+	return mysql.SharedStore().UpdateOrderStopPrice(orderId, status, stopPrice)
+}
+
 func ExecuteFill(orderId int64) error {
 	// tx
 	db, err := mysql.SharedStore().BeginTx()
@@ -123,7 +586,8 @@ func ExecuteFill(orderId int64) error {
 	if order == nil {
 		return fmt.Errorf("order not found: %v", orderId)
 	}
-	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled {
+	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled ||
+		order.Status == models.OrderStatusRejected {
 		return fmt.Errorf("order status invalid: %v %v", orderId, order.Status)
 	}
 
@@ -149,7 +613,23 @@ func ExecuteFill(orderId int64) error {
 
 		notes := fmt.Sprintf("%v-%v", fill.OrderId, fill.Id)
 
-		if !fill.Done {
+		if fill.Changed {
+			// 订单被修改，减少的数量不再需要冻结
+			order.Size = order.Size.Sub(fill.Size)
+			order.Price = fill.Price
+
+			if order.Side == models.SideSell && fill.Size.GreaterThan(decimal.Zero) {
+				// 卖单，解冻减少的size
+				bill, err := AddDelayBill(db, order.UserId, product.BaseCurrency, fill.Size, fill.Size.Neg(),
+					models.BillTypeTrade, notes)
+				if err != nil {
+					return err
+				}
+				bills = append(bills, bill)
+			}
+			// 买单冻结的是funds，在订单结束时统一解冻
+
+		} else if !fill.Done {
 			executedValue := fill.Size.Mul(fill.Price)
 			order.ExecutedValue = order.ExecutedValue.Add(executedValue)
 			order.FilledSize = order.FilledSize.Add(fill.Size)
@@ -190,15 +670,30 @@ func ExecuteFill(orderId int64) error {
 			}
 
 		} else {
-			if fill.DoneReason == models.DoneReasonCancelled {
-				order.Status = models.OrderStatusCancelled
-			} else if fill.DoneReason == models.DoneReasonFilled {
+			switch fill.DoneReason {
+			case models.DoneReasonFilled:
 				order.Status = models.OrderStatusFilled
-			} else {
+			case models.DoneReasonCancelled, models.DoneReasonExpired, models.DoneReasonIOCCancelled,
+				models.DoneReasonFOKRejected, models.DoneReasonMinQtyRejected, models.DoneReasonPostOnlyRejected,
+				models.DoneReasonSelfTradePrevented,
+				models.DoneReasonPriceProtected, models.DoneReasonStatusRejected, models.DoneReasonCircuitBreaker,
+				models.DoneReasonGroupCancelled, models.DoneReasonPegUnavailable:
+				order.Status = models.OrderStatusCancelled
+			case models.DoneReasonExpiredWindow, models.DoneReasonProductHalted, models.DoneReasonInvalidPrice,
+				models.DoneReasonInvalidSize, models.DoneReasonGroupRejected:
+				order.Status = models.OrderStatusRejected
+			default:
 				log.Fatalf("unknown done reason: %v", fill.DoneReason)
 			}
+			order.DoneReason = fill.DoneReason
 
-			if order.Side == models.SideBuy {
+			if fill.DoneReason == models.DoneReasonGroupCancelled {
+				// 被联动取消的订单没有自己的冻结资金，由组内的其他订单解冻
+
+			} else if order.Status == models.OrderStatusRejected && order.GroupId != 0 && order.Id != order.GroupId {
+				// 订单组只冻结一次资金，整个订单组被拒绝时由组内的第一个订单解冻
+
+			} else if order.Side == models.SideBuy {
 				// 如果是是买单，需要解冻剩余的funds
 				remainingFunds := order.Funds.Sub(order.ExecutedValue)
 				if remainingFunds.GreaterThan(decimal.Zero) {
@@ -223,6 +718,17 @@ func ExecuteFill(orderId int64) error {
 				}
 			}
 
+			if order.GroupType == models.OrderGroupTypeBracket && order.Id == order.GroupId &&
+				fill.DoneReason == models.DoneReasonFilled {
+				// bracket的入场单完全成交，买入的base冻结给止盈/止损单
+				bill, err := AddDelayBill(db, order.UserId, product.BaseCurrency, order.FilledSize.Neg(),
+					order.FilledSize, models.BillTypeTrade, notes)
+				if err != nil {
+					return err
+				}
+				bills = append(bills, bill)
+			}
+
 			break
 		}
 	}
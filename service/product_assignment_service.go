@@ -0,0 +1,38 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/models/mysql"
+)
+
+func GetProductAssignments() ([]*models.ProductAssignment, error) {
+	return mysql.SharedStore().GetProductAssignments()
+}
+
+func GetProductAssignmentByProductId(productId string) (*models.ProductAssignment, error) {
+	return mysql.SharedStore().GetProductAssignmentByProductId(productId)
+}
+
+// SetProductAssignmentNode将产品分配给一个撮合节点，正在运行的节点会保存快照并停止引擎，然后由新的节点接管
+func SetProductAssignmentNode(productId string, nodeId string) error {
+	return mysql.SharedStore().SetProductAssignmentNode(productId, nodeId)
+}
+
+// UpdateProductAssignmentRunningNode在正在运行的节点仍然是oldRunningNodeId时修改它，多个节点同时修改时只有一个返回true
+func UpdateProductAssignmentRunningNode(productId string, oldRunningNodeId, runningNodeId string) (bool, error) {
+	return mysql.SharedStore().UpdateProductAssignmentRunningNode(productId, oldRunningNodeId, runningNodeId)
+}
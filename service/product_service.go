@@ -26,3 +26,7 @@ func GetProductById(id string) (*models.Product, error) {
 func GetProducts() ([]*models.Product, error) {
 	return mysql.SharedStore().GetProducts()
 }
+
+func UpdateProductStatus(productId string, status models.ProductStatus) error {
+	return mysql.SharedStore().UpdateProductStatus(productId, status)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 GitBitEx.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"github.com/gitbitex/gitbitex-spot/models"
+	"github.com/gitbitex/gitbitex-spot/models/mysql"
+)
+
+// AddEngineSnapshot保存一个撮合引擎的快照，并且只保留该产品最近的keep个快照
+func AddEngineSnapshot(snapshot *models.EngineSnapshot, keep int) error {
+	err := mysql.SharedStore().AddEngineSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := mysql.SharedStore().GetEngineSnapshotsByProductId(snapshot.ProductId, keep)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) < keep {
+		return nil
+	}
+	return mysql.SharedStore().DeleteEngineSnapshotsBeforeId(snapshot.ProductId, snapshots[len(snapshots)-1].Id)
+}
+
+// GetEngineSnapshotsByProductId返回该产品最近的快照，从新到旧排列
+func GetEngineSnapshotsByProductId(productId string, limit int) ([]*models.EngineSnapshot, error) {
+	return mysql.SharedStore().GetEngineSnapshotsByProductId(productId, limit)
+}
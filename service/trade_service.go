@@ -23,6 +23,10 @@ func GetTradesByProductId(productId string, count int) ([]*models.Trade, error)
 	return mysql.SharedStore().GetTradesByProductId(productId, count)
 }
 
+func GetLastTradeByProductId(productId string) (*models.Trade, error) {
+	return mysql.SharedStore().GetLastTradeByProductId(productId)
+}
+
 func AddTrades(trades []*models.Trade) error {
 	if len(trades) == 0 {
 		return nil
@@ -84,8 +84,75 @@ func (t *FillMaker) OnMatchLog(log *matching.MatchLog, offset int64) {
 	}
 }
 
+func (t *FillMaker) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (t *FillMaker) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// the order submitted at the first time is still processed by the matching engine
+	if log.Reason == models.DoneReasonDuplicate {
+		return
+	}
+
+	t.fillCh <- &models.Fill{
+		MessageSeq: log.Sequence,
+		OrderId:    log.OrderId,
+		ProductId:  log.ProductId,
+		Size:       log.Size,
+		Done:       true,
+		DoneReason: log.Reason,
+		LogOffset:  offset,
+		LogSeq:     log.Sequence,
+	}
+}
+
 func (t *FillMaker) OnOpenLog(log *matching.OpenLog, offset int64) {
-	_, _ = service.UpdateOrderStatus(log.OrderId, models.OrderStatusNew, models.OrderStatusOpen)
+	// the price of a post only order may have been slid by the engine
+	_, _ = service.UpdateOrderStatusAndPrice(log.OrderId, models.OrderStatusNew, models.OrderStatusOpen, log.Price)
+}
+
+func (t *FillMaker) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	if log.Released {
+		// the exit of a bracket is released, a stop order still waits for its stop price
+		status := models.OrderStatusNew
+		if len(log.Stop) != 0 {
+			status = models.OrderStatusUntriggered
+		}
+		_, _ = service.UpdateOrderStatus(log.OrderId, models.OrderStatusPending, status)
+		return
+	}
+	_, _ = service.UpdateOrderStatus(log.OrderId, models.OrderStatusUntriggered, models.OrderStatusNew)
+}
+
+func (t *FillMaker) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// 和成交一样以fill的方式写入，从而按顺序、仅一次的修改订单以及解冻资金
+	t.fillCh <- &models.Fill{
+		MessageSeq: log.Sequence,
+		OrderId:    log.OrderId,
+		ProductId:  log.ProductId,
+		Size:       log.OldSize.Sub(log.NewSize),
+		Price:      log.Price,
+		Side:       log.Side,
+		Changed:    true,
+		LogOffset:  offset,
+		LogSeq:     log.Sequence,
+	}
+}
+
+func (t *FillMaker) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (t *FillMaker) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (t *FillMaker) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (t *FillMaker) OnTrailLog(log *matching.TrailLog, offset int64) {
+	_, _ = service.UpdateOrderStopPrice(log.OrderId, models.OrderStatusUntriggered, log.StopPrice)
 }
 
 func (t *FillMaker) OnDoneLog(log *matching.DoneLog, offset int64) {
@@ -66,6 +66,14 @@ func (t *TickMaker) Start() {
 	go t.flusher()
 }
 
+func (t *TickMaker) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
 func (t *TickMaker) OnOpenLog(log *matching.OpenLog, offset int64) {
 	// do nothing
 }
@@ -74,6 +82,30 @@ func (t *TickMaker) OnDoneLog(log *matching.DoneLog, offset int64) {
 	// do nothing
 }
 
+func (t *TickMaker) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (t *TickMaker) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
 func (t *TickMaker) OnMatchLog(log *matching.MatchLog, offset int64) {
 	for _, granularity := range minutes {
 		tickTime := log.Time.UTC().Truncate(time.Duration(granularity) * time.Minute).Unix()
@@ -57,6 +57,14 @@ func (t *TradeMaker) Start() {
 	go t.runFlusher()
 }
 
+func (t *TradeMaker) OnReceivedLog(log *matching.ReceivedLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnRejectLog(log *matching.RejectLog, offset int64) {
+	// do nothing
+}
+
 func (t *TradeMaker) OnOpenLog(log *matching.OpenLog, offset int64) {
 	// do nothing
 }
@@ -65,6 +73,30 @@ func (t *TradeMaker) OnDoneLog(log *matching.DoneLog, offset int64) {
 	// do nothing
 }
 
+func (t *TradeMaker) OnActivateLog(log *matching.ActivateLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnChangeLog(log *matching.ChangeLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnStatusLog(log *matching.StatusLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnAuctionLog(log *matching.AuctionLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnBreakerLog(log *matching.BreakerLog, offset int64) {
+	// do nothing
+}
+
+func (t *TradeMaker) OnTrailLog(log *matching.TrailLog, offset int64) {
+	// do nothing
+}
+
 func (t *TradeMaker) OnMatchLog(log *matching.MatchLog, offset int64) {
 	t.tradeCh <- &models.Trade{
 		Id:           log.TradeId,